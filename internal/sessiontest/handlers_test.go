@@ -19,6 +19,16 @@ type TestClientHandler struct {
 	storage string
 }
 
+// MeasuringClientHandler is a TestClientHandler that receives the measurements of sessions.
+type MeasuringClientHandler struct {
+	*TestClientHandler
+	results chan *irma.SessionMeasurementResult
+}
+
+func (i *MeasuringClientHandler) SessionMeasured(result *irma.SessionMeasurementResult) {
+	i.results <- result
+}
+
 func (i *TestClientHandler) UpdateConfiguration(new *irma.IrmaIdentifierSet) {}
 func (i *TestClientHandler) UpdateAttributes()                               {}
 func (i *TestClientHandler) Revoked(cred *irma.CredentialIdentifier) {
@@ -173,9 +173,46 @@ func TestRequestorDisclosureSession(t *testing.T) {
 		require.Len(t, serverResult.Disclosed, 1)
 		require.Equal(t, id, serverResult.Disclosed[0][0].Identifier)
 		require.Equal(t, "456", serverResult.Disclosed[0][0].Value["en"])
+
+		_, verified := serverResult.Timings["postDisclosure"].Get("verify")
+		require.True(t, verified)
+		require.Contains(t, serverResult.Timings, "getRequest")
 	}
 }
 
+func TestRequestorBinarySession(t *testing.T) {
+	client, handler := parseStorage(t)
+	defer test.ClearTestStorage(t, handler.storage)
+	condition := irma.MeasurementCondition{Network: irma.NetworkDirect, Scheme: irma.SchemeHTTP}
+
+	// Issuance sessions send commitments, and receive the signatures, CBOR-encoded
+	client.BinaryMessages = true
+	client.MeasurementType = irma.MeasurementType(irma.MeasurementSubject(irma.ActionIssuing), condition)
+	testRequestorIssuance(t, false, client)
+
+	// Perform the same disclosure session with JSON and with CBOR messages
+	id := irma.NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID")
+	client.MeasurementType = irma.MeasurementType(irma.MeasurementSubject(irma.ActionDisclosing), condition)
+	for _, binary := range []bool{false, true} {
+		client.BinaryMessages = binary
+		result := requestorSessionHelper(t, irma.NewDisclosureRequest(id), client)
+		require.Nil(t, result.Err)
+		require.Equal(t, irma.ProofStatusValid, result.ProofStatus)
+	}
+
+	traffic, err := client.Measurements.TrafficRecords()
+	require.NoError(t, err)
+	require.Len(t, traffic, 3)
+	require.Equal(t, irma.EncodingCBOR, traffic[0].Encoding)
+	require.Contains(t, traffic[0].Messages, irma.MessageCommitments)
+	jsonTraffic, cborTraffic := traffic[1], traffic[2]
+	require.Empty(t, jsonTraffic.Encoding)
+	require.Equal(t, irma.EncodingCBOR, cborTraffic.Encoding)
+	require.Equal(t, 1, cborTraffic.Messages[irma.MessageProofs].Requests)
+	require.True(t, cborTraffic.Messages[irma.MessageProofs].Sent < jsonTraffic.Messages[irma.MessageProofs].Sent)
+	require.NotZero(t, cborTraffic.Messages[irma.MessageSessionRequest].Received)
+}
+
 func TestRequestorDisclosureMultipleAttrs(t *testing.T) {
 	request := irma.NewDisclosureRequest(
 		irma.NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID"),
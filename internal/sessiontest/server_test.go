@@ -78,6 +78,7 @@ func StartIrmaServer(t *testing.T, updatedIrmaConf bool, storage string) {
 		SchemesPath:           path,
 		SchemesAssetsPath:     assets,
 		IssuerPrivateKeysPath: filepath.Join(testdata, "privatekeys"),
+		EnableServerTiming:    true,
 		RevocationSettings: irma.RevocationSettings{
 			revocationTestCred:  {RevocationServerURL: "http://localhost:48683", SSE: true},
 			revKeyshareTestCred: {RevocationServerURL: "http://localhost:48683"},
@@ -108,6 +109,7 @@ var IrmaServerConfiguration = &requestorserver.Configuration{
 		DisableSchemesUpdate:  true,
 		SchemesPath:           filepath.Join(testdata, "irma_configuration"),
 		IssuerPrivateKeysPath: filepath.Join(testdata, "privatekeys"),
+		EnableServerTiming:    true,
 		RevocationSettings: irma.RevocationSettings{
 			revocationTestCred:  {RevocationServerURL: "http://localhost:48683"},
 			revKeyshareTestCred: {RevocationServerURL: "http://localhost:48683"},
@@ -125,6 +127,7 @@ var JwtServerConfiguration = &requestorserver.Configuration{
 		DisableSchemesUpdate:  true,
 		SchemesPath:           filepath.Join(testdata, "irma_configuration"),
 		IssuerPrivateKeysPath: filepath.Join(testdata, "privatekeys"),
+		EnableServerTiming:    true,
 		RevocationSettings: irma.RevocationSettings{
 			revocationTestCred:  {RevocationServerURL: "http://localhost:48683"},
 			revKeyshareTestCred: {RevocationServerURL: "http://localhost:48683"},
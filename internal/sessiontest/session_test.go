@@ -5,9 +5,12 @@ import (
 	"context"
 	"encoding/json"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -15,6 +18,8 @@ import (
 	"github.com/markuskreukniet/irmago-measurements/internal/test"
 	"github.com/markuskreukniet/irmago-measurements/irmaclient"
 	"github.com/markuskreukniet/irmago-measurements/server"
+	"github.com/markuskreukniet/irmago-measurements/server/irmaserver"
+	"github.com/markuskreukniet/irmago-measurements/server/requestorserver"
 	"github.com/stretchr/testify/require"
 )
 
@@ -494,6 +499,220 @@ func TestIssuedCredentialIsStored(t *testing.T) {
 	sessionHelper(t, getDisclosureRequest(id), "verification", client)
 }
 
+func TestRequestorServerMetrics(t *testing.T) {
+	serverConf := *IrmaServerConfiguration.Configuration
+	conf := *IrmaServerConfiguration
+	conf.Configuration = &serverConf
+	conf.EnableMetrics = true
+	conf.MetricsToken = "metricstoken"
+	conf.Permissions = requestorserver.Permissions{Disclosing: []string{"*"}}
+	StartRequestorServer(&conf)
+	defer StopRequestorServer()
+
+	client, handler := parseStorage(t)
+	defer test.ClearTestStorage(t, handler.storage)
+	id := irma.NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID")
+	request := getDisclosureRequest(id)
+	var sesPkg server.SessionPackage
+	require.NoError(t, irma.NewHTTPTransport("http://localhost:48682", false).Post("session", &sesPkg, request))
+	c := make(chan *SessionResult)
+	h := &TestHandler{t: t, c: c, client: client, expectedServerName: expectedServerName(t, request, client.Configuration)}
+	qrjson, err := json.Marshal(sesPkg.SessionPtr)
+	require.NoError(t, err)
+	client.NewSession(string(qrjson), h)
+	if result := <-c; result != nil {
+		require.NoError(t, result.Err)
+	}
+
+	res, err := http.Get("http://localhost:48682/metrics")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+	_ = res.Body.Close()
+
+	req, err := http.NewRequest(http.MethodGet, "http://localhost:48682/metrics", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "metricstoken")
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, server.OpenMetricsContentType, res.Header.Get("Content-Type"))
+	bts, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	_ = res.Body.Close()
+
+	metrics := string(bts)
+	require.Contains(t, metrics, `irma_request_duration_seconds_count{route="/session/",method="POST",action=""} 1`)
+	require.Contains(t, metrics, `irma_request_duration_seconds_count{route="/irma/session/{token}/proofs",method="POST",action="disclosing"} 1`)
+	require.Contains(t, metrics, `irma_sessions_active{action="disclosing",status="DONE"} 1`)
+	require.Contains(t, metrics, `irma_sessions_finished_total{action="disclosing",status="DONE"} 1`)
+	require.Contains(t, metrics, "irma_scheme_update_failures_total 0")
+	require.True(t, strings.HasSuffix(metrics, "# EOF\n"))
+}
+
+func TestServerTimingDisabled(t *testing.T) {
+	serverConf := *IrmaServerConfiguration.Configuration
+	serverConf.EnableServerTiming = false
+	conf := *IrmaServerConfiguration
+	conf.Configuration = &serverConf
+	conf.Permissions = requestorserver.Permissions{Disclosing: []string{"*"}}
+	StartRequestorServer(&conf)
+	defer StopRequestorServer()
+
+	client, handler := parseStorage(t)
+	defer test.ClearTestStorage(t, handler.storage)
+	condition := irma.MeasurementCondition{Network: irma.NetworkDirect, Scheme: irma.SchemeHTTP}
+	client.MeasurementType = irma.MeasurementType(irma.MeasurementSubject(irma.ActionDisclosing), condition)
+
+	id := irma.NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID")
+	request := getDisclosureRequest(id)
+	var sesPkg server.SessionPackage
+	transport := irma.NewHTTPTransport("http://localhost:48682", false)
+	require.NoError(t, transport.Post("session", &sesPkg, request))
+	c := make(chan *SessionResult)
+	h := &TestHandler{t: t, c: c, client: client, expectedServerName: expectedServerName(t, request, client.Configuration)}
+	qrjson, err := json.Marshal(sesPkg.SessionPtr)
+	require.NoError(t, err)
+	client.NewSession(string(qrjson), h)
+	if result := <-c; result != nil {
+		require.NoError(t, result.Err)
+	}
+
+	// Neither the client nor the requestor learn how long the server took
+	records, err := client.Measurements.Records()
+	require.NoError(t, err)
+	require.NotEmpty(t, records)
+	for _, record := range records {
+		require.Zero(t, record.ServerDuration)
+	}
+	var result server.SessionResult
+	require.NoError(t, transport.Get("session/"+sesPkg.Token+"/result", &result))
+	require.Equal(t, server.StatusDone, result.Status)
+	require.Nil(t, result.Timings)
+
+	// and it cannot be enabled in production mode
+	serverConf.EnableServerTiming = true
+	serverConf.Production = true
+	_, err = irmaserver.New(&serverConf)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "server_timing")
+}
+
+func TestRequestorServerNetworkShape(t *testing.T) {
+	serverConf := *IrmaServerConfiguration.Configuration
+	conf := *IrmaServerConfiguration
+	conf.Configuration = &serverConf
+	conf.NetworkShape = "latency=10ms,jitter=2ms"
+	conf.Permissions = requestorserver.Permissions{Disclosing: []string{"*"}}
+	StartRequestorServer(&conf)
+	defer StopRequestorServer()
+
+	client, handler := parseStorage(t)
+	defer test.ClearTestStorage(t, handler.storage)
+	shape, err := irma.ParseNetworkShape("latency=10ms")
+	require.NoError(t, err)
+	client.NetworkShape = shape
+	condition := irma.MeasurementCondition{Network: irma.NetworkDirect, Scheme: irma.SchemeHTTP}
+	client.MeasurementType = irma.MeasurementType(irma.MeasurementSubject(irma.ActionDisclosing), condition)
+
+	id := irma.NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID")
+	request := getDisclosureRequest(id)
+	var sesPkg server.SessionPackage
+	require.NoError(t, irma.NewHTTPTransport("http://localhost:48682", false).Post("session", &sesPkg, request))
+	c := make(chan *SessionResult)
+	h := &TestHandler{t: t, c: c, client: client, expectedServerName: expectedServerName(t, request, client.Configuration)}
+	qrjson, err := json.Marshal(sesPkg.SessionPtr)
+	require.NoError(t, err)
+	client.NewSession(string(qrjson), h)
+	if result := <-c; result != nil {
+		require.NoError(t, result.Err)
+	}
+
+	// Both the client and the server delay the data in both directions
+	records, err := client.Measurements.Records()
+	require.NoError(t, err)
+	require.NotEmpty(t, records)
+	for _, record := range records {
+		require.Equal(t, "latency=10ms", record.Shape)
+		if record.Operation == irma.OperationDisclosureNewSession {
+			require.True(t, record.Duration >= 30*time.Millisecond, "round trip took only %s", record.Duration)
+		}
+	}
+}
+
+func TestFailedSessionMeasurements(t *testing.T) {
+	client, handler := parseStorage(t)
+	defer test.ClearTestStorage(t, handler.storage)
+	condition := irma.MeasurementCondition{Network: irma.NetworkDirect, Scheme: irma.SchemeHTTP}
+	client.MeasurementType = irma.MeasurementType(irma.MeasurementSubject(irma.ActionDisclosing), condition)
+
+	// No server listens at the URL of the session, so it fails while getting the session request
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, listener.Close())
+	qr := &irma.Qr{Type: irma.ActionDisclosing, URL: "http://" + listener.Addr().String() + "/irma/session/token"}
+	c := make(chan *SessionResult, 1)
+	qrjson, err := json.Marshal(qr)
+	require.NoError(t, err)
+	client.NewSession(string(qrjson), &TestHandler{t: t, c: c, client: client})
+	result := <-c
+	require.Error(t, result.Err)
+	require.Equal(t, irma.ErrorTransport, result.Err.(*irma.SessionError).ErrorType)
+
+	records, err := client.Measurements.Records()
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	require.Equal(t, irma.OperationDisclosureNewSession, records[0].Operation)
+	require.Equal(t, irma.OutcomeTransportError, records[0].Outcome)
+	require.Equal(t, irma.ErrorTransport, records[0].ErrorType)
+	require.Equal(t, "token", records[0].SessionToken)
+	require.True(t, records[0].Failed)
+	require.NotZero(t, records[0].Duration)
+}
+
+func TestMeasurementHandler(t *testing.T) {
+	storage := test.SetupTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+	handler := &MeasuringClientHandler{
+		TestClientHandler: &TestClientHandler{t: t, c: make(chan error), storage: storage},
+		results:           make(chan *irma.SessionMeasurementResult, 2),
+	}
+	client, err := irmaclient.New(
+		filepath.Join(storage, "client"),
+		filepath.Join(test.FindTestdataFolder(t), "irma_configuration"),
+		handler,
+	)
+	require.NoError(t, err)
+	client.SetPreferences(irmaclient.Preferences{DeveloperMode: true})
+	condition := irma.MeasurementCondition{Network: irma.NetworkDirect, Scheme: irma.SchemeHTTP}
+	client.MeasurementType = irma.MeasurementType(irma.MeasurementSubject(irma.ActionDisclosing), condition)
+
+	id := irma.NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID")
+	sessionHelper(t, getDisclosureRequest(id), "verification", client)
+
+	result := <-handler.results
+	require.Equal(t, irma.ActionDisclosing, result.Action)
+	require.Equal(t, condition, result.MeasurementCondition)
+	require.Equal(t, irma.OutcomeSuccess, result.Outcome)
+	require.Equal(t, 1, result.DisclosedAttrs)
+	require.NotEmpty(t, result.RunID)
+	require.Equal(t, irma.OperationDisclosureNewSession, result.Records[0].Operation)
+	last := result.Records[len(result.Records)-1]
+	require.Equal(t, irma.OperationDisclosureRespondPermission, last.Operation)
+	require.Equal(t, result.RunID, last.RunID)
+	require.NotNil(t, result.Traffic)
+
+	// the result can be passed to the app as JSON
+	bts, err := json.Marshal(result)
+	require.NoError(t, err)
+	var decoded irma.SessionMeasurementResult
+	require.NoError(t, json.Unmarshal(bts, &decoded))
+	require.Equal(t, last.Duration, decoded.Records[len(decoded.Records)-1].Duration)
+
+	// the legacy text file is not written for handlers receiving the measurements
+	require.False(t, client.Measurements.FlutterFile)
+	_, err = os.Stat(filepath.Join(storage, "client", "latestMeasurementsFlutter.txt"))
+	require.True(t, os.IsNotExist(err))
+}
+
 func TestPOSTSizeLimit(t *testing.T) {
 	StartRequestorServer(IrmaServerConfiguration)
 	defer StopRequestorServer()
@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-errors/errors"
+	irma "github.com/markuskreukniet/irmago-measurements"
+	"github.com/markuskreukniet/irmago-measurements/irmaclient"
+)
+
+// measureClientHandler is the irmaclient.ClientHandler of the client used by irma measure, which
+// only logs what happens to the client.
+type measureClientHandler struct{}
+
+func (measureClientHandler) UpdateConfiguration(new *irma.IrmaIdentifierSet) {}
+func (measureClientHandler) UpdateAttributes()                               {}
+func (measureClientHandler) Revoked(cred *irma.CredentialIdentifier) {
+	logger.Warn("Credential revoked: ", cred.Type)
+}
+func (measureClientHandler) ReportError(err error) {
+	logger.Error("Client error: ", err)
+}
+func (measureClientHandler) EnrollmentFailure(manager irma.SchemeManagerIdentifier, err error) {
+	logger.Errorf("Enrollment at keyshare server of %s failed: %s", manager, err)
+}
+func (measureClientHandler) EnrollmentSuccess(manager irma.SchemeManagerIdentifier)           {}
+func (measureClientHandler) ChangePinFailure(manager irma.SchemeManagerIdentifier, err error) {}
+func (measureClientHandler) ChangePinSuccess(manager irma.SchemeManagerIdentifier)            {}
+func (measureClientHandler) ChangePinIncorrect(manager irma.SchemeManagerIdentifier, attempts int) {
+}
+func (measureClientHandler) ChangePinBlocked(manager irma.SchemeManagerIdentifier, timeout int) {}
+
+// measureSessionHandler is the irmaclient.Handler of the sessions run by irma measure, which
+// accepts everything that is asked, choosing the first attributes that satisfy the request.
+type measureSessionHandler struct {
+	pin  string
+	done chan error
+	// refusal is the reason for refusing the session, reported when the client has cancelled it
+	refusal error
+	// sessionID is the session ID of the measurements of the session, if it is measured
+	sessionID string
+}
+
+var errSessionCancelled = errors.New("session cancelled")
+
+func newMeasureSessionHandler(pin string) *measureSessionHandler {
+	return &measureSessionHandler{pin: pin, done: make(chan error, 1)}
+}
+
+// run performs the session of the QR using the client, and waits until it finishes or times out.
+func (h *measureSessionHandler) run(client *irmaclient.Client, qr *irma.Qr, timeout time.Duration) error {
+	qrjson, err := json.Marshal(qr)
+	if err != nil {
+		return err
+	}
+	dismisser := client.NewSession(string(qrjson), h)
+	if measured, ok := dismisser.(irmaclient.MeasuredSession); ok {
+		h.sessionID = measured.MeasurementSessionID()
+	}
+	select {
+	case err = <-h.done:
+		return err
+	case <-time.After(timeout):
+		err := &irma.SessionError{
+			ErrorType: irma.ErrorTimeout,
+			Err:       errors.WrapPrefix(context.DeadlineExceeded, fmt.Sprintf("session did not finish within %s", timeout), 0),
+		}
+		if aborter, ok := dismisser.(irmaclient.SessionAborter); ok {
+			aborter.Abort(err)
+		} else if dismisser != nil {
+			dismisser.Dismiss()
+		}
+		return err
+	}
+}
+
+// finish reports the result of the session; only the first result is kept.
+func (h *measureSessionHandler) finish(err error) {
+	select {
+	case h.done <- err:
+	default:
+	}
+}
+
+func (h *measureSessionHandler) StatusUpdate(action irma.Action, status irma.Status) {}
+func (h *measureSessionHandler) ClientReturnURLSet(clientReturnURL string)           {}
+func (h *measureSessionHandler) Success(result string) {
+	h.finish(nil)
+}
+func (h *measureSessionHandler) Cancelled() {
+	if h.refusal != nil {
+		h.finish(h.refusal)
+		return
+	}
+	h.finish(errSessionCancelled)
+}
+func (h *measureSessionHandler) Failure(err *irma.SessionError) {
+	h.finish(err)
+}
+func (h *measureSessionHandler) KeyshareBlocked(manager irma.SchemeManagerIdentifier, duration int) {
+	h.finish(errors.Errorf("keyshare account at %s blocked for %d seconds", manager, duration))
+}
+func (h *measureSessionHandler) KeyshareEnrollmentIncomplete(manager irma.SchemeManagerIdentifier) {
+	h.finish(errors.Errorf("keyshare enrollment at %s incomplete", manager))
+}
+func (h *measureSessionHandler) KeyshareEnrollmentMissing(manager irma.SchemeManagerIdentifier) {
+	h.finish(errors.Errorf("not enrolled at keyshare server of %s", manager))
+}
+func (h *measureSessionHandler) KeyshareEnrollmentDeleted(manager irma.SchemeManagerIdentifier) {
+	h.finish(errors.Errorf("keyshare enrollment at %s deleted", manager))
+}
+
+func (h *measureSessionHandler) RequestIssuancePermission(request *irma.IssuanceRequest,
+	satisfiable bool,
+	candidates [][]irmaclient.DisclosureCandidates,
+	ServerName irma.TranslatedString,
+	callback irmaclient.PermissionHandler) {
+	h.givePermission(satisfiable, candidates, callback)
+}
+func (h *measureSessionHandler) RequestVerificationPermission(request *irma.DisclosureRequest,
+	satisfiable bool,
+	candidates [][]irmaclient.DisclosureCandidates,
+	ServerName irma.TranslatedString,
+	callback irmaclient.PermissionHandler) {
+	h.givePermission(satisfiable, candidates, callback)
+}
+func (h *measureSessionHandler) RequestSignaturePermission(request *irma.SignatureRequest,
+	satisfiable bool,
+	candidates [][]irmaclient.DisclosureCandidates,
+	ServerName irma.TranslatedString,
+	callback irmaclient.PermissionHandler) {
+	h.givePermission(satisfiable, candidates, callback)
+}
+func (h *measureSessionHandler) RequestSchemeManagerPermission(manager *irma.SchemeManager, callback func(proceed bool)) {
+	callback(true)
+}
+func (h *measureSessionHandler) RequestPin(remainingAttempts int, callback irmaclient.PinHandler) {
+	callback(true, h.pin)
+}
+
+func (h *measureSessionHandler) givePermission(
+	satisfiable bool, candidates [][]irmaclient.DisclosureCandidates, callback irmaclient.PermissionHandler,
+) {
+	if !satisfiable {
+		h.refuse(errors.New("client does not have the requested attributes; issue them first"), callback)
+		return
+	}
+	choice := &irma.DisclosureChoice{}
+	for _, discon := range candidates {
+		var ids []*irma.AttributeIdentifier
+		var err error
+		for _, con := range discon {
+			if ids, err = con.Choose(); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			h.finish(err)
+			callback(false, nil)
+			return
+		}
+		choice.Attributes = append(choice.Attributes, ids)
+	}
+	callback(true, choice)
+}
+
+// refuse cancels the session, which finishes once the client has informed the server.
+func (h *measureSessionHandler) refuse(err error, callback irmaclient.PermissionHandler) {
+	h.refusal = err
+	callback(false, nil)
+}
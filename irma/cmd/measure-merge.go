@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/go-errors/errors"
+	irma "github.com/markuskreukniet/irmago-measurements"
+	"github.com/spf13/cobra"
+)
+
+var measureMergeCmd = &cobra.Command{
+	Use:   "merge <requests.jsonl> <server log>",
+	Short: "Merge client request timings with a server log into a timeline per session",
+	Long: `Merge the request timings measured by clients (the requests.jsonl file of a measurement run)
+with the log of the IRMA server into a timeline per session, containing for each request the times
+at which the client sent it, the server received it, the server responded, and the client received
+the response. From these the one-way network delay and the clock offset of the server are estimated.
+
+The server must log in JSON at trace level, e.g. irma server --log-json -vv, for its log to contain
+the request IDs sent by the clients.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		timelines, err := mergeTimelines(args[0], args[1])
+		if err != nil {
+			die("failed to merge timelines", err)
+		}
+
+		out := os.Stdout
+		if output != "" {
+			if out, err = os.Create(output); err != nil {
+				die("failed to create output file", err)
+			}
+			defer out.Close()
+		}
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		if err = encoder.Encode(timelines); err != nil {
+			die("failed to write timelines", err)
+		}
+	},
+}
+
+func mergeTimelines(clientPath, serverPath string) ([]*irma.SessionTimeline, error) {
+	clientFile, err := os.Open(clientPath)
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "failed to open request timings", 0)
+	}
+	defer clientFile.Close()
+	client, err := irma.ReadRequestTimingRecords(clientFile)
+	if err != nil {
+		return nil, err
+	}
+
+	serverFile, err := os.Open(serverPath)
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "failed to open server log", 0)
+	}
+	defer serverFile.Close()
+	server, err := irma.ParseServerLog(serverFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return irma.MergeTimelines(client, server), nil
+}
+
+func init() {
+	measureCmd.AddCommand(measureMergeCmd)
+
+	measureMergeCmd.Flags().StringP("output", "o", "", "file to write the timelines to (default stdout)")
+}
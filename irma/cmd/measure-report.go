@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/go-errors/errors"
+	irma "github.com/markuskreukniet/irmago-measurements"
+	"github.com/spf13/cobra"
+)
+
+var measureReportCmd = &cobra.Command{
+	Use:   "report <measurements> [<measurements>...]",
+	Short: "Aggregate measurement runs into a report comparing conditions",
+	Long: `Aggregate the measurement records of one or more runs into a report. Each argument is either
+a directory to which measurements were written (e.g. by irma measure), or a measurements.jsonl file.
+
+For each operation the report contains a table with the statistics of its durations under each
+condition (direct or over Tor, HTTP or HTTPS, JSON or CBOR messages, and the emulated network
+shape, if any). Conditions that differ in only network, scheme or encoding are compared by the ratio of their medians, the Mann-Whitney U test and Welch's t-test; their p-values
+are two-sided. Conditions with an emulated network shape are compared against the same condition
+without it, and against Tor if the shape was emulated on the direct network. Durations are in microseconds, and only measurements of successful sessions are
+included; next to them, the success rate shows how often the operation succeeded out of the times
+it was attempted, including in sessions that failed. The report of directories also contains the amount of bytes sent and received per
+session, in total and per type of message, under each condition.
+
+The html format contains, besides the tables, charts of the durations of each operation: their
+cumulative distribution and box plots per condition, and their course over time per run. The charts
+are drawn as inline SVG, so that the file can be viewed without internet access.`,
+	Example: `irma measure report measurements
+irma measure report --format markdown direct/measurements.jsonl tor/measurements.jsonl
+irma measure report --format html --output report.html direct tor`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := cmd.Flags()
+		format, _ := flags.GetString("format")
+		output, _ := flags.GetString("output")
+
+		var records []*irma.MeasurementRecord
+		var traffic []*irma.SessionTrafficRecord
+		for _, path := range args {
+			r, t, err := readMeasurementRecords(path)
+			if err != nil {
+				die("failed to read measurements from "+path, err)
+			}
+			records = append(records, r...)
+			traffic = append(traffic, t...)
+		}
+
+		out := os.Stdout
+		var err error
+		if output != "" {
+			if out, err = os.Create(output); err != nil {
+				die("failed to create output file", err)
+			}
+			defer out.Close()
+		}
+		report := irma.NewMeasurementReport(records)
+		report.AddTraffic(traffic)
+		if err = report.Write(out, format); err != nil {
+			die("failed to write report", err)
+		}
+	},
+}
+
+// readMeasurementRecords reads the records from a measurements directory or file, along with the
+// traffic of the sessions if path is a directory.
+func readMeasurementRecords(path string) ([]*irma.MeasurementRecord, []*irma.SessionTrafficRecord, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.IsDir() {
+		m := irma.NewMeasurements(path)
+		records, err := m.Records()
+		if err != nil {
+			return nil, nil, err
+		}
+		traffic, err := m.TrafficRecords()
+		return records, traffic, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, errors.WrapPrefix(err, "failed to open measurement records", 0)
+	}
+	defer file.Close()
+	records, err := irma.ReadMeasurementRecords(file)
+	return records, nil, err
+}
+
+func init() {
+	measureCmd.AddCommand(measureReportCmd)
+
+	flags := measureReportCmd.Flags()
+	flags.StringP("format", "f", irma.ReportFormatText, "output format: text, markdown, json or html")
+	flags.StringP("output", "o", "", "file to write the report to (default stdout)")
+}
@@ -0,0 +1,331 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-errors/errors"
+	irma "github.com/markuskreukniet/irmago-measurements"
+	"github.com/markuskreukniet/irmago-measurements/internal/common"
+	"github.com/markuskreukniet/irmago-measurements/irmaclient"
+	"github.com/spf13/cobra"
+)
+
+// measureCmd represents the measure command
+var measureCmd = &cobra.Command{
+	Use:   "measure",
+	Short: "Measure the performance of IRMA sessions, and process the results",
+	Long: `Measure the performance of IRMA sessions without the IRMA app
+
+An IRMA client is created on a local storage directory, which performs the specified number of
+sessions, accepting every session and disclosing the first attributes that satisfy the request.
+The sessions are started either at the builtin IRMA server library, or at an external IRMA server
+(specify its URL with --server). The session request is specified as with "irma session".
+
+The measurements taken by the client are written to the output directory: the measurements as
+records (measurements.jsonl and measurements.csv), the timing and size of each request
+(requests.jsonl), the amount of bytes sent and received per session (traffic.jsonl), and the result
+of each session (sessions.jsonl). The manifest (manifest.json) records the versions of irmago, Go,
+Tor and the schemes, the platform, the server, the transport settings and the campaign, along with
+the SHA-256 hashes of the data files, to reproduce and compare runs. Sessions that do not succeed are recorded as well, with their
+outcome (transportError, timeout, rejected, keyshareError, cancelled or error), the type of the
+error, and a measurement of the operation that failed having the time until the failure as duration.
+
+With --binary, the client sends its commitments and proofs CBOR-encoded instead of as JSON, and
+receives the responses to these in CBOR, so that runs with and without --binary can be compared.
+The server must support CBOR-encoded session messages.
+
+With --campaign, the sessions are performed as defined by a campaign: a JSON file such as
+  {
+    "conditions": [{"network": "direct"}, {"network": "tor"}, {"network": "direct", "shape": "tor"}],
+    "samples": 25, "warmUp": 2, "pause": "5s", "order": "latin-square", "seed": 1
+  }
+For each condition (the network, optionally with "encoding": "cbor" and a network "shape"),
+the client first performs the warm-up sessions, which are not measured, and then the specified
+amount of measured sessions. The sessions of the conditions are interleaved in random order
+("random", the default), in rounds ordered by a balanced Latin square ("latin-square"), or not at
+all ("blocked"), with the specified pause between sessions. The progress is stored in
+campaign.json in the output directory, so that running the same campaign again after an
+interruption resumes where it stopped.
+
+With --shape, the client emulates network conditions on its connections to the server instead of
+connecting directly, to compare with measurements over Tor under controlled conditions, e.g. on
+localhost. The shape is either a preset (tor or 3g), or a comma-separated list of the parameters
+latency, jitter, bandwidth (in bytes per second, optionally with suffix k, M or G), stall (the
+probability that data is held back as if it were lost), stall-duration and seed. Latency and jitter
+apply to each direction of the connections.
+
+To measure disclosure or signature sessions, the client must have the attributes to disclose. They
+can be issued to it first by running an issuance session with the same client storage.`,
+	Example: `irma measure --issue irma-demo.MijnOverheid.root=12345 --sessions 1 --client-storage client
+irma measure --disclose irma-demo.MijnOverheid.root.BSN --sessions 25 --client-storage client
+irma measure --server https://example.com --tor --disclose irma-demo.MijnOverheid.root.BSN --client-storage client
+irma measure --binary --disclose irma-demo.MijnOverheid.root.BSN --client-storage client --output cbor
+irma measure --shape latency=250ms,jitter=50ms,bandwidth=250k --disclose irma-demo.MijnOverheid.root.BSN --client-storage client`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := measure(cmd); err != nil {
+			die("Measuring sessions failed", err)
+		}
+	},
+}
+
+// measureStarter starts a session at the requestor, returning its QR.
+type measureStarter func() (*irma.Qr, error)
+
+func measure(cmd *cobra.Command) error {
+	request, irmaconfig, err := configureSession(cmd)
+	if err != nil {
+		return err
+	}
+
+	flags := cmd.Flags()
+	count, _ := flags.GetInt("sessions")
+	output, _ := flags.GetString("output")
+	storage, _ := flags.GetString("client-storage")
+	useTor, _ := flags.GetBool("tor")
+	pin, _ := flags.GetString("pin")
+	timeout, _ := flags.GetDuration("timeout")
+	binary, _ := flags.GetBool("binary")
+	shapeSpec, _ := flags.GetString("shape")
+	campaignPath, _ := flags.GetString("campaign")
+
+	var campaign *irma.MeasurementCampaign
+	if campaignPath != "" {
+		if campaign, err = irma.ReadMeasurementCampaign(campaignPath); err != nil {
+			return err
+		}
+	}
+
+	var shape *irma.NetworkShape
+	if shapeSpec != "" {
+		if useTor {
+			return errors.New("--shape cannot be combined with --tor")
+		}
+		if shape, err = irma.ParseNetworkShape(shapeSpec); err != nil {
+			return err
+		}
+	}
+
+	start, err := measureRequestor(cmd, request, irmaconfig)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if httpServer != nil {
+			_ = httpServer.Close()
+		}
+	}()
+
+	if storage == "" {
+		if storage, err = ioutil.TempDir("", "irma-measure"); err != nil {
+			return err
+		}
+		defer os.RemoveAll(storage)
+	} else if err = common.EnsureDirectoryExists(storage); err != nil {
+		return err
+	}
+	client, err := irmaclient.New(storage, irmaconfig.Path, measureClientHandler{})
+	if err != nil {
+		return errors.WrapPrefix(err, "failed to create client", 0)
+	}
+	defer client.Close()
+	client.UseTor = useTor
+	client.BinaryMessages = binary
+	client.NetworkShape = shape
+	client.Measurements = irma.NewMeasurements(output)
+
+	if campaign != nil {
+		if err = measureCampaign(client, campaign, start, pin, timeout); err != nil {
+			return err
+		}
+		return writeManifest(client)
+	}
+
+	var failed int
+	for i := 0; i < count; i++ {
+		record, err := measureSession(client, start, pin, timeout, nil)
+		if err != nil {
+			return err
+		}
+		record.Index = i
+		if record.Error != "" {
+			failed++
+			logger.Warnf("Session %d/%d failed after %s (%s): %s", i+1, count, record.Duration, record.Outcome, record.Error)
+		} else {
+			logger.Infof("Session %d/%d done in %s", i+1, count, record.Duration)
+		}
+		if err = client.Measurements.AddSessionRun(record); err != nil {
+			return err
+		}
+	}
+
+	logger.Infof("%d of %d sessions succeeded, results written to %s", count-failed, count, output)
+	return writeManifest(client)
+}
+
+// writeManifest writes the manifest of the measurements, including the hash of the data.
+func writeManifest(client *irmaclient.Client) error {
+	path, err := client.Measurements.WriteManifest()
+	if err != nil {
+		return err
+	}
+	logger.Debug("Manifest written to ", path)
+	return nil
+}
+
+// measureCampaign performs the sessions of the campaign that have not been done yet, configuring
+// the client for the condition of each session.
+func measureCampaign(
+	client *irmaclient.Client, campaign *irma.MeasurementCampaign, start measureStarter, pin string, timeout time.Duration,
+) error {
+	var failed int
+	err := client.Measurements.RunCampaign(campaign, func(sample *irma.CampaignSample) (*irma.SessionRunRecord, error) {
+		client.UseTor = sample.Network == irma.NetworkTor
+		client.BinaryMessages = sample.Encoding == irma.EncodingCBOR
+		client.NetworkShape = nil
+		if sample.Shape != "" {
+			var err error
+			if client.NetworkShape, err = irma.ParseNetworkShape(sample.Shape); err != nil {
+				return nil, err
+			}
+		}
+
+		record, err := measureSession(client, start, pin, timeout, sample)
+		if err != nil {
+			return nil, err
+		}
+		done, total, err := client.Measurements.CampaignProgress()
+		if err != nil {
+			return nil, err
+		}
+		kind := "Session"
+		if sample.WarmUp {
+			kind = "Warm-up session"
+		}
+		if record.Error != "" {
+			failed++
+			logger.Warnf("%s %d/%d (%s) failed after %s (%s): %s", kind, done+1, total, record.Label(), record.Duration, record.Outcome, record.Error)
+		} else {
+			logger.Infof("%s %d/%d (%s) done in %s", kind, done+1, total, record.Label(), record.Duration)
+		}
+		return record, nil
+	})
+	if err != nil {
+		return err
+	}
+	logger.Infof("Campaign done (%d sessions of this invocation failed), results written to %s", failed, client.Measurements.Path)
+	return nil
+}
+
+// measureRequestor returns a function starting sessions of the request at the external server
+// specified with --server, or else at the builtin IRMA server.
+func measureRequestor(cmd *cobra.Command, request irma.RequestorRequest, irmaconfig *irma.Configuration) (measureStarter, error) {
+	flags := cmd.Flags()
+	serverurl, _ := flags.GetString("server")
+	if serverurl != "" {
+		authmethod, _ := flags.GetString("authmethod")
+		key, _ := flags.GetString("key")
+		name, _ := flags.GetString("name")
+		return func() (*irma.Qr, error) {
+			qr, _, err := postRequest(serverurl, request, name, authmethod, key)
+			return qr, err
+		}, nil
+	}
+
+	url, _ := flags.GetString("url")
+	port, _ := flags.GetInt("port")
+	privatekeysPath, _ := flags.GetString("privkeys")
+	verbosity, _ := flags.GetCount("verbose")
+	// The builtin server reports the time it spends on each message, which is recorded in the measurements
+	if err := configureSessionServer(url, port, privatekeysPath, irmaconfig, verbosity, true); err != nil {
+		return nil, err
+	}
+	startServer(port)
+	return func() (*irma.Qr, error) {
+		qr, _, err := irmaServer.StartSession(request, nil)
+		return qr, err
+	}, nil
+}
+
+// measureSession starts a session and performs it with the client, and returns its result. An
+// error is returned only if the session could not be started, or if the server does not use the
+// scheme of the campaign sample, if any. Warm-up sessions of campaigns are not measured.
+func measureSession(
+	client *irmaclient.Client, start measureStarter, pin string, timeout time.Duration, sample *irma.CampaignSample,
+) (*irma.SessionRunRecord, error) {
+	qr, err := start()
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "failed to start session", 0)
+	}
+
+	condition := irma.MeasurementCondition{Network: irma.NetworkDirect, Scheme: irma.SchemeHTTP}
+	if client.UseTor {
+		condition.Network = irma.NetworkTor
+	}
+	if strings.HasPrefix(qr.URL, "https://") {
+		condition.Scheme = irma.SchemeHTTPS
+	}
+	if client.BinaryMessages {
+		condition.Encoding = irma.EncodingCBOR
+	}
+	if client.NetworkShape != nil {
+		condition.Shape = client.NetworkShape.String()
+	}
+	if sample != nil && sample.Scheme != "" && sample.Scheme != condition.Scheme {
+		return nil, errors.Errorf("campaign condition %s requires scheme %s, but the server uses %s",
+			sample.Label(), sample.Scheme, condition.Scheme)
+	}
+	client.MeasurementType = irma.MeasurementType(irma.MeasurementSubject(qr.Type), condition)
+	if sample != nil && sample.WarmUp {
+		client.MeasurementType = ""
+	}
+
+	record := &irma.SessionRunRecord{
+		Action:               qr.Type,
+		ServerURL:            qr.URL,
+		SessionToken:         path.Base(qr.URL),
+		Start:                time.Now(),
+		MeasurementCondition: condition,
+	}
+	handler := newMeasureSessionHandler(pin)
+	err = handler.run(client, qr, timeout)
+	record.Duration = time.Since(record.Start)
+	record.SessionID = handler.sessionID
+	record.Outcome, record.ErrorType = irma.MeasurementOutcomeOf(err)
+	if err == errSessionCancelled {
+		record.Outcome = irma.OutcomeCancelled
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	return record, nil
+}
+
+func init() {
+	RootCmd.AddCommand(measureCmd)
+
+	flags := measureCmd.Flags()
+	flags.SortFlags = false
+	flags.IntP("sessions", "n", 25, "amount of sessions to perform")
+	flags.StringP("output", "o", ".", "directory to write the measurements to")
+	flags.String("client-storage", "", "storage directory of the client (default a temporary directory)")
+	flags.Bool("tor", false, "connect to the server over Tor")
+	flags.Bool("binary", false, "send session messages CBOR-encoded instead of as JSON")
+	flags.String("campaign", "", "JSON file defining a campaign of sessions under several conditions (replaces --sessions, --tor, --binary and --shape)")
+	flags.String("shape", "", "emulate network conditions on the connections to the server, e.g. tor or latency=100ms,bandwidth=500k")
+	flags.String("pin", "12345", "PIN of the client at keyshare servers")
+	flags.Duration("timeout", time.Minute, "time after which a session is considered failed")
+	flags.String("server", "", "External IRMA server to post request to (leave blank to use builtin library)")
+	flags.StringP("url", "u", "http://localhost:port", "external URL to which the client connects (when not using --server), \":port\" being replaced by --port value")
+	flags.IntP("port", "p", 48680, "port to listen at (when not using --server)")
+	flags.StringP("request", "r", "", "JSON session request")
+	flags.StringP("privkeys", "k", "", "path to private keys")
+
+	addRequestFlags(flags)
+
+	flags.CountP("verbose", "v", "verbose (repeatable)")
+}
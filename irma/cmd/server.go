@@ -92,6 +92,10 @@ func setFlags(cmd *cobra.Command, production bool) error {
 	flags.StringP("privkeys", "k", "", "path to IRMA private keys")
 	flags.String("static-path", "", "Host files under this path as static files (leave empty to disable)")
 	flags.String("static-prefix", "/", "Host static files under this URL prefix")
+	flags.Bool("metrics", false, "Expose metrics in the OpenMetrics format at /metrics")
+	flags.String("metrics-token", "", "if specified, requests to /metrics must include this token in the Authorization header")
+	flags.Bool("server-timing", false, "send the time spent on each protocol message in the Server-Timing header and in session results, for measurements (not in production mode)")
+	flags.String("network-shape", "", "emulate network conditions on all connections for measurements, e.g. tor or latency=100ms,bandwidth=500k (not in production mode)")
 	flags.StringP("url", "u", defaulturl, "external URL to server to which the IRMA client connects, \":port\" being replaced by --port value")
 	flags.String("revocation-db-type", "", "database type for revocation database (supported: mysql, postgres)")
 	flags.String("revocation-db-str", "", "connection string for revocation database")
@@ -221,6 +225,7 @@ func configureServer(cmd *cobra.Command) error {
 			JwtIssuer:             viper.GetString("jwt-issuer"),
 			JwtPrivateKey:         viper.GetString("jwt-privkey"),
 			JwtPrivateKeyFile:     viper.GetString("jwt-privkey-file"),
+			EnableServerTiming:    viper.GetBool("server-timing"),
 		},
 		Permissions: requestorserver.Permissions{
 			Disclosing: handlePermission("disclose-perms"),
@@ -238,6 +243,9 @@ func configureServer(cmd *cobra.Command) error {
 		MaxRequestAge:                  viper.GetInt("max-request-age"),
 		StaticPath:                     viper.GetString("static-path"),
 		StaticPrefix:                   viper.GetString("static-prefix"),
+		EnableMetrics:                  viper.GetBool("metrics"),
+		MetricsToken:                   viper.GetString("metrics-token"),
+		NetworkShape:                   viper.GetString("network-shape"),
 
 		TlsCertificate:           viper.GetString("tls-cert"),
 		TlsCertificateFile:       viper.GetString("tls-cert-file"),
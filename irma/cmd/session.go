@@ -97,7 +97,7 @@ func libraryRequest(
 	noqr bool,
 	verbosity int,
 ) (*server.SessionResult, error) {
-	if err := configureSessionServer(url, port, privatekeysPath, irmaconfig, verbosity); err != nil {
+	if err := configureSessionServer(url, port, privatekeysPath, irmaconfig, verbosity, false); err != nil {
 		return nil, err
 	}
 	startServer(port)
@@ -208,7 +208,7 @@ func postRequest(serverurl string, request irma.RequestorRequest, name, authmeth
 
 // Configuration functions
 
-func configureSessionServer(url string, port int, privatekeysPath string, irmaconfig *irma.Configuration, verbosity int) error {
+func configureSessionServer(url string, port int, privatekeysPath string, irmaconfig *irma.Configuration, verbosity int, serverTiming bool) error {
 	// Replace "port" in url with actual port
 	replace := "$1:" + strconv.Itoa(port)
 	url = string(regexp.MustCompile("(https?://[^/]*):port").ReplaceAll([]byte(url), []byte(replace)))
@@ -219,6 +219,7 @@ func configureSessionServer(url string, port int, privatekeysPath string, irmaco
 		URL:                  url,
 		DisableSchemesUpdate: true,
 		Verbose:              verbosity,
+		EnableServerTiming:   serverTiming,
 	}
 	if privatekeysPath != "" {
 		config.IssuerPrivateKeysPath = privatekeysPath
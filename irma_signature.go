@@ -7,6 +7,7 @@ import (
 	gobig "math/big"
 
 	"github.com/bwesterb/go-atum"
+	"github.com/fxamacker/cbor"
 	"github.com/privacybydesign/gabi"
 	"github.com/privacybydesign/gabi/big"
 )
@@ -25,6 +26,31 @@ type SignedMessage struct {
 	Timestamp *atum.Timestamp           `json:"timestamp"`
 }
 
+func (sm *SignedMessage) UnmarshalCBOR(data []byte) error {
+	var tmp struct {
+		LDContext string                    `json:"@context"`
+		Signature binaryProofList           `json:"signature"`
+		Indices   DisclosedAttributeIndices `json:"indices"`
+		Nonce     *big.Int                  `json:"nonce"`
+		Context   *big.Int                  `json:"context"`
+		Message   string                    `json:"message"`
+		Timestamp *atum.Timestamp           `json:"timestamp"`
+	}
+	if err := cbor.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+	*sm = SignedMessage{
+		LDContext: tmp.LDContext,
+		Signature: gabi.ProofList(tmp.Signature),
+		Indices:   tmp.Indices,
+		Nonce:     tmp.Nonce,
+		Context:   tmp.Context,
+		Message:   tmp.Message,
+		Timestamp: tmp.Timestamp,
+	}
+	return nil
+}
+
 func (sm *SignedMessage) Version() int {
 	if sm.LDContext == "" {
 		return 1
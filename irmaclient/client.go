@@ -51,14 +51,21 @@ type Client struct {
 	updates          []update
 
 	tor        *tor.Tor
+	torVersion string
 	cancel     func()
 	httpClient *http.Client
 
-	UseTor                       bool
-	MeasurementType              string
-	NewSessionMeasurement        int64
-	KssGetCommitmentsMeasurement int64
-	KssGetProofPsMeasurement     int64
+	UseTor          bool
+	MeasurementType string
+	// BinaryMessages, if set, makes sessions send their commitments and proofs CBOR-encoded instead
+	// of JSON-encoded, and receive the responses to these in CBOR. The server must support this.
+	BinaryMessages bool
+	// NetworkShape, if set and UseTor is not, emulates the specified network conditions on the
+	// connections of sessions to the IRMA server, to compare with measurements over Tor.
+	NetworkShape *irma.NetworkShape
+
+	// Measurements keeps the measurement results; by default in the storage directory of the client
+	Measurements *irma.Measurements
 
 	lookup map[string]*credLookup
 
@@ -119,6 +126,14 @@ type ClientHandler interface {
 	ReportError(err error)
 }
 
+// MeasurementHandler can optionally be implemented by a ClientHandler to receive the measurements
+// of each measured session once they are stored, whether the session succeeded or not. Clients
+// whose handler does not implement it write the measurements of the latest successful session to
+// the legacy latestMeasurementsFlutter.txt file instead.
+type MeasurementHandler interface {
+	SessionMeasured(result *irma.SessionMeasurementResult)
+}
+
 type credLookup struct {
 	id      irma.CredentialTypeIdentifier
 	counter int
@@ -197,6 +212,9 @@ func New(
 	client.fileStorage = fileStorage{storagePath: storagePath, Configuration: client.Configuration}
 
 	client.FileStoragePublic = storagePath // maybe useless
+	client.Measurements = irma.NewMeasurements(storagePath)
+	_, measurementHandler := handler.(MeasurementHandler)
+	client.Measurements.FlutterFile = !measurementHandler
 
 	if client.Preferences, err = client.storage.LoadPreferences(); err != nil {
 		return nil, err
@@ -826,6 +844,13 @@ func (client *Client) groupCredentials(choice *irma.DisclosureChoice) (
 // ProofBuilders constructs a list of proof builders for the specified attribute choice.
 func (client *Client) ProofBuilders(choice *irma.DisclosureChoice, request irma.SessionRequest,
 ) (gabi.ProofBuilderList, irma.DisclosedAttributeIndices, *atum.Timestamp, error) {
+	return client.proofBuilders(choice, request, nil)
+}
+
+func (client *Client) proofBuilders(choice *irma.DisclosureChoice, request irma.SessionRequest, measurements *irma.SessionMeasurements,
+) (gabi.ProofBuilderList, irma.DisclosedAttributeIndices, *atum.Timestamp, error) {
+	defer measurements.Time(irma.OperationClientProofBuilders)()
+
 	todisclose, attributeIndices, err := client.groupCredentials(choice)
 	if err != nil {
 		return nil, nil, nil, err
@@ -860,7 +885,14 @@ func (client *Client) ProofBuilders(choice *irma.DisclosureChoice, request irma.
 			sigs = append(sigs, s)
 			disclosed = append(disclosed, d)
 		}
-		timestamp, err = irma.GetTimestamp(r.Message, sigs, disclosed, client.Configuration)
+		// Over Tor, the timestamp server should not learn our IP address either
+		var httpClient *http.Client
+		if client.UseTor {
+			httpClient = client.httpClient
+		}
+		stop := measurements.Time(irma.OperationSigningTimestamp)
+		timestamp, err = irma.GetTimestampUsing(httpClient, r.Message, sigs, disclosed, client.Configuration)
+		stop()
 		if err != nil {
 			return nil, nil, nil, err
 		}
@@ -871,7 +903,14 @@ func (client *Client) ProofBuilders(choice *irma.DisclosureChoice, request irma.
 
 // Proofs computes disclosure proofs containing the attributes specified by choice.
 func (client *Client) Proofs(choice *irma.DisclosureChoice, request irma.SessionRequest) (*irma.Disclosure, *atum.Timestamp, error) {
-	builders, choices, timestamp, err := client.ProofBuilders(choice, request)
+	return client.proofs(choice, request, nil)
+}
+
+func (client *Client) proofs(choice *irma.DisclosureChoice, request irma.SessionRequest, measurements *irma.SessionMeasurements,
+) (*irma.Disclosure, *atum.Timestamp, error) {
+	defer measurements.Time(irma.OperationClientProofs)()
+
+	builders, choices, timestamp, err := client.proofBuilders(choice, request, measurements)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -893,6 +932,13 @@ func generateIssuerProofNonce() (*big.Int, error) {
 // a nonce against which the issuer's proof of knowledge must verify.
 func (client *Client) IssuanceProofBuilders(request *irma.IssuanceRequest, choice *irma.DisclosureChoice,
 ) (gabi.ProofBuilderList, irma.DisclosedAttributeIndices, *big.Int, error) {
+	return client.issuanceProofBuilders(request, choice, nil)
+}
+
+func (client *Client) issuanceProofBuilders(request *irma.IssuanceRequest, choice *irma.DisclosureChoice, measurements *irma.SessionMeasurements,
+) (gabi.ProofBuilderList, irma.DisclosedAttributeIndices, *big.Int, error) {
+	defer measurements.Time(irma.OperationClientIssuanceProofBuilders)()
+
 	issuerProofNonce, err := generateIssuerProofNonce()
 	if err != nil {
 		return nil, nil, nil, err
@@ -909,7 +955,7 @@ func (client *Client) IssuanceProofBuilders(request *irma.IssuanceRequest, choic
 		builders = append(builders, credBuilder)
 	}
 
-	disclosures, choices, _, err := client.ProofBuilders(choice, request)
+	disclosures, choices, _, err := client.proofBuilders(choice, request, measurements)
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -921,7 +967,14 @@ func (client *Client) IssuanceProofBuilders(request *irma.IssuanceRequest, choic
 // and also returns the credential builders which will become the new credentials upon combination with the issuer's signature.
 func (client *Client) IssueCommitments(request *irma.IssuanceRequest, choice *irma.DisclosureChoice,
 ) (*irma.IssueCommitmentMessage, gabi.ProofBuilderList, error) {
-	builders, choices, issuerProofNonce, err := client.IssuanceProofBuilders(request, choice)
+	return client.issueCommitments(request, choice, nil)
+}
+
+func (client *Client) issueCommitments(request *irma.IssuanceRequest, choice *irma.DisclosureChoice, measurements *irma.SessionMeasurements,
+) (*irma.IssueCommitmentMessage, gabi.ProofBuilderList, error) {
+	defer measurements.Time(irma.OperationClientIssueCommitments)()
+
+	builders, choices, issuerProofNonce, err := client.issuanceProofBuilders(request, choice, measurements)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -937,6 +990,14 @@ func (client *Client) IssueCommitments(request *irma.IssuanceRequest, choice *ir
 // ConstructCredentials constructs and saves new credentials using the specified issuance signature messages
 // and credential builders.
 func (client *Client) ConstructCredentials(msg []*gabi.IssueSignatureMessage, request *irma.IssuanceRequest, builders gabi.ProofBuilderList) error {
+	return client.constructCredentials(msg, request, builders, nil)
+}
+
+func (client *Client) constructCredentials(
+	msg []*gabi.IssueSignatureMessage, request *irma.IssuanceRequest, builders gabi.ProofBuilderList, measurements *irma.SessionMeasurements,
+) error {
+	defer measurements.Time(irma.OperationClientConstructCredentials)()
+
 	if len(msg) > len(builders) {
 		return errors.New("Received unexpected amount of signatures")
 	}
@@ -1057,7 +1118,7 @@ func (client *Client) keyshareEnrollWorker(managerID irma.SchemeManagerIdentifie
 		client: client,
 		pin:    pin,
 		kss:    kss,
-	})
+	}, nil)
 
 	return nil
 }
@@ -53,6 +53,8 @@ type keyshareSession struct {
 	pinCheck         bool
 	preferences      Preferences
 	client           *Client
+	measurements     *irma.SessionMeasurements
+	roundStart       time.Time // start of the communication with the keyshare servers
 }
 
 type keyshareServer struct {
@@ -161,6 +163,7 @@ func startKeyshareSession(
 	keyshareServers map[irma.SchemeManagerIdentifier]*keyshareServer,
 	preferences Preferences,
 	client *Client,
+	measurements *irma.SessionMeasurements,
 	httpClients ...*http.Client,
 ) {
 	ksscount := 0
@@ -193,6 +196,7 @@ func startKeyshareSession(
 		pinCheck:         false,
 		preferences:      preferences,
 		client:           client,
+		measurements:     measurements,
 	}
 
 	for managerID := range session.Identifiers().SchemeManagers {
@@ -214,6 +218,9 @@ func startKeyshareSession(
 		transport.SetHeader(kssUsernameHeader, ks.keyshareServer.Username)
 		transport.SetHeader(kssAuthHeader, "Bearer "+ks.keyshareServer.token)
 		transport.SetHeader(kssVersionHeader, "2")
+		if ks.measurements != nil {
+			transport.TimingSink = ks.measurements
+		}
 		ks.transports[managerID] = transport
 
 		// Try to parse token as a jwt to see if it is still valid; if so we don't need to ask for the PIN
@@ -355,6 +362,7 @@ func (ks *keyshareSession) verifyPinAttempt(pin string) (
 // of all keyshare servers of their part of the private key, and merges these commitments
 // in our own proof builders.
 func (ks *keyshareSession) GetCommitments() {
+	ks.roundStart = time.Now()
 	pkids := map[irma.SchemeManagerIdentifier][]*publicKeyIdentifier{}
 	commitments := map[publicKeyIdentifier]*gabi.ProofPCommitment{}
 
@@ -382,7 +390,9 @@ func (ks *keyshareSession) GetCommitments() {
 		transport := ks.transports[managerID]
 		comms := &proofPCommitmentMap{}
 
-		irma.StopProgramWhenNeeded(ks.client.UseTor, ks.client.httpClient)
+		if !ks.checkTorConnection(managerID) {
+			return
+		}
 
 		timeStart := time.Now()
 
@@ -390,7 +400,9 @@ func (ks *keyshareSession) GetCommitments() {
 
 		timeEnd := time.Now()
 
-		irma.StopProgramWhenNeeded(ks.client.UseTor, ks.client.httpClient)
+		if !ks.checkTorConnection(managerID) {
+			return
+		}
 
 		if err != nil {
 			if err.(*irma.SessionError).RemoteError != nil &&
@@ -405,8 +417,7 @@ func (ks *keyshareSession) GetCommitments() {
 			ks.sessionHandler.KeyshareError(&managerID, err)
 			return
 		} else {
-			difference := timeEnd.Sub(timeStart).Microseconds()
-			ks.client.KssGetCommitmentsMeasurement = difference
+			ks.measurements.AddKeyshare(irma.OperationKssGetCommitments, managerID, timeEnd.Sub(timeStart))
 		}
 		for pki, c := range comms.Commitments {
 			commitments[pki] = c
@@ -427,12 +438,24 @@ func (ks *keyshareSession) GetCommitments() {
 	ks.GetProofPs()
 }
 
+// checkTorConnection aborts the keyshare session and returns false if the client should,
+// but does not, connect over Tor.
+func (ks *keyshareSession) checkTorConnection(managerID irma.SchemeManagerIdentifier) bool {
+	if err := irma.CheckTorConnection(ks.client.UseTor, ks.client.httpClient); err != nil {
+		ks.sessionHandler.KeyshareError(&managerID, err.(*irma.SessionError))
+		return false
+	}
+	return true
+}
+
 // GetProofPs uses the combined commitments of all keyshare servers and ourself
 // to calculate the challenge, which is sent to the keyshare servers in order to
 // receive their responses (2nd and 3rd message in Schnorr zero-knowledge protocol).
 func (ks *keyshareSession) GetProofPs() {
 	_, issig := ks.session.(*irma.SignatureRequest)
+	stopTiming := ks.measurements.Time(irma.OperationClientChallenge)
 	challenge := ks.builders.Challenge(ks.session.Base().GetContext(), ks.session.GetNonce(ks.timestamp), issig)
+	stopTiming()
 
 	// Post the challenge, obtaining JWT's containing the ProofP's
 	responses := map[irma.SchemeManagerIdentifier]string{}
@@ -443,7 +466,9 @@ func (ks *keyshareSession) GetProofPs() {
 		}
 		var j string
 
-		irma.StopProgramWhenNeeded(ks.client.UseTor, ks.client.httpClient)
+		if !ks.checkTorConnection(managerID) {
+			return
+		}
 
 		timeStart := time.Now()
 
@@ -451,18 +476,20 @@ func (ks *keyshareSession) GetProofPs() {
 
 		timeEnd := time.Now()
 
-		irma.StopProgramWhenNeeded(ks.client.UseTor, ks.client.httpClient)
+		if !ks.checkTorConnection(managerID) {
+			return
+		}
 
 		if err != nil {
 			ks.sessionHandler.KeyshareError(&managerID, err)
 			return
 		} else {
-			difference := timeEnd.Sub(timeStart).Microseconds()
-			ks.client.KssGetProofPsMeasurement = difference
+			ks.measurements.AddKeyshare(irma.OperationKssGetProofPs, managerID, timeEnd.Sub(timeStart))
 		}
 
 		responses[managerID] = j
 	}
+	ks.measurements.Add(irma.OperationKssRound, time.Since(ks.roundStart))
 
 	ks.Finish(challenge, responses)
 }
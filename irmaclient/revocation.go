@@ -78,6 +78,12 @@ func (client *Client) initRevocation() {
 // requiring a nonrevocation proof, using the updates included in the request, or the remote
 // revocation server if those do not suffice.
 func (client *Client) NonrevPrepare(request irma.SessionRequest) error {
+	return client.nonrevPrepare(request, nil)
+}
+
+func (client *Client) nonrevPrepare(request irma.SessionRequest, measurements *irma.SessionMeasurements) error {
+	defer measurements.Time(irma.OperationClientNonrevPrepare)()
+
 	base := request.Base()
 	var err error
 	var wg sync.WaitGroup
@@ -92,7 +98,8 @@ func (client *Client) NonrevPrepare(request irma.SessionRequest) error {
 		irma.Logger.WithField("credtype", id).Debug("updating witnesses")
 		wg.Add(1)
 		go func() {
-			if e := client.nonrevUpdate(id, base.Revocation[id].Updates); e != nil {
+			defer measurements.Time(irma.OperationClientNonrevUpdate)()
+			if e := client.nonrevUpdate(id, base.Revocation[id].Updates, measurements); e != nil {
 				err = e // overwrites err from previously finished call, if any
 			}
 			wg.Done()
@@ -105,7 +112,9 @@ func (client *Client) NonrevPrepare(request irma.SessionRequest) error {
 // nonrevUpdate updates all contained instances of the specified type, using the specified
 // updates if present and if they suffice, and contacting the issuer's server to download updates
 // otherwise.
-func (client *Client) nonrevUpdate(id irma.CredentialTypeIdentifier, updates map[uint]*revocation.Update) error {
+func (client *Client) nonrevUpdate(
+	id irma.CredentialTypeIdentifier, updates map[uint]*revocation.Update, measurements *irma.SessionMeasurements,
+) error {
 	lowest := map[uint]uint64{}
 	attrs := client.attrs(id)
 
@@ -139,9 +148,7 @@ func (client *Client) nonrevUpdate(id irma.CredentialTypeIdentifier, updates map
 			u[counter] = update
 		} else {
 			var err error
-			u[counter], err = irma.RevocationClient{Conf: client.Configuration}.
-				FetchUpdateFrom(id, counter, l+1)
-			if err != nil {
+			if u[counter], err = client.nonrevFetchUpdate(id, counter, l+1, measurements); err != nil {
 				return err
 			}
 		}
@@ -149,14 +156,65 @@ func (client *Client) nonrevUpdate(id irma.CredentialTypeIdentifier, updates map
 
 	// Apply the update messages to all instances of the given type and key counter
 	for counter, update := range u {
-		if err := client.nonrevApplyUpdates(id, counter, update); err != nil {
+		if err := client.nonrevApplyUpdates(id, counter, update, measurements); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (client *Client) nonrevApplyUpdates(id irma.CredentialTypeIdentifier, counter uint, update *revocation.Update) error {
+// nonrevFetchUpdate fetches the revocation update starting at the specified index from the
+// revocation server, over Tor if the client uses it.
+func (client *Client) nonrevFetchUpdate(
+	id irma.CredentialTypeIdentifier, counter uint, from uint64, measurements *irma.SessionMeasurements,
+) (*revocation.Update, error) {
+	revocationClient := irma.RevocationClient{Conf: client.Configuration}
+	if client.UseTor && client.httpClient != nil {
+		revocationClient.HTTPClient = client.httpClient
+	}
+	var sink *revocationFetchSink
+	if measurements != nil {
+		sink = &revocationFetchSink{measurements: measurements}
+		revocationClient.TimingSink = sink
+	}
+
+	start := time.Now()
+	update, err := revocationClient.FetchUpdateFrom(id, counter, from)
+	if err != nil {
+		return nil, err
+	}
+	measurements.AddRevocation(irma.OperationRevocationFetchUpdate, id, time.Since(start), sink.received(), len(update.Events))
+	return update, nil
+}
+
+// revocationFetchSink passes the timings of the requests fetching a revocation update on to the
+// measurements of the session, summing the sizes of their responses.
+type revocationFetchSink struct {
+	measurements *irma.SessionMeasurements
+	mutex        sync.Mutex
+	size         int64
+}
+
+func (sink *revocationFetchSink) AddRequestTiming(timing *irma.RequestTiming) {
+	sink.mutex.Lock()
+	sink.size += timing.ResponseSize
+	sink.mutex.Unlock()
+	sink.measurements.AddRequestTiming(timing)
+}
+
+// received returns the amount of bytes received, or 0 if sink is nil.
+func (sink *revocationFetchSink) received() int64 {
+	if sink == nil {
+		return 0
+	}
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	return sink.size
+}
+
+func (client *Client) nonrevApplyUpdates(
+	id irma.CredentialTypeIdentifier, counter uint, update *revocation.Update, measurements *irma.SessionMeasurements,
+) error {
 	client.credMutex.Lock()
 	defer client.credMutex.Unlock()
 
@@ -170,7 +228,16 @@ func (client *Client) nonrevApplyUpdates(id irma.CredentialTypeIdentifier, count
 		if cred.NonRevocationWitness == nil || cred.Pk.Counter != counter {
 			continue
 		}
+		index := cred.NonRevocationWitness.SignedAccumulator.Accumulator.Index
+		start := time.Now()
 		updated, err := cred.nonrevApplyUpdates(update, irma.RevocationKeys{Conf: client.Configuration})
+		if err == nil {
+			var applied int
+			if newIndex := cred.NonRevocationWitness.SignedAccumulator.Accumulator.Index; newIndex > index {
+				applied = int(newIndex - index)
+			}
+			measurements.AddRevocation(irma.OperationRevocationWitnessUpdate, id, time.Since(start), 0, applied)
+		}
 		if updated {
 			save = true
 		}
@@ -208,7 +275,7 @@ func (client *Client) nonrevApplyUpdates(id irma.CredentialTypeIdentifier, count
 }
 
 func (client *Client) NonrevUpdateFromServer(id irma.CredentialTypeIdentifier) error {
-	return client.nonrevUpdate(id, nil)
+	return client.nonrevUpdate(id, nil, nil)
 }
 
 func (client *Client) nonrevPrepareCache(id irma.CredentialTypeIdentifier, index int) error {
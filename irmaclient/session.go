@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"path"
 	"reflect"
 	"runtime/debug"
 	"strings"
@@ -69,6 +70,23 @@ type SessionDismisser interface {
 	Dismiss()
 }
 
+// SessionAborter can abort the current IRMA session with an error, e.g. when it did not finish in
+// time. Unlike a dismissed session, which is measured as cancelled, an aborted session is measured
+// as having failed with the error. The SessionDismissers returned by Client.NewSession implement it.
+type SessionAborter interface {
+	SessionDismisser
+	Abort(err *irma.SessionError)
+}
+
+// MeasuredSession identifies the measurements of an IRMA session, e.g. to relate them to records
+// kept by the caller. The SessionDismissers returned by Client.NewSession implement it.
+type MeasuredSession interface {
+	SessionDismisser
+	// MeasurementSessionID returns the session ID of the measurements of the session, or the
+	// empty string if the session is not measured.
+	MeasurementSessionID() string
+}
+
 type session struct {
 	Action     irma.Action
 	Handler    Handler
@@ -83,6 +101,11 @@ type session struct {
 	done           bool
 	prepRevocation chan error // used when nonrevocation preprocessing is done
 
+	// Guards finishing the session and storing its measurements, which may happen concurrently
+	// when the session is dismissed or aborted while it finishes
+	mutex    sync.Mutex
+	measured bool // whether the measurements of the session have been stored
+
 	// State for issuance sessions
 	issuerProofNonce *big.Int
 	builders         gabi.ProofBuilderList
@@ -90,6 +113,9 @@ type session struct {
 	// State for signature sessions
 	timestamp *atum.Timestamp
 
+	// Measurements of this session; nil if the client does not measure sessions of this type
+	measurements *irma.SessionMeasurements
+
 	// These are empty on manual sessions
 	Hostname  string
 	ServerURL string
@@ -121,22 +147,36 @@ var maxVersion = &irma.ProtocolVersion{Major: 2, Minor: supportedVersions[2][len
 // When the request is not suitable to start an IRMA session from, it calls the Failure method of the specified Handler.
 func (client *Client) NewSession(sessionrequest string, handler Handler) SessionDismisser {
 	var httpClient *http.Client = nil
+	// The type of the session is not known yet, so the Tor measurements are taken separately
+	// and added to those of the session once it is known whether it is measured
+	setup := client.setupMeasurements()
 
 	if client.UseTor {
+		var err error
 		if client.tor == nil {
 			// tor, cancel, httpClient := irma.MakeTorHttpClient("home/markus/measurements/")
-			tor, cancel, httpClientTor := irma.MakeTorHttpClient(client.FileStoragePublic)
+			client.tor, client.cancel, client.httpClient, err = irma.MakeTorHttpClient(client.FileStoragePublic, setup)
+			if err == nil {
+				var versionErr error
+				if client.torVersion, versionErr = irma.TorVersion(client.tor); versionErr != nil {
+					irma.Logger.Warn("Failed to determine Tor version: ", versionErr)
+				}
+			}
 			// defer tor.Close()
 			// defer cancel()
-
-			client.tor = tor
-			client.cancel = cancel
-			client.httpClient = httpClientTor
 		} else {
-			client.cancel, client.httpClient = irma.RenewTorCircuit(client.tor, client.cancel)
+			client.cancel, client.httpClient, err = irma.RenewTorCircuit(client.tor, client.cancel, setup)
+		}
+		if err != nil {
+			serr := &irma.SessionError{ErrorType: irma.ErrorTor, Err: err}
+			client.storeFailedMeasurements(setup, serr)
+			handler.Failure(serr)
+			return nil
 		}
 
 		httpClient = client.httpClient
+	} else if client.NetworkShape != nil {
+		httpClient = client.NetworkShape.HTTPClient()
 	}
 
 	bts := []byte(sessionrequest)
@@ -148,9 +188,9 @@ func (client *Client) NewSession(sessionrequest string, handler Handler) Session
 			return nil
 		}
 		if httpClient != nil {
-			return client.newQrSession(qr, handler, httpClient)
+			return client.newQrSession(qr, handler, setup, httpClient)
 		} else {
-			return client.newQrSession(qr, handler)
+			return client.newQrSession(qr, handler, setup)
 		}
 	}
 
@@ -195,8 +235,9 @@ func (client *Client) newManualSession(request irma.SessionRequest, handler Hand
 	return session
 }
 
-// newQrSession creates and starts a new interactive IRMA session
-func (client *Client) newQrSession(qr *irma.Qr, handler Handler, httpClients ...*http.Client) SessionDismisser {
+// newQrSession creates and starts a new interactive IRMA session. The measurements taken while
+// setting up the connection, if any, are added to those of the session if it is measured.
+func (client *Client) newQrSession(qr *irma.Qr, handler Handler, setup *irma.SessionMeasurements, httpClients ...*http.Client) SessionDismisser {
 	if qr.Type == irma.ActionRedirect {
 		newqr := &irma.Qr{}
 		transport := irma.NewHTTPTransport("", !client.Preferences.DeveloperMode)
@@ -208,7 +249,7 @@ func (client *Client) newQrSession(qr *irma.Qr, handler Handler, httpClients ...
 			handler.Failure(&irma.SessionError{ErrorType: irma.ErrorInvalidRequest, Err: errors.New("infinite static QR recursion")})
 			return nil
 		}
-		return client.newQrSession(newqr, handler)
+		return client.newQrSession(newqr, handler, setup)
 	}
 
 	client.PauseJobs()
@@ -249,24 +290,68 @@ func (client *Client) newQrSession(qr *irma.Qr, handler Handler, httpClients ...
 		return nil
 	}
 
+	session.measurements = client.sessionMeasurements(session.Action)
+	session.measurements.Adopt(setup)
+	if session.measurements != nil {
+		session.transport.TimingSink = session.measurements
+		session.transport.SetHeader(irma.SessionIDHeader, session.measurements.SessionID)
+	}
+
 	session.transport.SetHeader(irma.MinVersionHeader, min.String())
 	session.transport.SetHeader(irma.MaxVersionHeader, maxVersion.String())
 	if !strings.HasSuffix(session.ServerURL, "/") {
 		session.ServerURL += "/"
 	}
 
-	irma.StopProgramWhenNeeded(client.UseTor, client.httpClient)
+	if !session.checkTorConnection() {
+		return nil
+	}
 
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go session.getSessionInfo(&wg)
 	wg.Wait()
 
-	irma.StopProgramWhenNeeded(client.UseTor, client.httpClient)
+	if !session.checkTorConnection() {
+		return nil
+	}
 
 	return session
 }
 
+// sessionMeasurements returns a new measurement context for a session of the specified type,
+// or nil if the client does not measure sessions of this type.
+func (client *Client) sessionMeasurements(action irma.Action) *irma.SessionMeasurements {
+	subject, condition, err := client.measurementCondition()
+	if err != nil || subject != irma.MeasurementSubject(action) {
+		return nil
+	}
+	return irma.NewSessionMeasurements(action, condition)
+}
+
+// setupMeasurements returns a new measurement context for the measurements taken before the type
+// of the session is known, or nil if the client does not measure sessions.
+func (client *Client) setupMeasurements() *irma.SessionMeasurements {
+	_, condition, err := client.measurementCondition()
+	if err != nil {
+		return nil
+	}
+	return irma.NewSessionMeasurements(irma.ActionUnknown, condition)
+}
+
+// measurementCondition returns the subject of the sessions that the client measures and the
+// condition under which it measures them.
+func (client *Client) measurementCondition() (string, irma.MeasurementCondition, error) {
+	subject, condition, err := irma.ParseMeasurementType(client.MeasurementType)
+	if client.BinaryMessages {
+		condition.Encoding = irma.EncodingCBOR
+	}
+	if client.NetworkShape != nil && !client.UseTor {
+		condition.Shape = client.NetworkShape.String()
+	}
+	return subject, condition, err
+}
+
 // Core session methods
 
 // getSessionInfo retrieves the first message in the IRMA protocol (only in interactive sessions)
@@ -275,19 +360,23 @@ func (session *session) getSessionInfo(wg *sync.WaitGroup) {
 
 	session.Handler.StatusUpdate(session.Action, irma.StatusCommunicating)
 
+	newSession, _ := irma.SessionOperations(session.Action)
+	session.measurements.Begin(newSession)
 	timeStart := time.Now()
 
 	// Get the first IRMA protocol message and parse it
 	err := session.transport.Get("", session.request)
 	if err != nil {
 		session.fail(err.(*irma.SessionError))
+		wg.Done()
 		return
 	}
 
 	timeEnd := time.Now()
-	difference := timeEnd.Sub(timeStart).Microseconds()
+	session.measurements.AddRoundTrip(newSession, timeEnd.Sub(timeStart))
 
-	session.client.NewSessionMeasurement = difference
+	// The session request is always JSON-encoded; the messages hereafter may be binary
+	session.transport.Binary = session.client.BinaryMessages
 
 	wg.Done()
 	session.processSessionInfo()
@@ -374,7 +463,7 @@ func (session *session) processSessionInfo() {
 	// if it finishes in time, then credentials that have been revoked can be excluded from the
 	// candidate calculation.
 	go func() {
-		session.prepRevocation <- session.client.NonrevPrepare(session.request)
+		session.prepRevocation <- session.client.nonrevPrepare(session.request, session.measurements)
 	}()
 	select {
 	case err := <-session.prepRevocation:
@@ -438,6 +527,9 @@ func (session *session) doSession(proceed bool, choice *irma.DisclosureChoice) {
 		return
 	}
 	session.choice = choice
+	// Failures while computing the response, e.g. in the keyshare protocol, are attributed to it
+	_, respondPermission := irma.SessionOperations(session.Action)
+	session.measurements.Begin(respondPermission)
 	if err := session.choice.Validate(); err != nil {
 		session.fail(&irma.SessionError{ErrorType: irma.ErrorRequiredAttributeMissing, Err: err})
 		return
@@ -478,6 +570,7 @@ func (session *session) doSession(proceed bool, choice *irma.DisclosureChoice) {
 				session.client.keyshareServers,
 				session.client.Preferences,
 				session.client,
+				session.measurements,
 				httpClient,
 			)
 		} else {
@@ -492,6 +585,7 @@ func (session *session) doSession(proceed bool, choice *irma.DisclosureChoice) {
 				session.client.keyshareServers,
 				session.client.Preferences,
 				session.client,
+				session.measurements,
 			)
 		}
 	}
@@ -521,6 +615,13 @@ func (session *session) sendResponse(message interface{}) {
 		}
 
 		if session.IsInteractive() {
+			if !session.checkTorConnection() {
+				return
+			}
+
+			session.beginRespondPermission()
+			timeStart := time.Now()
+
 			var response disclosureResponse
 			if err = session.transport.Post("proofs", &response, irmaSignature); err != nil {
 				session.fail(err.(*irma.SessionError))
@@ -530,6 +631,16 @@ func (session *session) sendResponse(message interface{}) {
 				session.fail(&irma.SessionError{ErrorType: irma.ErrorRejected, Info: string(response)})
 				return
 			}
+
+			timeEnd := time.Now()
+
+			if !session.checkTorConnection() {
+				return
+			}
+
+			if !session.finishMeasurements(timeEnd.Sub(timeStart)) {
+				return
+			}
 		}
 		log, err = session.createLogEntry(message)
 		if err != nil {
@@ -543,8 +654,11 @@ func (session *session) sendResponse(message interface{}) {
 			return
 		}
 		if session.IsInteractive() {
-			irma.StopProgramWhenNeeded(session.client.UseTor, session.client.httpClient)
+			if !session.checkTorConnection() {
+				return
+			}
 
+			session.beginRespondPermission()
 			timeStart := time.Now()
 
 			var response disclosureResponse
@@ -558,49 +672,13 @@ func (session *session) sendResponse(message interface{}) {
 			}
 
 			timeEnd := time.Now()
-			difference := timeEnd.Sub(timeStart).Microseconds()
-
-			irma.StopProgramWhenNeeded(session.client.UseTor, session.client.httpClient)
-
-			irma.ClearFlutterMeasurements()
 
-			switch session.client.MeasurementType {
-			case "disclosureMeasurement":
-				irma.AddMeasurementResult(irma.DisclosureNewSession, session.client.NewSessionMeasurement)
-				irma.AddMeasurementResult(irma.DisclosureRespondPermission, difference)
+			if !session.checkTorConnection() {
+				return
+			}
 
-				if session.client.KssGetCommitmentsMeasurement != -1 &&
-					session.client.KssGetProofPsMeasurement != -1 {
-					irma.AddMeasurementResult(irma.KssGetCommitments, session.client.KssGetCommitmentsMeasurement)
-					irma.AddMeasurementResult(irma.KssGetProofPs, session.client.KssGetProofPsMeasurement)
-				}
-			case "torDisclosureMeasurement":
-				irma.AddMeasurementResult(irma.TorDisclosureNewSession, session.client.NewSessionMeasurement)
-				irma.AddMeasurementResult(irma.TorDisclosureRespondPermission, difference)
-
-				if session.client.KssGetCommitmentsMeasurement != -1 &&
-					session.client.KssGetProofPsMeasurement != -1 {
-					irma.AddMeasurementResult(irma.TorKssGetCommitments, session.client.KssGetCommitmentsMeasurement)
-					irma.AddMeasurementResult(irma.TorKssGetProofPs, session.client.KssGetProofPsMeasurement)
-				}
-			case "disclosureHttpsMeasurement":
-				irma.AddMeasurementResult(irma.DisclosureHttpsNewSession, session.client.NewSessionMeasurement)
-				irma.AddMeasurementResult(irma.DisclosureHttpsRespondPermission, difference)
-
-				if session.client.KssGetCommitmentsMeasurement != -1 &&
-					session.client.KssGetProofPsMeasurement != -1 {
-					irma.AddMeasurementResult(irma.KssHttpsGetCommitments, session.client.KssGetCommitmentsMeasurement)
-					irma.AddMeasurementResult(irma.KssHttpsGetProofPs, session.client.KssGetProofPsMeasurement)
-				}
-			case "torDisclosureHttpsMeasurement":
-				irma.AddMeasurementResult(irma.TorDisclosureHttpsNewSession, session.client.NewSessionMeasurement)
-				irma.AddMeasurementResult(irma.TorDisclosureHttpsRespondPermission, difference)
-
-				if session.client.KssGetCommitmentsMeasurement != -1 &&
-					session.client.KssGetProofPsMeasurement != -1 {
-					irma.AddMeasurementResult(irma.TorKssHttpsGetCommitments, session.client.KssGetCommitmentsMeasurement)
-					irma.AddMeasurementResult(irma.TorKssHttpsGetProofPs, session.client.KssGetProofPsMeasurement)
-				}
+			if !session.finishMeasurements(timeEnd.Sub(timeStart)) {
+				return
 			}
 		}
 		log, err = session.createLogEntry(message)
@@ -609,8 +687,11 @@ func (session *session) sendResponse(message interface{}) {
 			session.client.reportError(err)
 		}
 	case irma.ActionIssuing:
-		irma.StopProgramWhenNeeded(session.client.UseTor, session.client.httpClient)
+		if !session.checkTorConnection() {
+			return
+		}
 
+		session.beginRespondPermission()
 		timeStart := time.Now()
 
 		response := []*gabi.IssueSignatureMessage{}
@@ -621,54 +702,17 @@ func (session *session) sendResponse(message interface{}) {
 
 		timeEnd := time.Now()
 
-		if err = session.client.ConstructCredentials(response, session.request.(*irma.IssuanceRequest), session.builders); err != nil {
+		if err = session.client.constructCredentials(response, session.request.(*irma.IssuanceRequest), session.builders, session.measurements); err != nil {
 			session.fail(&irma.SessionError{ErrorType: irma.ErrorCrypto, Err: err})
 			return
 		}
 
-		difference := timeEnd.Sub(timeStart).Microseconds()
-
-		irma.StopProgramWhenNeeded(session.client.UseTor, session.client.httpClient)
-
-		irma.ClearFlutterMeasurements()
-
-		switch session.client.MeasurementType {
-		case "issuanceMeasurement":
-			irma.AddMeasurementResult(irma.IssuanceNewSession, session.client.NewSessionMeasurement)
-			irma.AddMeasurementResult(irma.IssuanceRespondPermission, difference)
+		if !session.checkTorConnection() {
+			return
+		}
 
-			if session.client.KssGetCommitmentsMeasurement != -1 &&
-				session.client.KssGetProofPsMeasurement != -1 {
-				irma.AddMeasurementResult(irma.KssGetCommitments, session.client.KssGetCommitmentsMeasurement)
-				irma.AddMeasurementResult(irma.KssGetProofPs, session.client.KssGetProofPsMeasurement)
-			}
-		case "torIssuanceMeasurement":
-			irma.AddMeasurementResult(irma.TorIssuanceNewSession, session.client.NewSessionMeasurement)
-			irma.AddMeasurementResult(irma.TorIssuanceRespondPermission, difference)
-
-			if session.client.KssGetCommitmentsMeasurement != -1 &&
-				session.client.KssGetProofPsMeasurement != -1 {
-				irma.AddMeasurementResult(irma.TorKssGetCommitments, session.client.KssGetCommitmentsMeasurement)
-				irma.AddMeasurementResult(irma.TorKssGetProofPs, session.client.KssGetProofPsMeasurement)
-			}
-		case "issuanceHttpsMeasurement":
-			irma.AddMeasurementResult(irma.IssuanceHttpsNewSession, session.client.NewSessionMeasurement)
-			irma.AddMeasurementResult(irma.IssuanceHttpsRespondPermission, difference)
-
-			if session.client.KssGetCommitmentsMeasurement != -1 &&
-				session.client.KssGetProofPsMeasurement != -1 {
-				irma.AddMeasurementResult(irma.KssHttpsGetCommitments, session.client.KssGetCommitmentsMeasurement)
-				irma.AddMeasurementResult(irma.KssHttpsGetProofPs, session.client.KssGetProofPsMeasurement)
-			}
-		case "torIssuanceHttpsMeasurement":
-			irma.AddMeasurementResult(irma.TorIssuanceHttpsNewSession, session.client.NewSessionMeasurement)
-			irma.AddMeasurementResult(irma.TorIssuanceHttpsRespondPermission, difference)
-
-			if session.client.KssGetCommitmentsMeasurement != -1 &&
-				session.client.KssGetProofPsMeasurement != -1 {
-				irma.AddMeasurementResult(irma.TorKssHttpsGetCommitments, session.client.KssGetCommitmentsMeasurement)
-				irma.AddMeasurementResult(irma.TorKssHttpsGetProofPs, session.client.KssGetProofPsMeasurement)
-			}
+		if !session.finishMeasurements(timeEnd.Sub(timeStart)) {
+			return
 		}
 
 		log, err = session.createLogEntry(message)
@@ -690,6 +734,141 @@ func (session *session) sendResponse(message interface{}) {
 	session.Handler.Success(string(messageJson))
 }
 
+// finishMeasurements adds the duration of the response to the server to the measurements of
+// this session, and stores them. It fails the session and returns false if that does not succeed.
+func (session *session) finishMeasurements(respondPermission time.Duration) bool {
+	if session.measurements == nil {
+		return true
+	}
+	_, op := irma.SessionOperations(session.Action)
+	session.measurements.AddRoundTrip(op, respondPermission)
+	if err := session.storeMeasurements(); err != nil {
+		session.fail(&irma.SessionError{ErrorType: irma.ErrorMeasurement, Err: err})
+		return false
+	}
+	return true
+}
+
+// beginRespondPermission marks the start of the response to the server in the measurements.
+func (session *session) beginRespondPermission() {
+	_, op := irma.SessionOperations(session.Action)
+	session.measurements.Begin(op)
+}
+
+// setMeasurementContext sets the context of the session on its measurements.
+func (session *session) setMeasurementContext() {
+	session.measurements.ServerURL = session.ServerURL
+	session.measurements.Hostname = session.Hostname
+	session.measurements.ProtocolVersion = session.Version
+	session.measurements.Keyshare = session.Distributed()
+	session.measurements.Environment = &irma.MeasurementEnvironment{
+		Schemes:   session.client.Configuration.SchemeVersions(),
+		Transport: session.transport.Settings(),
+	}
+	if session.client.UseTor {
+		session.measurements.Environment.TorVersion = session.client.torVersion
+	}
+	session.measurements.DisclosedAttrs = 0
+	if session.choice != nil {
+		for _, attrs := range session.choice.Attributes {
+			session.measurements.DisclosedAttrs += len(attrs)
+		}
+	}
+	if session.IsInteractive() {
+		session.measurements.SessionToken = path.Base(session.ServerURL)
+	}
+}
+
+// storeMeasurements stores the measurements of this session along with its context, unless they
+// have been stored already, e.g. because the session was aborted while it finished.
+func (session *session) storeMeasurements() error {
+	stored, err := session.addMeasurements()
+	if err != nil || !stored {
+		return err
+	}
+	// Outside of the lock, as the handler may dismiss the session
+	return session.client.reportMeasurements(session.measurements)
+}
+
+func (session *session) addMeasurements() (bool, error) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	if session.measured {
+		return false, nil
+	}
+	session.measured = true
+	session.setMeasurementContext()
+	return true, session.client.Measurements.AddSession(session.measurements)
+}
+
+// reportMeasurements passes the stored measurements of a session to the handler of the client,
+// if it is a MeasurementHandler.
+func (client *Client) reportMeasurements(sm *irma.SessionMeasurements) error {
+	handler, ok := client.handler.(MeasurementHandler)
+	if !ok {
+		return nil
+	}
+	runID, err := client.Measurements.RunID()
+	if err != nil {
+		return err
+	}
+	handler.SessionMeasured(sm.Result(runID))
+	return nil
+}
+
+// finishFailed finishes the session, which did not succeed, and stores its measurements along
+// with its outcome. Like finish, it returns whether the session was not finished already.
+func (session *session) finishFailed(delete bool, outcome irma.MeasurementOutcome, errorType irma.ErrorType) bool {
+	if session.measurements == nil {
+		return session.finish(delete)
+	}
+	// Record the failure before informing the server, so that this is not included in its duration
+	session.mutex.Lock()
+	if !session.done && !session.measured {
+		session.measurements.Fail(outcome, errorType)
+	}
+	session.mutex.Unlock()
+
+	if !session.finish(delete) {
+		return false
+	}
+	if err := session.storeMeasurements(); err != nil {
+		// The session has failed already, so this is only logged
+		irma.Logger.Warn("failed to store measurements of failed session: ", err)
+	}
+	return true
+}
+
+// storeFailedMeasurements stores measurements taken during a session that failed with the
+// specified error.
+func (client *Client) storeFailedMeasurements(sm *irma.SessionMeasurements, err *irma.SessionError) {
+	if sm == nil {
+		return
+	}
+	sm.Fail(irma.MeasurementOutcomeOf(err))
+	sm.Environment = &irma.MeasurementEnvironment{
+		TorVersion: client.torVersion,
+		Schemes:    client.Configuration.SchemeVersions(),
+	}
+	storeErr := client.Measurements.AddSession(sm)
+	if storeErr == nil {
+		storeErr = client.reportMeasurements(sm)
+	}
+	if storeErr != nil {
+		irma.Logger.Warn("failed to store measurements of failed session: ", storeErr)
+	}
+}
+
+// checkTorConnection fails the session and returns false if the client should, but does not,
+// connect over Tor.
+func (session *session) checkTorConnection() bool {
+	if err := irma.CheckTorConnection(session.client.UseTor, session.client.httpClient); err != nil {
+		session.fail(err.(*irma.SessionError))
+		return false
+	}
+	return true
+}
+
 // Response calculation methods
 
 // getBuilders computes the builders for disclosure proofs or secretkey-knowledge proof (in case of disclosure/signing
@@ -702,9 +881,9 @@ func (session *session) getBuilders() (gabi.ProofBuilderList, irma.DisclosedAttr
 
 	switch session.Action {
 	case irma.ActionSigning, irma.ActionDisclosing:
-		builders, choices, session.timestamp, err = session.client.ProofBuilders(session.choice, session.request)
+		builders, choices, session.timestamp, err = session.client.proofBuilders(session.choice, session.request, session.measurements)
 	case irma.ActionIssuing:
-		builders, choices, issuerProofNonce, err = session.client.IssuanceProofBuilders(session.request.(*irma.IssuanceRequest), session.choice)
+		builders, choices, issuerProofNonce, err = session.client.issuanceProofBuilders(session.request.(*irma.IssuanceRequest), session.choice, session.measurements)
 	}
 
 	return builders, choices, issuerProofNonce, err
@@ -718,9 +897,9 @@ func (session *session) getProof() (interface{}, error) {
 
 	switch session.Action {
 	case irma.ActionSigning, irma.ActionDisclosing:
-		message, session.timestamp, err = session.client.Proofs(session.choice, session.request)
+		message, session.timestamp, err = session.client.proofs(session.choice, session.request, session.measurements)
 	case irma.ActionIssuing:
-		message, session.builders, err = session.client.IssueCommitments(session.request.(*irma.IssuanceRequest), session.choice)
+		message, session.builders, err = session.client.issueCommitments(session.request.(*irma.IssuanceRequest), session.choice, session.measurements)
 	}
 
 	return message, err
@@ -735,7 +914,7 @@ func (session *session) checkKeyshareEnrollment() bool {
 		distributed := session.client.Configuration.SchemeManagers[id].Distributed()
 		_, enrolled := session.client.keyshareServers[id]
 		if distributed && !enrolled {
-			session.finish(false)
+			session.finishFailed(false, irma.OutcomeKeyshareError, irma.ErrorKeyshareUnenrolled)
 			session.Handler.KeyshareEnrollmentMissing(id)
 			return false
 		}
@@ -807,9 +986,10 @@ func (session *session) Distributed() bool {
 
 func (session *session) recoverFromPanic() {
 	if e := recover(); e != nil {
-		session.finish(false)
+		err := panicToError(e)
+		session.finishFailed(false, irma.OutcomeError, err.ErrorType)
 		if session.Handler != nil {
-			session.Handler.Failure(panicToError(e))
+			session.Handler.Failure(err)
 		}
 	}
 }
@@ -833,20 +1013,30 @@ func panicToError(e interface{}) *irma.SessionError {
 // background jobs. This function is idempotent, doing nothing when called a second time. It
 // returns whether or not it did something.
 func (session *session) finish(delete bool) bool {
-	if !session.done {
-		if delete && session.IsInteractive() {
-			session.transport.Delete()
-		}
-		session.client.nonrevRepopulateCaches(session.request)
-		session.client.sessions.remove(session.token)
-		session.done = true
-		return true
+	// Only marking the session as done is guarded, so that those waiting for the mutex do not
+	// wait for the DELETE as well
+	session.mutex.Lock()
+	done := session.done
+	session.done = true
+	session.mutex.Unlock()
+	if done {
+		return false
 	}
-	return false
+
+	if delete && session.IsInteractive() {
+		session.transport.Delete()
+	}
+	session.client.nonrevRepopulateCaches(session.request)
+	session.client.sessions.remove(session.token)
+	return true
 }
 
 func (session *session) fail(err *irma.SessionError) {
-	if session.finish(true) && err.ErrorType != irma.ErrorKeyshareUnenrolled {
+	outcome, errorType := irma.MeasurementOutcomeOf(err)
+	if !session.finishFailed(true, outcome, errorType) {
+		return
+	}
+	if err.ErrorType != irma.ErrorKeyshareUnenrolled {
 		irma.Logger.Warn("client session error: ", err.Error())
 		err.Err = errors.Wrap(err.Err, 0)
 		session.Handler.Failure(err)
@@ -854,7 +1044,7 @@ func (session *session) fail(err *irma.SessionError) {
 }
 
 func (session *session) cancel() {
-	if session.finish(true) {
+	if session.finishFailed(true, irma.OutcomeCancelled, "") {
 		session.Handler.Cancelled()
 	}
 }
@@ -863,6 +1053,17 @@ func (session *session) Dismiss() {
 	session.cancel()
 }
 
+func (session *session) Abort(err *irma.SessionError) {
+	session.fail(err)
+}
+
+func (session *session) MeasurementSessionID() string {
+	if session.measurements == nil {
+		return ""
+	}
+	return session.measurements.SessionID
+}
+
 // Keyshare session handler methods
 
 func (session *session) KeyshareDone(message interface{}) {
@@ -887,17 +1088,17 @@ func (session *session) KeyshareCancelled() {
 }
 
 func (session *session) KeyshareEnrollmentIncomplete(manager irma.SchemeManagerIdentifier) {
-	session.finish(false)
+	session.finishFailed(false, irma.OutcomeKeyshareError, irma.ErrorKeyshare)
 	session.Handler.KeyshareEnrollmentIncomplete(manager)
 }
 
 func (session *session) KeyshareEnrollmentDeleted(manager irma.SchemeManagerIdentifier) {
-	session.finish(false)
+	session.finishFailed(false, irma.OutcomeKeyshareError, irma.ErrorKeyshare)
 	session.Handler.KeyshareEnrollmentDeleted(manager)
 }
 
 func (session *session) KeyshareBlocked(manager irma.SchemeManagerIdentifier, duration int) {
-	session.finish(false)
+	session.finishFailed(false, irma.OutcomeKeyshareError, irma.ErrorKeyshare)
 	session.Handler.KeyshareBlocked(manager, duration)
 }
 
@@ -906,7 +1107,7 @@ func (session *session) KeyshareError(manager *irma.SchemeManagerIdentifier, err
 	var ok bool
 	if serr, ok = err.(*irma.SessionError); !ok {
 		serr = &irma.SessionError{ErrorType: irma.ErrorKeyshare, Err: err}
-	} else {
+	} else if serr.ErrorType != irma.ErrorTor {
 		serr.ErrorType = irma.ErrorKeyshare
 	}
 	session.fail(serr)
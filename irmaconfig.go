@@ -11,6 +11,7 @@ import (
 	"regexp"
 	"runtime"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"crypto/sha256"
@@ -70,6 +71,8 @@ type Configuration struct {
 	assets        string
 	readOnly      bool
 
+	updateFailures uint32 // accessed atomically
+
 	options ConfigurationOptions
 }
 
@@ -1310,10 +1313,16 @@ func (conf *Configuration) UpdateSchemes() error {
 	return nil
 }
 
+// SchemeUpdateFailures returns how many times the scheme autoupdater failed to update the schemes.
+func (conf *Configuration) SchemeUpdateFailures() uint64 {
+	return uint64(atomic.LoadUint32(&conf.updateFailures))
+}
+
 func (conf *Configuration) AutoUpdateSchemes(interval uint) {
 	Logger.Infof("Updating schemes every %d minutes", interval)
 	update := func() {
 		if err := conf.UpdateSchemes(); err != nil {
+			atomic.AddUint32(&conf.updateFailures, 1)
 			Logger.Error("Scheme autoupdater failed: ")
 			if e, ok := err.(*errors.Error); ok {
 				Logger.Error(e.ErrorStack())
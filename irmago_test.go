@@ -3,11 +3,14 @@ package irma
 import (
 	"crypto/rand"
 	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"path/filepath"
 	"reflect"
 	"testing"
 	"time"
 
+	"github.com/bwesterb/go-atum"
 	"github.com/markuskreukniet/irmago-measurements/internal/common"
 	"github.com/markuskreukniet/irmago-measurements/internal/test"
 	"github.com/privacybydesign/gabi"
@@ -205,6 +208,29 @@ func TestTimestamp(t *testing.T) {
 	require.Equal(t, time.Time(*timestruct.Time).Unix(), int64(1500000000))
 }
 
+func TestSendTimestampRequest(t *testing.T) {
+	var requests []atum.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req atum.Request
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		requests = append(requests, req)
+		if req.ProofOfWork == nil {
+			_, _ = w.Write([]byte(`{"Error":"proof of work is missing","Info":{"DefaultSigAlg":"ed25519","RequiredProofOfWork":{"ed25519":"sha2bday-1-bm9uY2U"}}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"Stamp":{"Time":1500000000}}`))
+	}))
+	defer server.Close()
+
+	alg := atum.Ed25519
+	timestamp, err := sendTimestampRequest(server.Client(), server.URL, atum.Request{Nonce: []byte("nonce"), PreferredSigAlg: &alg})
+	require.NoError(t, err)
+	require.Equal(t, int64(1500000000), timestamp.Time)
+	require.Len(t, requests, 2)
+	require.NotNil(t, requests[1].Time)
+	require.NotNil(t, requests[1].ProofOfWork)
+}
+
 func TestVerifyValidSig(t *testing.T) {
 	conf := parseConfiguration(t)
 
@@ -253,6 +279,46 @@ func TestEmptySignature(t *testing.T) {
 	require.NotEqual(t, ProofStatusValid, status)
 }
 
+func TestBinaryMessages(t *testing.T) {
+	proofs := gabi.ProofList{
+		&gabi.ProofU{U: big.NewInt(2), C: big.NewInt(3)},
+		&gabi.ProofD{C: big.NewInt(3), A: big.NewInt(5), AResponses: map[int]*big.Int{0: big.NewInt(7)}},
+	}
+	indices := DisclosedAttributeIndices{{{CredentialIndex: 1, AttributeIndex: 2}}}
+
+	commitments := &IssueCommitmentMessage{
+		IssueCommitmentMessage: &gabi.IssueCommitmentMessage{Nonce2: big.NewInt(11), Proofs: proofs},
+		Indices:                indices,
+	}
+	bts, err := MarshalBinary(commitments)
+	require.NoError(t, err)
+	decodedCommitments := &IssueCommitmentMessage{}
+	require.NoError(t, UnmarshalBinary(bts, decodedCommitments))
+	require.Equal(t, commitments.Nonce2, decodedCommitments.Nonce2)
+	require.Equal(t, indices, decodedCommitments.Indices)
+	require.IsType(t, &gabi.ProofU{}, decodedCommitments.Proofs[0])
+	require.Equal(t, proofs[1], decodedCommitments.Proofs[1])
+
+	signature := &SignedMessage{LDContext: LDContextSignedMessage, Signature: proofs, Indices: indices,
+		Nonce: big.NewInt(13), Context: big.NewInt(1), Message: "message"}
+	bts, err = MarshalBinary(signature)
+	require.NoError(t, err)
+	decodedSignature := &SignedMessage{}
+	require.NoError(t, UnmarshalBinary(bts, decodedSignature))
+	require.Equal(t, signature, decodedSignature)
+
+	bts, err = MarshalBinary(signature.Disclosure())
+	require.NoError(t, err)
+	disclosure := &Disclosure{}
+	require.NoError(t, UnmarshalBinary(bts, disclosure))
+	require.Equal(t, signature.Disclosure(), disclosure)
+
+	// Proofs of which the type cannot be inferred are rejected
+	bts, err = MarshalBinary(map[string]interface{}{"proofs": []map[string]int{{"c": 3}}})
+	require.NoError(t, err)
+	require.Error(t, UnmarshalBinary(bts, &Disclosure{}))
+}
+
 // Test attribute decoding with both old and new metadata versions
 func TestAttributeDecoding(t *testing.T) {
 	expected := "male"
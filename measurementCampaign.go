@@ -0,0 +1,378 @@
+package irma
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/markuskreukniet/irmago-measurements/internal/common"
+)
+
+// A MeasurementCampaign defines a series of measured sessions under several conditions. To avoid
+// building time-dependent effects, such as the load of the Tor network over the day, into the
+// comparison of conditions, the sessions of the conditions are interleaved rather than run in blocks.
+type MeasurementCampaign struct {
+	// Conditions under which sessions are measured. If the scheme of a condition is empty, it is
+	// determined by the server at which the sessions are started.
+	Conditions []MeasurementCondition `json:"conditions"`
+	// Samples is the amount of measured sessions per condition.
+	Samples int `json:"samples"`
+	// WarmUp is the amount of sessions per condition that are performed, but not measured, before
+	// the measured sessions, e.g. to build Tor circuits and fill caches.
+	WarmUp int `json:"warmUp,omitempty"`
+	// Pause is the time to wait between two sessions.
+	Pause time.Duration `json:"-"`
+	// Order in which the conditions are interleaved; CampaignOrderRandom by default.
+	Order CampaignOrder `json:"order,omitempty"`
+	// Seed of the order of the sessions; if 0, a random seed is used, which is stored in the checkpoint.
+	Seed int64 `json:"seed,omitempty"`
+}
+
+// CampaignOrder is the order in which the sessions of the conditions of a campaign are performed.
+type CampaignOrder string
+
+const (
+	// CampaignOrderRandom performs all sessions in random order.
+	CampaignOrderRandom = CampaignOrder("random")
+	// CampaignOrderLatinSquare performs the sessions in rounds of one session per condition, in
+	// the order of the rows of a balanced Latin square, so that each condition precedes each other
+	// condition equally often.
+	CampaignOrderLatinSquare = CampaignOrder("latin-square")
+	// CampaignOrderBlocked performs all sessions of one condition before those of the next one.
+	CampaignOrderBlocked = CampaignOrder("blocked")
+)
+
+// CampaignSample is one session of a campaign.
+type CampaignSample struct {
+	Index int `json:"index"`
+	MeasurementCondition
+	WarmUp bool `json:"warmUp,omitempty"`
+}
+
+// campaignCheckpoint is the progress of a campaign, which is stored after each session so that
+// a campaign that was interrupted resumes where it stopped.
+type campaignCheckpoint struct {
+	Campaign *MeasurementCampaign `json:"campaign"`
+	Seed     int64                `json:"seed"`
+	Schedule []*CampaignSample    `json:"schedule"`
+	Done     int                  `json:"done"`
+	// Records is the amount of session records stored when the checkpoint was written
+	Records int `json:"records"`
+}
+
+// ReadMeasurementCampaign reads a campaign from a JSON file, e.g.
+//
+//	{
+//	  "conditions": [{"network": "direct"}, {"network": "tor"}, {"network": "direct", "shape": "tor"}],
+//	  "samples": 25, "warmUp": 2, "pause": "5s", "order": "latin-square", "seed": 1
+//	}
+func ReadMeasurementCampaign(path string) (*MeasurementCampaign, error) {
+	bts, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	campaign := &MeasurementCampaign{}
+	if err = json.Unmarshal(bts, campaign); err != nil {
+		return nil, errors.WrapPrefix(err, "failed to parse campaign", 0)
+	}
+	return campaign, campaign.Validate()
+}
+
+type measurementCampaignJSON MeasurementCampaign
+
+// MarshalJSON encodes the pause as a string such as "5s".
+func (campaign *MeasurementCampaign) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		*measurementCampaignJSON
+		Pause string `json:"pause,omitempty"`
+	}{(*measurementCampaignJSON)(campaign), durationString(campaign.Pause)})
+}
+
+// UnmarshalJSON decodes the pause from a string such as "5s".
+func (campaign *MeasurementCampaign) UnmarshalJSON(bts []byte) error {
+	var pause struct {
+		Pause string `json:"pause"`
+	}
+	if err := json.Unmarshal(bts, (*measurementCampaignJSON)(campaign)); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(bts, &pause); err != nil {
+		return err
+	}
+	campaign.Pause = 0
+	if pause.Pause == "" {
+		return nil
+	}
+	var err error
+	if campaign.Pause, err = time.ParseDuration(pause.Pause); err != nil {
+		return errors.WrapPrefix(err, "invalid campaign pause", 0)
+	}
+	return nil
+}
+
+func durationString(duration time.Duration) string {
+	if duration == 0 {
+		return ""
+	}
+	return duration.String()
+}
+
+// Validate checks that the campaign can be run.
+func (campaign *MeasurementCampaign) Validate() error {
+	if len(campaign.Conditions) == 0 {
+		return errors.New("campaign has no conditions")
+	}
+	if campaign.Samples <= 0 {
+		return errors.New("campaign must have a positive amount of samples per condition")
+	}
+	if campaign.WarmUp < 0 || campaign.Pause < 0 {
+		return errors.New("campaign warm-up and pause must not be negative")
+	}
+	seen := map[MeasurementCondition]bool{}
+	for _, condition := range campaign.Conditions {
+		if condition.Network != NetworkDirect && condition.Network != NetworkTor {
+			return errors.Errorf("unknown network %s in campaign", condition.Network)
+		}
+		if seen[condition] {
+			return errors.Errorf("duplicate condition %s in campaign", condition.Label())
+		}
+		seen[condition] = true
+	}
+	switch campaign.Order {
+	case "", CampaignOrderRandom, CampaignOrderLatinSquare, CampaignOrderBlocked:
+		return nil
+	default:
+		return errors.Errorf("unknown campaign order %s (must be random, latin-square or blocked)", campaign.Order)
+	}
+}
+
+// Schedule returns the sessions of the campaign in the order in which they are performed, using
+// the specified seed for random orders: first the warm-up sessions, one per condition in turn,
+// followed by the measured sessions in the order of the campaign.
+func (campaign *MeasurementCampaign) Schedule(seed int64) []*CampaignSample {
+	random := rand.New(rand.NewSource(seed))
+	n := len(campaign.Conditions)
+	var order []int // indices of the conditions of the measured sessions
+	switch campaign.Order {
+	case CampaignOrderBlocked:
+		for c := 0; c < n; c++ {
+			for i := 0; i < campaign.Samples; i++ {
+				order = append(order, c)
+			}
+		}
+	case CampaignOrderLatinSquare:
+		// Assign the conditions randomly to the symbols of the square
+		symbols := random.Perm(n)
+		square := balancedLatinSquare(n)
+		for round := 0; round < campaign.Samples; round++ {
+			for _, symbol := range square[round%len(square)] {
+				order = append(order, symbols[symbol])
+			}
+		}
+	default:
+		for c := 0; c < n; c++ {
+			for i := 0; i < campaign.Samples; i++ {
+				order = append(order, c)
+			}
+		}
+		random.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+	}
+
+	var schedule []*CampaignSample
+	for i := 0; i < campaign.WarmUp; i++ {
+		for _, condition := range campaign.Conditions {
+			schedule = append(schedule, &CampaignSample{MeasurementCondition: condition, WarmUp: true})
+		}
+	}
+	for _, c := range order {
+		schedule = append(schedule, &CampaignSample{MeasurementCondition: campaign.Conditions[c]})
+	}
+	for i, sample := range schedule {
+		sample.Index = i
+	}
+	return schedule
+}
+
+// balancedLatinSquare returns the rows of a balanced Latin square of the specified order (Williams
+// design), in which each symbol immediately precedes each other symbol equally often. For odd
+// orders this takes twice as many rows as symbols.
+func balancedLatinSquare(n int) [][]int {
+	first := make([]int, n)
+	for j := 1; j < n; j++ {
+		if j%2 == 1 {
+			first[j] = (j + 1) / 2
+		} else {
+			first[j] = n - j/2
+		}
+	}
+	var rows [][]int
+	for i := 0; i < n; i++ {
+		row := make([]int, n)
+		for j := range row {
+			row[j] = (first[j] + i) % n
+		}
+		rows = append(rows, row)
+	}
+	if n%2 == 1 {
+		for i := 0; i < n; i++ {
+			row := make([]int, n)
+			for j := range row {
+				row[j] = rows[i][n-1-j]
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// RunCampaign performs the sessions of the campaign that have not been done yet with perform, and
+// stores their results with AddSessionRun. The progress is checkpointed after each session, so that
+// running the same campaign in the same directory after an interruption resumes where it stopped;
+// the session that was being performed during the interruption is performed again, unless its
+// result was stored already.
+func (m *Measurements) RunCampaign(campaign *MeasurementCampaign, perform func(sample *CampaignSample) (*SessionRunRecord, error)) error {
+	if err := campaign.Validate(); err != nil {
+		return err
+	}
+	checkpoint, err := m.campaignCheckpoint(campaign)
+	if err != nil {
+		return err
+	}
+	err = m.updateManifest(func(manifest *MeasurementManifest) error {
+		manifest.Campaign, manifest.CampaignSeed = checkpoint.Campaign, checkpoint.Seed
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for first := true; checkpoint.Done < len(checkpoint.Schedule); first = false {
+		if !first {
+			time.Sleep(campaign.Pause)
+		}
+		sample := checkpoint.Schedule[checkpoint.Done]
+		record, err := perform(sample)
+		if err != nil {
+			return err
+		}
+		record.Index = sample.Index
+		record.WarmUp = sample.WarmUp
+		if err = m.AddSessionRun(record); err != nil {
+			return err
+		}
+		checkpoint.Done++
+		checkpoint.Records++
+		if err = m.writeCampaignCheckpoint(checkpoint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CampaignProgress returns the amount of sessions of the campaign in the directory that have been
+// done, and the total amount of sessions of the campaign, or zeroes if there is no campaign.
+func (m *Measurements) CampaignProgress() (done, total int, err error) {
+	checkpoint, err := m.readCampaignCheckpoint()
+	if err != nil || checkpoint == nil {
+		return 0, 0, err
+	}
+	return checkpoint.Done, len(checkpoint.Schedule), nil
+}
+
+// campaignCheckpoint returns the checkpoint of the campaign in the directory, or a new one if
+// there is none or if the previous campaign was completed.
+func (m *Measurements) campaignCheckpoint(campaign *MeasurementCampaign) (*campaignCheckpoint, error) {
+	checkpoint, err := m.readCampaignCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+	if checkpoint != nil && checkpoint.Done < len(checkpoint.Schedule) {
+		if !reflect.DeepEqual(checkpoint.Campaign, campaign) {
+			return nil, errors.Errorf("another campaign is in progress in %s", m.Path)
+		}
+		return checkpoint, m.skipStoredSession(checkpoint)
+	}
+
+	runs, err := m.SessionRuns()
+	if err != nil {
+		return nil, err
+	}
+	seed := campaign.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	checkpoint = &campaignCheckpoint{Campaign: campaign, Seed: seed, Schedule: campaign.Schedule(seed), Records: len(runs)}
+	return checkpoint, m.writeCampaignCheckpoint(checkpoint)
+}
+
+// skipStoredSession advances the checkpoint past the session that was being performed when the
+// campaign was interrupted, if its result was stored before the checkpoint could be written, so
+// that it is not performed and stored again.
+func (m *Measurements) skipStoredSession(checkpoint *campaignCheckpoint) error {
+	runs, err := m.SessionRuns()
+	if err != nil || len(runs) <= checkpoint.Records {
+		return err
+	}
+	last, sample := runs[len(runs)-1], checkpoint.Schedule[checkpoint.Done]
+	if last.Index != sample.Index || last.WarmUp != sample.WarmUp || last.MeasurementCondition != sample.MeasurementCondition {
+		return nil
+	}
+	checkpoint.Done++
+	checkpoint.Records = len(runs)
+	return m.writeCampaignCheckpoint(checkpoint)
+}
+
+func (m *Measurements) readCampaignCheckpoint() (*campaignCheckpoint, error) {
+	filePath := m.filePath(fileCampaign)
+	if !pathDoesExist(filePath) {
+		return nil, nil
+	}
+	bts, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	checkpoint := &campaignCheckpoint{}
+	if err = json.Unmarshal(bts, checkpoint); err != nil {
+		return nil, errors.WrapPrefix(err, "failed to read campaign checkpoint", 0)
+	}
+	return checkpoint, nil
+}
+
+func (m *Measurements) writeCampaignCheckpoint(checkpoint *campaignCheckpoint) error {
+	bts, err := json.MarshalIndent(checkpoint, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(m.filePath(fileCampaign), bts)
+}
+
+// writeFileAtomically replaces the content of the file, such that it contains either its old or
+// its new content if writing is interrupted.
+func writeFileAtomically(filePath string, content []byte) error {
+	if err := common.EnsureDirectoryExists(filepath.Dir(filePath)); err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(filepath.Dir(filePath), filepath.Base(filePath)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(content); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(file.Name(), 0644)
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), filePath)
+}
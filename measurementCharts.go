@@ -0,0 +1,358 @@
+package irma
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// This file writes the measurement report as a single HTML file, in which the charts are drawn
+// as inline SVG. It references no scripts, stylesheets or fonts, so that it can be viewed on
+// machines without internet access.
+
+const (
+	chartWidth        = 720
+	chartHeight       = 320
+	chartMarginLeft   = 64
+	chartMarginRight  = 176 // room for the legend
+	chartMarginTop    = 32
+	chartMarginBottom = 48
+	chartTicks        = 6
+)
+
+// chartColors are the colors of the series in charts, in order.
+var chartColors = []string{
+	"#1f77b4", "#d62728", "#2ca02c", "#ff7f0e", "#9467bd", "#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf",
+}
+
+const htmlReportHeader = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Measurement report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin: 1em 0; font-size: 90%; }
+th, td { border: 1px solid #ccc; padding: 0.25em 0.5em; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+.charts { display: flex; flex-wrap: wrap; gap: 1em; }
+svg { font-size: 11px; background: #fff; }
+svg .grid { stroke: #e5e5e5; }
+svg .axis { stroke: #222; }
+</style>
+</head>
+<body>
+`
+
+const htmlReportFooter = `</body>
+</html>
+`
+
+// chartSeries is a named series of samples of one condition or run in a chart.
+type chartSeries struct {
+	label string
+	x, y  []float64
+}
+
+// svgChart draws a chart with linear axes into an SVG element.
+type svgChart struct {
+	b                      strings.Builder
+	xmin, xmax, ymin, ymax float64
+}
+
+func (report *MeasurementReport) writeHTML(w io.Writer) error {
+	if _, err := io.WriteString(w, htmlReportHeader); err != nil {
+		return err
+	}
+	if err := report.writeTables(w, htmlTableWriter{}, report.writeCharts); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, htmlReportFooter)
+	return err
+}
+
+// writeCharts writes the CDF, box plot and time series charts of the durations of the operation.
+func (report *MeasurementReport) writeCharts(w io.Writer, op *OperationReport) error {
+	var conditions []chartSeries
+	for _, c := range op.Conditions {
+		if c.Statistics == nil {
+			continue
+		}
+		series := chartSeries{label: c.Label()}
+		for _, record := range report.records {
+			if record.Operation == op.Operation && record.MeasurementCondition == c.MeasurementCondition {
+				series.y = append(series.y, durationMillis(record.Duration))
+			}
+		}
+		conditions = append(conditions, series)
+	}
+
+	charts := []string{
+		cdfChart("Cumulative distribution", conditions),
+		boxChart("Distribution per condition", conditions),
+		timeSeriesChart("Per run over time", report.runSeries(op.Operation)),
+	}
+	_, err := fmt.Fprintf(w, "<div class=\"charts\">\n%s</div>\n", strings.Join(charts, ""))
+	return err
+}
+
+// runSeries returns the durations of the operation per run and condition, against the time in
+// seconds since the start of the run.
+func (report *MeasurementReport) runSeries(op MeasurementOperation) []chartSeries {
+	starts := map[string]time.Time{}
+	for _, run := range report.Runs {
+		starts[run.RunID] = run.First
+	}
+	var series []chartSeries
+	index := map[string]int{}
+	for _, record := range report.records {
+		if record.Operation != op {
+			continue
+		}
+		label := record.RunID + " (" + record.MeasurementCondition.Label() + ")"
+		i, ok := index[label]
+		if !ok {
+			i = len(series)
+			index[label] = i
+			series = append(series, chartSeries{label: label})
+		}
+		series[i].x = append(series[i].x, record.Timestamp.Sub(starts[record.RunID]).Seconds())
+		series[i].y = append(series[i].y, durationMillis(record.Duration))
+	}
+	for _, s := range series {
+		sort.Sort(byX(s))
+	}
+	return series
+}
+
+func cdfChart(title string, series []chartSeries) string {
+	xmin, xmax := seriesRange(series, false)
+	c := newSVGChart(math.Min(xmin, 0), xmax, 0, 1)
+	c.axes(title, "duration (ms)", "fraction")
+	for i, s := range series {
+		sorted := sortedCopy(s.y)
+		path := fmt.Sprintf("M%.1f,%.1f", c.x(sorted[0]), c.y(0))
+		for j, v := range sorted {
+			path += fmt.Sprintf(" H%.1f V%.1f", c.x(v), c.y(float64(j+1)/float64(len(sorted))))
+		}
+		fmt.Fprintf(&c.b, `<path d="%s" fill="none" stroke="%s" stroke-width="1.5"/>`+"\n", path, chartColor(i))
+	}
+	c.legend(series)
+	return c.String()
+}
+
+func boxChart(title string, series []chartSeries) string {
+	ymin, ymax := seriesRange(series, false)
+	c := newSVGChart(0, 1, math.Min(ymin, 0), ymax)
+	c.xmin, c.xmax = 0, float64(len(series)) // a box per series, without ticks
+	c.axes(title, "", "duration (ms)")
+	width := c.x(0.6) - c.x(0)
+	for i, s := range series {
+		sorted := sortedCopy(s.y)
+		q1, median, q3 := percentile(sorted, 25), percentile(sorted, 50), percentile(sorted, 75)
+		iqr := q3 - q1
+		// Whiskers extend to the most extreme samples within 1.5 IQR of the box
+		low, high := median, median
+		for _, v := range sorted {
+			if v >= q1-1.5*iqr && v < low {
+				low = v
+			}
+			if v <= q3+1.5*iqr && v > high {
+				high = v
+			}
+		}
+
+		color, center := chartColor(i), c.x(float64(i)+0.5)
+		fmt.Fprintf(&c.b, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="%s"/>`+"\n",
+			center, c.y(low), center, c.y(high), color)
+		fmt.Fprintf(&c.b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="#fff" stroke="%s"/>`+"\n",
+			center-width/2, c.y(q3), width, c.y(q1)-c.y(q3), color)
+		fmt.Fprintf(&c.b, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="%s" stroke-width="2"/>`+"\n",
+			center-width/2, c.y(median), center+width/2, c.y(median), color)
+		for _, v := range sorted {
+			if v < low || v > high {
+				fmt.Fprintf(&c.b, `<circle cx="%.1f" cy="%.1f" r="2" fill="none" stroke="%s"/>`+"\n", center, c.y(v), color)
+			}
+		}
+		fmt.Fprintf(&c.b, `<text x="%.1f" y="%.1f" text-anchor="middle">%s</text>`+"\n",
+			center, c.y(c.ymin)+16, html.EscapeString(s.label))
+	}
+	return c.String()
+}
+
+func timeSeriesChart(title string, series []chartSeries) string {
+	xmin, xmax := seriesRange(series, true)
+	ymin, ymax := seriesRange(series, false)
+	c := newSVGChart(math.Min(xmin, 0), xmax, math.Min(ymin, 0), ymax)
+	c.axes(title, "time since start of run (s)", "duration (ms)")
+	for i, s := range series {
+		points := make([]string, len(s.x))
+		for j := range s.x {
+			points[j] = fmt.Sprintf("%.1f,%.1f", c.x(s.x[j]), c.y(s.y[j]))
+			fmt.Fprintf(&c.b, `<circle cx="%.1f" cy="%.1f" r="2" fill="%s"/>`+"\n", c.x(s.x[j]), c.y(s.y[j]), chartColor(i))
+		}
+		fmt.Fprintf(&c.b, `<polyline points="%s" fill="none" stroke="%s"/>`+"\n", strings.Join(points, " "), chartColor(i))
+	}
+	c.legend(series)
+	return c.String()
+}
+
+// newSVGChart returns a chart of which the axes span at least the specified ranges; they are
+// extended to round numbers.
+func newSVGChart(xmin, xmax, ymin, ymax float64) *svgChart {
+	c := &svgChart{}
+	c.xmin, c.xmax, _ = niceTicks(xmin, xmax)
+	c.ymin, c.ymax, _ = niceTicks(ymin, ymax)
+	return c
+}
+
+func (c *svgChart) x(v float64) float64 {
+	return chartMarginLeft + (v-c.xmin)/(c.xmax-c.xmin)*(chartWidth-chartMarginLeft-chartMarginRight)
+}
+
+func (c *svgChart) y(v float64) float64 {
+	return chartHeight - chartMarginBottom - (v-c.ymin)/(c.ymax-c.ymin)*(chartHeight-chartMarginTop-chartMarginBottom)
+}
+
+// axes draws the title, the grid lines and ticks, and the axis labels. If xlabel is empty, the x
+// axis has no ticks, as in box plots where the series are labeled instead.
+func (c *svgChart) axes(title, xlabel, ylabel string) {
+	left, right := c.x(c.xmin), c.x(c.xmax)
+	top, bottom := c.y(c.ymax), c.y(c.ymin)
+	fmt.Fprintf(&c.b, `<text x="%.1f" y="%d" text-anchor="middle" font-weight="bold">%s</text>`+"\n",
+		(left+right)/2, chartMarginTop/2, html.EscapeString(title))
+
+	_, _, yticks := niceTicks(c.ymin, c.ymax)
+	for _, tick := range yticks {
+		fmt.Fprintf(&c.b, `<line class="grid" x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f"/>`+"\n", left, c.y(tick), right, c.y(tick))
+		fmt.Fprintf(&c.b, `<text x="%.1f" y="%.1f" text-anchor="end" dominant-baseline="middle">%s</text>`+"\n",
+			left-4, c.y(tick), formatTick(tick, yticks))
+	}
+	if xlabel != "" {
+		_, _, xticks := niceTicks(c.xmin, c.xmax)
+		for _, tick := range xticks {
+			fmt.Fprintf(&c.b, `<line class="grid" x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f"/>`+"\n", c.x(tick), top, c.x(tick), bottom)
+			fmt.Fprintf(&c.b, `<text x="%.1f" y="%.1f" text-anchor="middle">%s</text>`+"\n",
+				c.x(tick), bottom+14, formatTick(tick, xticks))
+		}
+		fmt.Fprintf(&c.b, `<text x="%.1f" y="%d" text-anchor="middle">%s</text>`+"\n",
+			(left+right)/2, chartHeight-8, html.EscapeString(xlabel))
+	}
+	fmt.Fprintf(&c.b, `<text transform="translate(14,%.1f) rotate(-90)" text-anchor="middle">%s</text>`+"\n",
+		(top+bottom)/2, html.EscapeString(ylabel))
+	fmt.Fprintf(&c.b, `<polyline class="axis" points="%.1f,%.1f %.1f,%.1f %.1f,%.1f" fill="none"/>`+"\n",
+		left, top, left, bottom, right, bottom)
+}
+
+func (c *svgChart) legend(series []chartSeries) {
+	x := float64(chartWidth - chartMarginRight + 12)
+	for i, s := range series {
+		y := float64(chartMarginTop + 16*i)
+		fmt.Fprintf(&c.b, `<rect x="%.1f" y="%.1f" width="10" height="10" fill="%s"/>`+"\n", x, y, chartColor(i))
+		fmt.Fprintf(&c.b, `<text x="%.1f" y="%.1f" dominant-baseline="middle">%s</text>`+"\n",
+			x+14, y+5, html.EscapeString(s.label))
+	}
+}
+
+func (c *svgChart) String() string {
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n%s</svg>\n",
+		chartWidth, chartHeight, chartWidth, chartHeight, c.b.String())
+}
+
+// niceTicks returns round numbers spanning at least [min, max], and the ticks between them.
+func niceTicks(min, max float64) (float64, float64, []float64) {
+	if max <= min {
+		max = min + 1
+	}
+	raw := (max - min) / chartTicks
+	magnitude := math.Pow(10, math.Floor(math.Log10(raw)))
+	step := 10 * magnitude
+	for _, f := range []float64{1, 2, 5} {
+		if f*magnitude >= raw {
+			step = f * magnitude
+			break
+		}
+	}
+	min, max = math.Floor(min/step)*step, math.Ceil(max/step)*step
+	var ticks []float64
+	for tick := min; tick <= max+step/2; tick += step {
+		ticks = append(ticks, tick)
+	}
+	return min, max, ticks
+}
+
+// formatTick formats the tick with as many decimals as needed to distinguish it from the others.
+func formatTick(tick float64, ticks []float64) string {
+	decimals := 0
+	if len(ticks) > 1 {
+		decimals = int(math.Max(0, -math.Floor(math.Log10(ticks[1]-ticks[0]))))
+	}
+	return fmt.Sprintf("%.*f", decimals, tick)
+}
+
+// seriesRange returns the smallest and largest x or y value of the series.
+func seriesRange(series []chartSeries, x bool) (float64, float64) {
+	min, max := math.Inf(1), math.Inf(-1)
+	for _, s := range series {
+		values := s.y
+		if x {
+			values = s.x
+		}
+		for _, v := range values {
+			min, max = math.Min(min, v), math.Max(max, v)
+		}
+	}
+	if math.IsInf(min, 1) {
+		return 0, 1
+	}
+	return min, max
+}
+
+func chartColor(i int) string {
+	return chartColors[i%len(chartColors)]
+}
+
+func durationMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// byX sorts the points of a series by their x value.
+type byX chartSeries
+
+func (s byX) Len() int           { return len(s.x) }
+func (s byX) Less(i, j int) bool { return s.x[i] < s.x[j] }
+func (s byX) Swap(i, j int) {
+	s.x[i], s.x[j] = s.x[j], s.x[i]
+	s.y[i], s.y[j] = s.y[j], s.y[i]
+}
+
+// htmlTableWriter writes tables as HTML.
+type htmlTableWriter struct{}
+
+func (htmlTableWriter) heading(w io.Writer, level int, text string) error {
+	_, err := fmt.Fprintf(w, "<h%d>%s</h%d>\n", level, html.EscapeString(text), level)
+	return err
+}
+
+func (htmlTableWriter) table(w io.Writer, header []string, rows [][]string) error {
+	var b strings.Builder
+	b.WriteString("<table>\n<thead><tr>")
+	for _, cell := range header {
+		b.WriteString("<th>" + html.EscapeString(cell) + "</th>")
+	}
+	b.WriteString("</tr></thead>\n<tbody>\n")
+	for _, row := range rows {
+		b.WriteString("<tr>")
+		for _, cell := range row {
+			b.WriteString("<td>" + html.EscapeString(cell) + "</td>")
+		}
+		b.WriteString("</tr>\n")
+	}
+	b.WriteString("</tbody>\n</table>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,191 @@
+package irma
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/smtp"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/jordan-wright/email"
+	"github.com/markuskreukniet/irmago-measurements/internal/common"
+)
+
+// MeasurementExport contains the results of a measurement run.
+type MeasurementExport struct {
+	RunID   string
+	Summary string
+	Files   []string // paths of the files containing the measurements
+}
+
+// ResultExporter exports the results of a measurement run, e.g. by email.
+type ResultExporter interface {
+	Export(export *MeasurementExport) error
+}
+
+// SMTPTLSMode determines how an SMTPExporter secures its connection to the SMTP server.
+type SMTPTLSMode string
+
+const (
+	// SMTPTLSNone uses STARTTLS only if the server supports it.
+	SMTPTLSNone = SMTPTLSMode("none")
+	// SMTPStartTLS requires STARTTLS.
+	SMTPStartTLS = SMTPTLSMode("starttls")
+	// SMTPTLS connects over TLS directly.
+	SMTPTLS = SMTPTLSMode("tls")
+)
+
+// SMTPExporter sends the results of a measurement run by email, with the measurement files attached.
+type SMTPExporter struct {
+	Host     string      `json:"host"`
+	Port     int         `json:"port,omitempty"`     // 587, or 465 if TLSMode is SMTPTLS, if 0
+	Username string      `json:"username,omitempty"` // no authentication is done if empty
+	Password string      `json:"password,omitempty"`
+	TLSMode  SMTPTLSMode `json:"tls_mode,omitempty"`
+	From     string      `json:"from"`
+	To       []string    `json:"to"`
+	Subject  string      `json:"subject,omitempty"`
+}
+
+// DirectoryExporter copies the results of each measurement run to a subdirectory of Path
+// named after the run.
+type DirectoryExporter struct {
+	Path string `json:"path"`
+}
+
+// WebhookExporter POSTs the results of a measurement run as JSON to URL.
+type WebhookExporter struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+
+	Client *http.Client `json:"-"` // if nil, a client with a 30 second timeout is used
+}
+
+// webhookMessage is the message posted by the WebhookExporter.
+type webhookMessage struct {
+	RunID   string            `json:"runId"`
+	Summary string            `json:"summary"`
+	Files   map[string]string `json:"files"`
+}
+
+const summaryFileName = "summary.txt"
+
+func (exporter *SMTPExporter) Export(export *MeasurementExport) error {
+	if len(exporter.To) == 0 {
+		return errors.New("no email recipients configured")
+	}
+
+	e := email.NewEmail()
+
+	// by testing, it looks like AttachFile has to happen first
+	for _, filePath := range export.Files {
+		if _, err := e.AttachFile(filePath); err != nil {
+			return err
+		}
+	}
+
+	e.From = exporter.From
+	e.To = exporter.To
+	e.Subject = exporter.Subject
+	if e.Subject == "" {
+		e.Subject = "measurement statistics of run " + export.RunID
+	}
+	e.Text = []byte(export.Summary)
+
+	var auth smtp.Auth
+	if exporter.Username != "" {
+		auth = smtp.PlainAuth("", exporter.Username, exporter.Password, exporter.Host)
+	}
+	addr, err := exporter.address()
+	if err != nil {
+		return err
+	}
+	tlsConfig := &tls.Config{ServerName: exporter.Host}
+
+	switch exporter.TLSMode {
+	case SMTPTLSNone, "":
+		return e.Send(addr, auth)
+	case SMTPStartTLS:
+		return e.SendWithStartTLS(addr, auth, tlsConfig)
+	case SMTPTLS:
+		return e.SendWithTLS(addr, auth, tlsConfig)
+	default:
+		return errors.Errorf("unknown SMTP TLS mode %s", exporter.TLSMode)
+	}
+}
+
+// address returns the address of the SMTP server, using the default port of the TLS mode if no
+// port is configured.
+func (exporter *SMTPExporter) address() (string, error) {
+	port := exporter.Port
+	switch {
+	case port == 0 && exporter.TLSMode == SMTPTLS:
+		port = 465
+	case port == 0:
+		port = 587
+	case port < 0 || port > 65535:
+		return "", errors.Errorf("invalid SMTP port %d", port)
+	}
+	return net.JoinHostPort(exporter.Host, strconv.Itoa(port)), nil
+}
+
+func (exporter *DirectoryExporter) Export(export *MeasurementExport) error {
+	dir := filepath.Join(exporter.Path, export.RunID)
+	if err := common.EnsureDirectoryExists(dir); err != nil {
+		return err
+	}
+	for _, filePath := range export.Files {
+		bts, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		if err = common.SaveFile(filepath.Join(dir, filepath.Base(filePath)), bts); err != nil {
+			return err
+		}
+	}
+	return common.SaveFile(filepath.Join(dir, summaryFileName), []byte(export.Summary))
+}
+
+func (exporter *WebhookExporter) Export(export *MeasurementExport) error {
+	msg := webhookMessage{RunID: export.RunID, Summary: export.Summary, Files: map[string]string{}}
+	for _, filePath := range export.Files {
+		bts, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		msg.Files[filepath.Base(filePath)] = string(bts)
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, exporter.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	for name, val := range exporter.Headers {
+		req.Header.Set(name, val)
+	}
+
+	client := exporter.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return errors.Errorf("webhook returned status %d", res.StatusCode)
+	}
+	return nil
+}
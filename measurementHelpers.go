@@ -2,87 +2,63 @@ package irma
 
 import (
 	"bufio"
+	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
-	"net/smtp"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 
-	"github.com/jordan-wright/email"
+	"github.com/go-errors/errors"
+	"github.com/markuskreukniet/irmago-measurements/internal/common"
 )
 
 const (
-	DisclosureNewSession           int = iota
-	DisclosureRespondPermission    int = iota
-	IssuanceNewSession             int = iota
-	IssuanceRespondPermission      int = iota
-	TorDisclosureNewSession        int = iota
-	TorDisclosureRespondPermission int = iota
-	TorIssuanceNewSession          int = iota
-	TorIssuanceRespondPermission   int = iota
-
-	KssGetCommitments    int = iota
-	KssGetProofPs        int = iota
-	TorKssGetCommitments int = iota
-	TorKssGetProofPs     int = iota
-
-	DisclosureHttpsNewSession           int = iota
-	DisclosureHttpsRespondPermission    int = iota
-	IssuanceHttpsNewSession             int = iota
-	IssuanceHttpsRespondPermission      int = iota
-	TorDisclosureHttpsNewSession        int = iota
-	TorDisclosureHttpsRespondPermission int = iota
-	TorIssuanceHttpsNewSession          int = iota
-	TorIssuanceHttpsRespondPermission   int = iota
-
-	KssHttpsGetCommitments    int = iota
-	KssHttpsGetProofPs        int = iota
-	TorKssHttpsGetCommitments int = iota
-	TorKssHttpsGetProofPs     int = iota
-
 	measurementsDoneText string = "measurements done: "
 
-	folderPath      string = "/data/user/0/foundation.privacybydesign.irmamobile.alpha/v2"
-	filePart        string = "/measurementsDone.txt"
-	filePartFlutter string = "/latestMeasurementsFlutter.txt"
-	filePath        string = folderPath + filePart
-	filePathFlutter string = folderPath + filePartFlutter
-
-	filePartDisclosureNewSession           string = "/disclosureNewSession.txt"
-	filePartDisclosureRespondPermission    string = "/disclosureRespondPermission.txt"
-	filePartIssuanceNewSession             string = "/issuanceNewSession.txt"
-	filePartIssuanceRespondPermission      string = "/issuanceRespondPermission.txt"
-	filePartTorDisclosureNewSession        string = "/torDisclosureNewSession.txt"
-	filePartTorDisclosureRespondPermission string = "/torDisclosureRespondPermission.txt"
-	filePartTorIssuanceNewSession          string = "/torIssuanceNewSession.txt"
-	filePartTorIssuanceRespondPermission   string = "/torIssuanceRespondPermission.txt"
-
-	filePartKssGetCommitments    string = "/kssGetCommitments.txt"
-	filePartKssGetProofPs        string = "/kssGetProofPs.txt"
-	filePartTorKssGetCommitments string = "/torKssGetCommitments.txt"
-	filePartTorKssGetProofPs     string = "/torKssGetProofPs.txt"
-
-	// HTTPS - start
-	filePartDisclosureHttpsNewSession           string = "/disclosureHttpsNewSession.txt"
-	filePartDisclosureHttpsRespondPermission    string = "/disclosureHttpsRespondPermission.txt"
-	filePartIssuanceHttpsNewSession             string = "/issuanceHttpsNewSession.txt"
-	filePartIssuanceHttpsRespondPermission      string = "/issuanceHttpsRespondPermission.txt"
-	filePartTorDisclosureHttpsNewSession        string = "/torDisclosureHttpsNewSession.txt"
-	filePartTorDisclosureHttpsRespondPermission string = "/torDisclosureHttpsRespondPermission.txt"
-	filePartTorIssuanceHttpsNewSession          string = "/torIssuanceHttpsNewSession.txt"
-	filePartTorIssuanceHttpsRespondPermission   string = "/torIssuanceHttpsRespondPermission.txt"
-
-	filePartKssHttpsGetCommitments    string = "/kssHttpsGetCommitments.txt"
-	filePartKssHttpsGetProofPs        string = "/kssHttpsGetProofPs.txt"
-	filePartTorKssHttpsGetCommitments string = "/torKssHttpsGetCommitments.txt"
-	filePartTorKssHttpsGetProofPs     string = "/torKssHttpsGetProofPs.txt"
-	// HTTPS - end
+	fileMeasurementsDone string = "measurementsDone.txt"
+	fileFlutter          string = "latestMeasurementsFlutter.txt"
+	fileRunID            string = "measurementRun.txt"
+	fileRecordsJSONL     string = "measurements.jsonl"
+	fileRecordsCSV       string = "measurements.csv"
+	fileRequestsJSONL    string = "requests.jsonl"
+	fileSessionsJSONL    string = "sessions.jsonl"
+	fileTrafficJSONL     string = "traffic.jsonl"
+	fileTorJSONL         string = "tor.jsonl"
+	fileCampaign         string = "campaign.json"
+	fileManifest         string = "manifest.json"
 
 	measurementText string = "measurement: "
 )
 
+// structuredFiles contains the files of a run, besides those of the kinds of measurements, that
+// are exported.
+var structuredFiles = []string{
+	fileRecordsJSONL, fileRecordsCSV, fileRequestsJSONL, fileSessionsJSONL, fileTrafficJSONL, fileTorJSONL, fileCampaign,
+}
+
+// DefaultMeasurementSamples is the default amount of measurements per run.
+const DefaultMeasurementSamples = 25
+
+// Measurements keeps the results of measurements, and the amount of measurements done, in the
+// files of a directory.
+type Measurements struct {
+	Path string
+	// Samples is the amount of measurements per run after which IncrementMeasurementAndDetermineAgain
+	// returns false; DefaultMeasurementSamples if 0.
+	Samples int
+	// FlutterFile enables the legacy latestMeasurementsFlutter.txt file, which contains the
+	// measurements of the latest successful session as text. Apps should use the structured
+	// results of sessions instead (see SessionMeasurements.Result).
+	FlutterFile bool
+}
+
+// NewMeasurements returns a Measurements that keeps its files in the directory at path.
+func NewMeasurements(path string) *Measurements {
+	return &Measurements{Path: path}
+}
+
 // private functions
 
 func pathDoesExist(filePath string) bool {
@@ -93,60 +69,57 @@ func pathDoesExist(filePath string) bool {
 	}
 }
 
-func replaceFileContentWithString(filePath string, s string) {
-	bytes := []byte(s)
-	err := ioutil.WriteFile(filePath, bytes, 0644)
-	if err != nil {
-		log.Fatal(err)
+func replaceFileContentWithString(filePath string, s string) error {
+	if err := common.EnsureDirectoryExists(filepath.Dir(filePath)); err != nil {
+		return err
 	}
+	return ioutil.WriteFile(filePath, []byte(s), 0644)
 }
 
-func determineFileStringContent(filePath string) string {
+func determineFileStringContent(filePath string) (string, error) {
 	content, err := ioutil.ReadFile(filePath)
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
 
-	return string(content)
+	return string(content), nil
 }
 
-func determineMeasurementsDone(filePath string) int {
+func determineMeasurementsDone(filePath string) (int, error) {
 	if !pathDoesExist(filePath) {
-		return 0
+		return 0, nil
 	}
 
-	stringContent := determineFileStringContent(filePath)
+	stringContent, err := determineFileStringContent(filePath)
+	if err != nil {
+		return 0, err
+	}
 
 	if strings.Contains(stringContent, measurementsDoneText) {
 		stringNumber := strings.Split(stringContent,
 			measurementsDoneText)[1]
-		number, err := strconv.Atoi(stringNumber)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		return number
+		return strconv.Atoi(stringNumber)
 	} else {
-		return 0
+		return 0, nil
 	}
 }
 
-func deleteMeasurementsDoneFile(filePath string) {
+func deleteMeasurementsDoneFile(filePath string) error {
 	if pathDoesExist(filePath) {
-		err := os.Remove(filePath)
-		if err != nil {
-			log.Fatal(err)
-		}
+		return os.Remove(filePath)
 	}
+	return nil
 }
 
-func calculateAverage(filePath string) int64 {
-	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_RDWR, 0644)
+// determineMeasurementResults reads the results from a file of measurements of some kind.
+func determineMeasurementResults(filePath string) ([]float64, error) {
+	file, err := os.Open(filePath)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+	defer file.Close()
 
-	var measurements []int64
+	var measurements []float64
 
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
@@ -155,420 +128,324 @@ func calculateAverage(filePath string) int64 {
 
 			i, err := strconv.ParseInt(s, 10, 64)
 			if err != nil {
-				log.Fatal(err)
+				return nil, err
 			}
 
-			measurements = append(measurements, i)
+			measurements = append(measurements, float64(i))
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
-	}
-
-	if len(measurements) > 0 {
-		var sum int64 = 0
-
-		for _, i := range measurements {
-			sum += i
-		}
-
-		return sum / int64(len(measurements))
-	} else {
-		return 0
-	}
-}
-
-func sendMail(emailText string, filePaths []string) {
-	smtpServerHost := "smtp.gmail.com"
-	smtpServerAddress := smtpServerHost + ":587"
-	emailAddress := "irmamobilemeasurementtests@gmail.com"
-
-	e := email.NewEmail()
-
-	// by testing, it looks like AttachFile has to happen first
-	for _, filePath := range filePaths {
-		e.AttachFile(filePath)
+		return nil, err
 	}
 
-	e.From = emailAddress
-	e.To = []string{emailAddress}
-	e.Subject = "measurement averages"
-	e.Text = []byte(emailText)
-	e.Send(smtpServerAddress, smtp.PlainAuth("", emailAddress, "asdf5asdf", smtpServerHost))
+	return measurements, nil
 }
 
-func addFilePathAndEmailTextIfExist(filePaths []string,
+func addFilePathAndSummaryIfExist(filePaths []string,
 	filePath string,
-	emailText string,
-	measurement string) ([]string, string) {
+	summary string,
+	measurement string) ([]string, string, error) {
 	if pathDoesExist(filePath) {
+		results, err := determineMeasurementResults(filePath)
+		if err != nil {
+			return nil, "", err
+		}
 		filePaths = append(filePaths, filePath)
-		average := calculateAverage(filePath)
-		emailText += "The " + measurement + " average is: " +
-			strconv.FormatInt(average, 10) + "\n"
+		stats := NewMeasurementStatistics(results)
+		summary += "The " + measurement + " statistics are: " + stats.String() + "\n"
 	}
 
-	return filePaths, emailText
+	return filePaths, summary, nil
 }
 
-func determineFlutterMeasurementText(measurementType int) string {
-	flutterMeasurementText := ""
-
-	switch measurementType {
-	case DisclosureNewSession:
-		flutterMeasurementText = "disclosureNewSession: "
-	case DisclosureRespondPermission:
-		flutterMeasurementText = "\ndisclosureRespondPermission: "
-	case IssuanceNewSession:
-		flutterMeasurementText = "issuanceNewSession: "
-	case IssuanceRespondPermission:
-		flutterMeasurementText = "\nissuanceRespondPermission: "
-	case TorDisclosureNewSession:
-		flutterMeasurementText = "torDisclosureNewSession: "
-	case TorDisclosureRespondPermission:
-		flutterMeasurementText = "\ntorDisclosureRespondPermission: "
-	case TorIssuanceNewSession:
-		flutterMeasurementText = "torIssuanceNewSession: "
-	case TorIssuanceRespondPermission:
-		flutterMeasurementText = "\ntorIssuanceRespondPermission: "
-
-	case KssGetCommitments:
-		flutterMeasurementText = "\nkssGetCommitments: "
-	case KssGetProofPs:
-		flutterMeasurementText = "\nkssGetProofPs: "
-	case TorKssGetCommitments:
-		flutterMeasurementText = "\ntorKssGetCommitments: "
-	case TorKssGetProofPs:
-		flutterMeasurementText = "\ntorKssGetProofPs: "
-
-		// HTTPS - start
-	case DisclosureHttpsNewSession:
-		flutterMeasurementText = "disclosureHttpsNewSession: "
-	case DisclosureHttpsRespondPermission:
-		flutterMeasurementText = "\ndisclosureHttpsRespondPermission: "
-	case IssuanceHttpsNewSession:
-		flutterMeasurementText = "issuanceHttpsNewSession: "
-	case IssuanceHttpsRespondPermission:
-		flutterMeasurementText = "\nissuanceHttpsRespondPermission: "
-	case TorDisclosureHttpsNewSession:
-		flutterMeasurementText = "torDisclosureHttpsNewSession: "
-	case TorDisclosureHttpsRespondPermission:
-		flutterMeasurementText = "\ntorDisclosureHttpsRespondPermission: "
-	case TorIssuanceHttpsNewSession:
-		flutterMeasurementText = "torIssuanceHttpsNewSession: "
-	case TorIssuanceHttpsRespondPermission:
-		flutterMeasurementText = "\ntorIssuanceHttpsRespondPermission: "
-
-	case KssHttpsGetCommitments:
-		flutterMeasurementText = "\nkssHttpsGetCommitments: "
-	case KssHttpsGetProofPs:
-		flutterMeasurementText = "\nkssHttpsGetProofPs: "
-	case TorKssHttpsGetCommitments:
-		flutterMeasurementText = "\ntorKssHttpsGetCommitments: "
-	case TorKssHttpsGetProofPs:
-		flutterMeasurementText = "\ntorKssHttpsGetProofPs: "
-		// HTTPS - end
-	}
-
-	return flutterMeasurementText
+// readMeasurementRecords reads the records from a JSON Lines file of measurement records.
+func readMeasurementRecords(filePath string) ([]*MeasurementRecord, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadMeasurementRecords(file)
 }
 
-// public functions
-
-func IncrementMeasurementAndDetermineAgain() bool {
-	const totalMeasurements = 25
-
-	measurementsDone := determineMeasurementsDone(filePath)
-	measurementsDone++
-	replaceFileContentWithString(
-		filePath,
-		measurementsDoneText+strconv.Itoa(measurementsDone),
-	)
+// groupedSummary returns the statistics of the measurements of each kind per group, as returned
+// by group; records for which group returns the empty string are skipped.
+func groupedSummary(records []*MeasurementRecord, group func(record *MeasurementRecord) string) string {
+	var kinds []MeasurementKind
+	var groups []string
+	results := map[MeasurementKind]map[string][]float64{}
+	for _, record := range records {
+		g := group(record)
+		if g == "" {
+			continue
+		}
+		if results[record.MeasurementKind] == nil {
+			results[record.MeasurementKind] = map[string][]float64{}
+			kinds = append(kinds, record.MeasurementKind)
+		}
+		if _, ok := results[record.MeasurementKind][g]; !ok {
+			groups = append(groups, g)
+		}
+		results[record.MeasurementKind][g] = append(
+			results[record.MeasurementKind][g], float64(record.Duration.Microseconds()),
+		)
+	}
 
-	if measurementsDone < totalMeasurements {
-		return true
-	} else {
-		return false
+	summary := ""
+	for _, kind := range kinds {
+		for _, g := range groups {
+			if results[kind][g] == nil {
+				continue
+			}
+			stats := NewMeasurementStatistics(results[kind][g])
+			summary += "The " + kind.Label() + " statistics " + g + " are: " + stats.String() + "\n"
+		}
 	}
+	return summary
 }
 
-func SendResultsAndResetMeasurements() {
-	var filePaths []string
-	emailText := ""
-
-	disclosureNewSessionAverageFilePath := folderPath +
-		filePartDisclosureNewSession
-
-	filePaths, emailText = addFilePathAndEmailTextIfExist(filePaths,
-		disclosureNewSessionAverageFilePath,
-		emailText,
-		"disclosure new session")
-
-	disclosureRespondPermissionAverageFilePath := folderPath +
-		filePartDisclosureRespondPermission
-
-	filePaths, emailText = addFilePathAndEmailTextIfExist(filePaths,
-		disclosureRespondPermissionAverageFilePath,
-		emailText,
-		"disclosure respond permission")
-
-	issuanceNewSessionAverageFilePath := folderPath +
-		filePartIssuanceNewSession
-
-	filePaths, emailText = addFilePathAndEmailTextIfExist(filePaths,
-		issuanceNewSessionAverageFilePath,
-		emailText,
-		"issuance new session")
-
-	issuanceRespondPermissionAverageFilePath := folderPath +
-		filePartIssuanceRespondPermission
-
-	filePaths, emailText = addFilePathAndEmailTextIfExist(filePaths,
-		issuanceRespondPermissionAverageFilePath,
-		emailText,
-		"issuance respond permission")
-
-	torDisclosureNewSessionAverageFilePath := folderPath +
-		filePartTorDisclosureNewSession
-
-	filePaths, emailText = addFilePathAndEmailTextIfExist(filePaths,
-		torDisclosureNewSessionAverageFilePath,
-		emailText,
-		"disclosure new session over Tor")
-
-	torDisclosureRespondPermissionAverageFilePath := folderPath +
-		filePartTorDisclosureRespondPermission
-
-	filePaths, emailText = addFilePathAndEmailTextIfExist(filePaths,
-		torDisclosureRespondPermissionAverageFilePath,
-		emailText,
-		"disclosure respond permission over Tor")
-
-	torIssuanceNewSessionAverageFilePath := folderPath +
-		filePartTorIssuanceNewSession
-
-	filePaths, emailText = addFilePathAndEmailTextIfExist(filePaths,
-		torIssuanceNewSessionAverageFilePath,
-		emailText,
-		"issuance new session over Tor")
-
-	torIssuanceRespondPermissionAverageFilePath := folderPath +
-		filePartTorIssuanceRespondPermission
-
-	filePaths, emailText = addFilePathAndEmailTextIfExist(filePaths,
-		torIssuanceRespondPermissionAverageFilePath,
-		emailText,
-		"issuance respond permission over Tor")
-
-	// KSS - start
-	kssGetCommitmentsAverageFilePath := folderPath +
-		filePartKssGetCommitments
-
-	filePaths, emailText = addFilePathAndEmailTextIfExist(filePaths,
-		kssGetCommitmentsAverageFilePath,
-		emailText,
-		"KSS GetCommitments")
-
-	kssGetProofPsAverageFilePath := folderPath +
-		filePartKssGetProofPs
-
-	filePaths, emailText = addFilePathAndEmailTextIfExist(filePaths,
-		kssGetProofPsAverageFilePath,
-		emailText,
-		"KSS GetProofPs")
-
-	torKssGetCommitmentsAverageFilePath := folderPath +
-		filePartTorKssGetCommitments
-
-	filePaths, emailText = addFilePathAndEmailTextIfExist(filePaths,
-		torKssGetCommitmentsAverageFilePath,
-		emailText,
-		"Tor KSS GetCommitments")
-
-	torKssGetProofPsAverageFilePath := folderPath +
-		filePartTorKssGetProofPs
-
-	filePaths, emailText = addFilePathAndEmailTextIfExist(filePaths,
-		torKssGetProofPsAverageFilePath,
-		emailText,
-		"Tor KSS GetProofPs")
-	// KSS - end
-
-	// HTTPS - start
-	disclosureHttpsNewSessionAverageFilePath := folderPath +
-		filePartDisclosureHttpsNewSession
-
-	filePaths, emailText = addFilePathAndEmailTextIfExist(filePaths,
-		disclosureHttpsNewSessionAverageFilePath,
-		emailText,
-		"disclosure new session over HTTPS")
-
-	disclosureHttpsRespondPermissionAverageFilePath := folderPath +
-		filePartDisclosureHttpsRespondPermission
-
-	filePaths, emailText = addFilePathAndEmailTextIfExist(filePaths,
-		disclosureHttpsRespondPermissionAverageFilePath,
-		emailText,
-		"disclosure respond permission over HTTPS")
+// keyshareSummary returns the statistics of the measurements of each kind per keyshare server.
+func keyshareSummary(records []*MeasurementRecord) string {
+	return groupedSummary(records, func(record *MeasurementRecord) string {
+		if record.SchemeManager == "" {
+			return ""
+		}
+		return "at keyshare server " + record.SchemeManager
+	})
+}
 
-	issuanceHttpsNewSessionAverageFilePath := folderPath +
-		filePartIssuanceHttpsNewSession
+// clientSummary returns the statistics of the cryptographic work of the client per session type
+// and amount of disclosed attributes.
+func clientSummary(records []*MeasurementRecord) string {
+	return groupedSummary(records, func(record *MeasurementRecord) string {
+		if record.Operation.info().subject != subjectClient {
+			return ""
+		}
+		return fmt.Sprintf("of %s sessions disclosing %d attributes", record.Action, record.DisclosedAttrs)
+	})
+}
 
-	filePaths, emailText = addFilePathAndEmailTextIfExist(filePaths,
-		issuanceHttpsNewSessionAverageFilePath,
-		emailText,
-		"issuance new session over HTTPS")
+func appendToFile(filePath string, write func(file *os.File) error) error {
+	if err := common.EnsureDirectoryExists(filepath.Dir(filePath)); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if err = write(file); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
 
-	issuanceHttpsRespondPermissionAverageFilePath := folderPath +
-		filePartIssuanceHttpsRespondPermission
+func (m *Measurements) filePath(name string) string {
+	return filepath.Join(m.Path, name)
+}
 
-	filePaths, emailText = addFilePathAndEmailTextIfExist(filePaths,
-		issuanceHttpsRespondPermissionAverageFilePath,
-		emailText,
-		"issuance respond permission over HTTPS")
+func (m *Measurements) writeRecord(record *MeasurementRecord) error {
+	err := appendToFile(m.filePath(fileRecordsJSONL), func(file *os.File) error {
+		return NewJSONLRecordWriter(file).WriteRecord(record)
+	})
+	if err != nil {
+		return err
+	}
 
-	torDisclosureHttpsNewSessionAverageFilePath := folderPath +
-		filePartTorDisclosureHttpsNewSession
+	return appendToFile(m.filePath(fileRecordsCSV), func(file *os.File) error {
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		return NewCSVRecordWriter(file, info.Size() == 0).WriteRecord(record)
+	})
+}
 
-	filePaths, emailText = addFilePathAndEmailTextIfExist(filePaths,
-		torDisclosureHttpsNewSessionAverageFilePath,
-		emailText,
-		"disclosure new session over Tor and HTTPS")
+// public functions
 
-	torDisclosureHttpsRespondPermissionAverageFilePath := folderPath +
-		filePartTorDisclosureHttpsRespondPermission
+// RunID returns the identifier of the current measurement run, which lasts until the results are
+// sent. A new identifier is generated when there is no current run.
+func (m *Measurements) RunID() (string, error) {
+	filePath := m.filePath(fileRunID)
+	if pathDoesExist(filePath) {
+		return determineFileStringContent(filePath)
+	}
+	runID := common.NewSessionToken()
+	return runID, replaceFileContentWithString(filePath, runID)
+}
 
-	filePaths, emailText = addFilePathAndEmailTextIfExist(filePaths,
-		torDisclosureHttpsRespondPermissionAverageFilePath,
-		emailText,
-		"disclosure respond permission over Tor and HTTPS")
+// Records returns the measurement records kept in the directory.
+func (m *Measurements) Records() ([]*MeasurementRecord, error) {
+	return readMeasurementRecords(m.filePath(fileRecordsJSONL))
+}
 
-	torIssuanceHttpsNewSessionAverageFilePath := folderPath +
-		filePartTorIssuanceHttpsNewSession
+// SessionRuns returns the results of the session runs kept in the directory, if any.
+func (m *Measurements) SessionRuns() ([]*SessionRunRecord, error) {
+	filePath := m.filePath(fileSessionsJSONL)
+	if !pathDoesExist(filePath) {
+		return nil, nil
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadSessionRunRecords(file)
+}
 
-	filePaths, emailText = addFilePathAndEmailTextIfExist(filePaths,
-		torIssuanceHttpsNewSessionAverageFilePath,
-		emailText,
-		"issuance new session over Tor and HTTPS")
+// TrafficRecords returns the traffic of the sessions kept in the directory, if any.
+func (m *Measurements) TrafficRecords() ([]*SessionTrafficRecord, error) {
+	filePath := m.filePath(fileTrafficJSONL)
+	if !pathDoesExist(filePath) {
+		return nil, nil
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadSessionTrafficRecords(file)
+}
 
-	torIssuanceHttpsRespondPermissionAverageFilePath := folderPath +
-		filePartTorIssuanceHttpsRespondPermission
+// Add stores the measurement record, both as structured record and in the text files of its kind.
+// Records of sessions that did not succeed, and records under conditions that are not kept in
+// the text files (see MeasurementCondition.inTextFiles), are only stored as structured record.
+// If FlutterFile is set, records of the kinds known to the Flutter app are appended to its file.
+func (m *Measurements) Add(record *MeasurementRecord) error {
+	if err := m.add(record); err != nil {
+		return err
+	}
+	if _, ok := record.flutterOrder(); !ok || !m.FlutterFile || !record.succeeded() {
+		return nil
+	}
+	return m.addFlutterMeasurement(record)
+}
 
-	filePaths, emailText = addFilePathAndEmailTextIfExist(filePaths,
-		torIssuanceHttpsRespondPermissionAverageFilePath,
-		emailText,
-		"issuance respond permission over Tor and HTTPS")
+func (m *Measurements) add(record *MeasurementRecord) error {
+	if record.RunID == "" {
+		runID, err := m.RunID()
+		if err != nil {
+			return err
+		}
+		record.RunID = runID
+	}
+	if err := m.writeRecord(record); err != nil {
+		return err
+	}
+	if !record.succeeded() || !record.inTextFiles() {
+		return nil
+	}
+	return m.addMeasurementResult(record.MeasurementKind, record.Duration.Microseconds())
+}
 
-	// KSS - start
-	kssHttpsGetCommitmentsAverageFilePath := folderPath +
-		filePartKssHttpsGetCommitments
+// IncrementMeasurementAndDetermineAgain counts a measurement of the current run, and returns
+// whether another measurement should be taken to reach the amount of samples of the run.
+func (m *Measurements) IncrementMeasurementAndDetermineAgain() (bool, error) {
+	totalMeasurements := m.Samples
+	if totalMeasurements <= 0 {
+		totalMeasurements = DefaultMeasurementSamples
+	}
 
-	filePaths, emailText = addFilePathAndEmailTextIfExist(filePaths,
-		kssHttpsGetCommitmentsAverageFilePath,
-		emailText,
-		"KSS GetCommitments over HTTPS")
+	filePath := m.filePath(fileMeasurementsDone)
+	measurementsDone, err := determineMeasurementsDone(filePath)
+	if err != nil {
+		return false, err
+	}
+	measurementsDone++
+	err = writeFileAtomically(
+		filePath,
+		[]byte(measurementsDoneText+strconv.Itoa(measurementsDone)),
+	)
+	if err != nil {
+		return false, err
+	}
 
-	kssHttpsGetProofPsAverageFilePath := folderPath +
-		filePartKssHttpsGetProofPs
+	return measurementsDone < totalMeasurements, nil
+}
 
-	filePaths, emailText = addFilePathAndEmailTextIfExist(filePaths,
-		kssHttpsGetProofPsAverageFilePath,
-		emailText,
-		"KSS GetProofPs over HTTPS")
+// SendResultsAndResetMeasurements exports the results of the current measurement run using the
+// exporter. Only if that succeeds, the measurements are removed so that a new run can start.
+// While a campaign is unfinished, the results cannot be sent, as its checkpoint would be removed
+// along with them and the campaign could not be resumed.
+func (m *Measurements) SendResultsAndResetMeasurements(exporter ResultExporter) error {
+	var filePaths []string
+	var err error
+	summary := ""
 
-	torKssHttpsGetCommitmentsAverageFilePath := folderPath +
-		filePartTorKssHttpsGetCommitments
+	done, total, err := m.CampaignProgress()
+	if err != nil {
+		return err
+	}
+	if done < total {
+		return errors.Errorf("cannot send results of unfinished campaign (%d of %d sessions done)", done, total)
+	}
 
-	filePaths, emailText = addFilePathAndEmailTextIfExist(filePaths,
-		torKssHttpsGetCommitmentsAverageFilePath,
-		emailText,
-		"Tor KSS GetCommitments over HTTPS")
+	for _, kind := range MeasurementKinds() {
+		filePaths, summary, err = addFilePathAndSummaryIfExist(filePaths,
+			m.filePath(kind.FileName()),
+			summary,
+			kind.Label())
+		if err != nil {
+			return err
+		}
+	}
 
-	torKssHttpsGetProofPsAverageFilePath := folderPath +
-		filePartTorKssHttpsGetProofPs
+	if filePath := m.filePath(fileRecordsJSONL); pathDoesExist(filePath) {
+		records, err := readMeasurementRecords(filePath)
+		if err != nil {
+			return err
+		}
+		summary += keyshareSummary(records) + clientSummary(records)
+	}
 
-	filePaths, emailText = addFilePathAndEmailTextIfExist(filePaths,
-		torKssHttpsGetProofPsAverageFilePath,
-		emailText,
-		"Tor KSS GetProofPs over HTTPS")
-	// KSS - end
-	// HTTPS - end
+	for _, name := range structuredFiles {
+		if filePath := m.filePath(name); pathDoesExist(filePath) {
+			filePaths = append(filePaths, filePath)
+		}
+	}
 
-	emailText += "The averages are in microseconds."
+	manifestPath, err := m.WriteManifest()
+	if err != nil {
+		return err
+	}
+	filePaths = append(filePaths, manifestPath)
+	manifest, err := m.Manifest()
+	if err != nil {
+		return err
+	}
+	summary += manifest.summary()
+	summary += "All values are in microseconds."
 
-	sendMail(emailText, filePaths)
+	runID, err := m.RunID()
+	if err != nil {
+		return err
+	}
+	export := &MeasurementExport{RunID: runID, Summary: summary, Files: filePaths}
+	if err = exporter.Export(export); err != nil {
+		return errors.WrapPrefix(err, "failed to export measurements", 0)
+	}
 
-	for _, filePath := range filePaths {
-		deleteMeasurementsDoneFile(filePath)
+	for _, filePath := range append(filePaths, m.filePath(fileRunID)) {
+		if err = deleteMeasurementsDoneFile(filePath); err != nil {
+			return err
+		}
 	}
 
-	replaceFileContentWithString(filePath, measurementsDoneText+"0")
+	return replaceFileContentWithString(m.filePath(fileMeasurementsDone), measurementsDoneText+"0")
 }
 
-func AddMeasurementResult(measurementType int, result int64) {
-	filePath := ""
-
-	switch measurementType {
-	case DisclosureNewSession:
-		filePath = folderPath + filePartDisclosureNewSession
-	case DisclosureRespondPermission:
-		filePath = folderPath + filePartDisclosureRespondPermission
-	case IssuanceNewSession:
-		filePath = folderPath + filePartIssuanceNewSession
-	case IssuanceRespondPermission:
-		filePath = folderPath + filePartIssuanceRespondPermission
-	case TorDisclosureNewSession:
-		filePath = folderPath + filePartTorDisclosureNewSession
-	case TorDisclosureRespondPermission:
-		filePath = folderPath + filePartTorDisclosureRespondPermission
-	case TorIssuanceNewSession:
-		filePath = folderPath + filePartTorIssuanceNewSession
-	case TorIssuanceRespondPermission:
-		filePath = folderPath + filePartTorIssuanceRespondPermission
-
-	case KssGetCommitments:
-		filePath = folderPath + filePartKssGetCommitments
-	case KssGetProofPs:
-		filePath = folderPath + filePartKssGetProofPs
-	case TorKssGetCommitments:
-		filePath = folderPath + filePartTorKssGetCommitments
-	case TorKssGetProofPs:
-		filePath = folderPath + filePartTorKssGetProofPs
-
-		// HTTPS - start
-	case DisclosureHttpsNewSession:
-		filePath = folderPath + filePartDisclosureHttpsNewSession
-	case DisclosureHttpsRespondPermission:
-		filePath = folderPath + filePartDisclosureHttpsRespondPermission
-	case IssuanceHttpsNewSession:
-		filePath = folderPath + filePartIssuanceHttpsNewSession
-	case IssuanceHttpsRespondPermission:
-		filePath = folderPath + filePartIssuanceHttpsRespondPermission
-	case TorDisclosureHttpsNewSession:
-		filePath = folderPath + filePartTorDisclosureHttpsNewSession
-	case TorDisclosureHttpsRespondPermission:
-		filePath = folderPath + filePartTorDisclosureHttpsRespondPermission
-	case TorIssuanceHttpsNewSession:
-		filePath = folderPath + filePartTorIssuanceHttpsNewSession
-	case TorIssuanceHttpsRespondPermission:
-		filePath = folderPath + filePartTorIssuanceHttpsRespondPermission
-
-	case KssHttpsGetCommitments:
-		filePath = folderPath + filePartKssHttpsGetCommitments
-	case KssHttpsGetProofPs:
-		filePath = folderPath + filePartKssHttpsGetProofPs
-	case TorKssHttpsGetCommitments:
-		filePath = folderPath + filePartTorKssHttpsGetCommitments
-	case TorKssHttpsGetProofPs:
-		filePath = folderPath + filePartTorKssHttpsGetProofPs
-		// HTTPS - end
-	}
+// addMeasurementResult appends the result, in microseconds, to the measurements of the specified kind.
+func (m *Measurements) addMeasurementResult(kind MeasurementKind, result int64) error {
+	filePath := m.filePath(kind.FileName())
 
 	stringContent := ""
 
 	if pathDoesExist(filePath) {
-		stringContent = determineFileStringContent(filePath)
+		var err error
+		if stringContent, err = determineFileStringContent(filePath); err != nil {
+			return err
+		}
 	}
 
 	possibleLineFeed := "\n"
@@ -580,29 +457,46 @@ func AddMeasurementResult(measurementType int, result int64) {
 
 	stringContent += possibleLineFeed + measurementText + stringResult
 
-	replaceFileContentWithString(filePath, stringContent)
+	return replaceFileContentWithString(filePath, stringContent)
+}
 
-	// for Flutter part
+// addFlutterMeasurement appends the record to the file read by the Flutter app.
+func (m *Measurements) addFlutterMeasurement(record *MeasurementRecord) error {
+	filePathFlutter := m.filePath(fileFlutter)
 	stringContentFlutter := ""
 
 	if pathDoesExist(filePathFlutter) {
-		stringContentFlutter = determineFileStringContent(filePathFlutter)
+		var err error
+		if stringContentFlutter, err = determineFileStringContent(filePathFlutter); err != nil {
+			return err
+		}
 	}
 
 	stringContentFlutter +=
-		determineFlutterMeasurementText(measurementType) + stringResult
+		record.flutterText() + strconv.FormatInt(record.Duration.Microseconds(), 10)
 
-	replaceFileContentWithString(filePathFlutter, stringContentFlutter)
+	return replaceFileContentWithString(filePathFlutter, stringContentFlutter)
 }
 
-func StopProgramWhenNeeded(useTor bool, httpClient *http.Client) {
-	if httpClient != nil {
-		if useTor && !IsClientConnectedToTor(httpClient) {
-			log.Fatal("use Tor && client is not connected to Tor")
-		}
+// CheckTorConnection returns an error if useTor is set, but there is no httpClient or it does not
+// connect over Tor. The error is a *SessionError of type ErrorTor.
+func CheckTorConnection(useTor bool, httpClient *http.Client) error {
+	if !useTor {
+		return nil
+	}
+	if httpClient == nil {
+		return &SessionError{ErrorType: ErrorTor, Err: errors.New("use Tor && client has no Tor connection")}
+	}
+	connected, err := IsClientConnectedToTor(httpClient)
+	if err != nil {
+		return &SessionError{ErrorType: ErrorTor, Err: err}
+	}
+	if !connected {
+		return &SessionError{ErrorType: ErrorTor, Err: errors.New("use Tor && client is not connected to Tor")}
 	}
+	return nil
 }
 
-func ClearFlutterMeasurements() {
-	replaceFileContentWithString(filePathFlutter, "")
+func (m *Measurements) ClearFlutterMeasurements() error {
+	return replaceFileContentWithString(m.filePath(fileFlutter), "")
 }
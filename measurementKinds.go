@@ -0,0 +1,323 @@
+package irma
+
+import (
+	"strings"
+
+	"github.com/go-errors/errors"
+)
+
+// This file contains the model of the things we time during IRMA sessions. A MeasurementKind is
+// an operation (what is being timed) combined with a MeasurementCondition (over which network path
+// and which URL scheme it was timed). File names, report labels and the text passed to the Flutter
+// app are all derived from the registry below, so adding an operation is a matter of adding one
+// line to measurementOperations.
+
+// MeasurementOperation identifies what is being timed, e.g. the first GET of a disclosure session.
+type MeasurementOperation string
+
+// MeasurementNetwork is the network path over which a measurement was taken.
+type MeasurementNetwork string
+
+// MeasurementScheme is the URL scheme over which a measurement was taken.
+type MeasurementScheme string
+
+// MeasurementCondition is the combination of network path and URL scheme of a measurement, along
+// with the encoding of the session messages if they were not JSON-encoded, and the network shape
+// (see NetworkShape) if network conditions were emulated. The encoding and the shape are not part
+// of the names of measurement kinds; they distinguish the measurements in reports.
+type MeasurementCondition struct {
+	Network  MeasurementNetwork `json:"network"`
+	Scheme   MeasurementScheme  `json:"scheme"`
+	Encoding MessageEncoding    `json:"encoding,omitempty"`
+	Shape    string             `json:"shape,omitempty"`
+}
+
+// MeasurementKind identifies a series of measurements: one operation under one condition.
+type MeasurementKind struct {
+	Operation MeasurementOperation `json:"operation"`
+	MeasurementCondition
+}
+
+const (
+	OperationDisclosureNewSession        = MeasurementOperation("disclosureNewSession")
+	OperationDisclosureRespondPermission = MeasurementOperation("disclosureRespondPermission")
+	OperationIssuanceNewSession          = MeasurementOperation("issuanceNewSession")
+	OperationIssuanceRespondPermission   = MeasurementOperation("issuanceRespondPermission")
+	OperationSigningNewSession           = MeasurementOperation("signingNewSession")
+	OperationSigningRespondPermission    = MeasurementOperation("signingRespondPermission")
+	OperationSigningTimestamp            = MeasurementOperation("signingTimestamp")
+	OperationKssGetCommitments           = MeasurementOperation("kssGetCommitments")
+	OperationKssGetProofPs               = MeasurementOperation("kssGetProofPs")
+	OperationKssRound                    = MeasurementOperation("kssRound")
+
+	OperationClientProofBuilders         = MeasurementOperation("clientProofBuilders")
+	OperationClientProofs                = MeasurementOperation("clientProofs")
+	OperationClientIssuanceProofBuilders = MeasurementOperation("clientIssuanceProofBuilders")
+	OperationClientIssueCommitments      = MeasurementOperation("clientIssueCommitments")
+	OperationClientConstructCredentials  = MeasurementOperation("clientConstructCredentials")
+	OperationClientChallenge             = MeasurementOperation("clientChallenge")
+	OperationClientNonrevPrepare         = MeasurementOperation("clientNonrevPrepare")
+	OperationClientNonrevUpdate          = MeasurementOperation("clientNonrevUpdate")
+
+	OperationRevocationFetchUpdate   = MeasurementOperation("revocationFetchUpdate")
+	OperationRevocationWitnessUpdate = MeasurementOperation("revocationWitnessUpdate")
+
+	OperationTorBootstrap      = MeasurementOperation("torBootstrap")
+	OperationTorFirstDialer    = MeasurementOperation("torFirstDialer")
+	OperationTorCircuitRenewal = MeasurementOperation("torCircuitRenewal")
+)
+
+const (
+	NetworkDirect = MeasurementNetwork("direct")
+	NetworkTor    = MeasurementNetwork("tor")
+)
+
+const (
+	SchemeHTTP  = MeasurementScheme("http")
+	SchemeHTTPS = MeasurementScheme("https")
+)
+
+// subjectClient is the subject of the cryptographic work done by the client, as opposed to
+// network round trips.
+const subjectClient = "client"
+
+// subjectTor is the subject of the work done by the embedded Tor client before sessions start.
+// Operations of this subject only occur under conditions using the Tor network.
+const subjectTor = "tor"
+
+// Phases of sessions that are measured for each session type.
+const (
+	phaseNewSession        = "NewSession"
+	phaseRespondPermission = "RespondPermission"
+)
+
+type measurementOperationInfo struct {
+	operation MeasurementOperation
+	subject   string // first part of the name, e.g. "disclosure"
+	phase     string // second part of the name, e.g. "NewSession"
+	label     string // used in reports, e.g. "disclosure new session"
+}
+
+// measurementOperations contains all known operations, in the order in which they are reported.
+var measurementOperations = []measurementOperationInfo{
+	{OperationDisclosureNewSession, "disclosure", phaseNewSession, "disclosure new session"},
+	{OperationDisclosureRespondPermission, "disclosure", phaseRespondPermission, "disclosure respond permission"},
+	{OperationIssuanceNewSession, "issuance", phaseNewSession, "issuance new session"},
+	{OperationIssuanceRespondPermission, "issuance", phaseRespondPermission, "issuance respond permission"},
+	{OperationSigningNewSession, "signing", phaseNewSession, "signing new session"},
+	{OperationSigningRespondPermission, "signing", phaseRespondPermission, "signing respond permission"},
+	{OperationSigningTimestamp, "signing", "Timestamp", "signing timestamp request"},
+	{OperationKssGetCommitments, "kss", "GetCommitments", "KSS GetCommitments"},
+	{OperationKssGetProofPs, "kss", "GetProofPs", "KSS GetProofPs"},
+	{OperationKssRound, "kss", "Round", "KSS round"},
+	{OperationClientProofBuilders, subjectClient, "ProofBuilders", "client ProofBuilders"},
+	{OperationClientProofs, subjectClient, "Proofs", "client Proofs"},
+	{OperationClientIssuanceProofBuilders, subjectClient, "IssuanceProofBuilders", "client IssuanceProofBuilders"},
+	{OperationClientIssueCommitments, subjectClient, "IssueCommitments", "client IssueCommitments"},
+	{OperationClientConstructCredentials, subjectClient, "ConstructCredentials", "client ConstructCredentials"},
+	{OperationClientChallenge, subjectClient, "Challenge", "client keyshare challenge"},
+	{OperationClientNonrevPrepare, subjectClient, "NonrevPrepare", "client NonrevPrepare"},
+	{OperationClientNonrevUpdate, subjectClient, "NonrevUpdate", "client witness update"},
+	{OperationRevocationFetchUpdate, "revocation", "FetchUpdate", "revocation update fetch"},
+	{OperationRevocationWitnessUpdate, "revocation", "WitnessUpdate", "revocation witness update computation"},
+	{OperationTorBootstrap, subjectTor, "Bootstrap", "Tor bootstrap"},
+	{OperationTorFirstDialer, subjectTor, "FirstDialer", "time to first Tor dialer"},
+	{OperationTorCircuitRenewal, subjectTor, "CircuitRenewal", "Tor circuit renewal"},
+}
+
+// measurementConditions contains all known conditions, in the order in which they are reported.
+var measurementConditions = []MeasurementCondition{
+	{Network: NetworkDirect, Scheme: SchemeHTTP},
+	{Network: NetworkTor, Scheme: SchemeHTTP},
+	{Network: NetworkDirect, Scheme: SchemeHTTPS},
+	{Network: NetworkTor, Scheme: SchemeHTTPS},
+}
+
+// measurementSubjects maps session types to the subject of their measurement operations.
+var measurementSubjects = map[Action]string{
+	ActionDisclosing: "disclosure",
+	ActionIssuing:    "issuance",
+	ActionSigning:    "signing",
+}
+
+const measurementTypeSuffix = "Measurement"
+
+func (op MeasurementOperation) info() measurementOperationInfo {
+	for _, info := range measurementOperations {
+		if info.operation == op {
+			return info
+		}
+	}
+	// Unregistered operations are named after themselves
+	return measurementOperationInfo{operation: op, subject: string(op), label: string(op)}
+}
+
+// Label returns a human-readable description of the operation.
+func (op MeasurementOperation) Label() string {
+	return op.info().label
+}
+
+// MeasurementKinds returns all known measurement kinds, in the order in which they are reported.
+func MeasurementKinds() []MeasurementKind {
+	var kinds []MeasurementKind
+	for _, condition := range measurementConditions {
+		for _, info := range measurementOperations {
+			if info.subject == subjectTor && condition.Network != NetworkTor {
+				continue
+			}
+			kinds = append(kinds, MeasurementKind{Operation: info.operation, MeasurementCondition: condition})
+		}
+	}
+	return kinds
+}
+
+// NewMeasurementKind returns the kind of the specified operation under the specified condition.
+func NewMeasurementKind(op MeasurementOperation, condition MeasurementCondition) MeasurementKind {
+	return MeasurementKind{Operation: op, MeasurementCondition: condition}
+}
+
+// ParseMeasurementKind returns the kind having the specified name, as returned by Name().
+func ParseMeasurementKind(name string) (MeasurementKind, error) {
+	for _, kind := range MeasurementKinds() {
+		if kind.Name() == name {
+			return kind, nil
+		}
+	}
+	return MeasurementKind{}, errors.Errorf("unknown measurement kind %s", name)
+}
+
+// Name returns the identifier of the kind, e.g. torDisclosureHttpsNewSession. Operations of the
+// Tor client itself are not prefixed twice, e.g. torHttpsBootstrap.
+func (kind MeasurementKind) Name() string {
+	info := kind.Operation.info()
+	name := info.subject
+	if kind.Network == NetworkTor && info.subject != subjectTor {
+		name = "tor" + upperFirst(name)
+	}
+	if kind.Scheme == SchemeHTTPS {
+		name += "Https"
+	}
+	return name + info.phase
+}
+
+// Label returns a human-readable description of the kind, e.g.
+// "disclosure new session over Tor and HTTPS".
+func (kind MeasurementKind) Label() string {
+	return kind.Operation.Label() + kind.MeasurementCondition.labelSuffix()
+}
+
+// FileName returns the name of the file in which measurements of this kind are kept.
+func (kind MeasurementKind) FileName() string {
+	return kind.Name() + ".txt"
+}
+
+// flutterText returns the text preceding measurements of this kind in the file read by the
+// Flutter app. The new session measurement starts the text of a session.
+func (kind MeasurementKind) flutterText() string {
+	text := kind.Name() + ": "
+	if kind.Operation.info().phase != phaseNewSession {
+		text = "\n" + text
+	}
+	return text
+}
+
+// flutterOrder returns the position of measurements of this kind among those of a session in the
+// file read by the Flutter app, or false if they are not written to it. The app only knows the
+// kinds that the package-level measurement functions measured, in the order in which they wrote
+// them: the new session, the response after permission, and then those of the keyshare server.
+func (kind MeasurementKind) flutterOrder() (int, bool) {
+	if !kind.inTextFiles() || !isLegacyMeasurementKind(kind.Name()) {
+		return 0, false
+	}
+	switch kind.Operation {
+	case OperationKssGetCommitments:
+		return 2, true
+	case OperationKssGetProofPs:
+		return 3, true
+	}
+	if kind.Operation.info().phase == phaseNewSession {
+		return 0, true
+	}
+	return 1, true
+}
+
+// inTextFiles returns whether measurements under the condition are kept in the text files of
+// their kind, which are summarised in the results and shown in the app. As these files are
+// identified by the network and scheme only, measurements of CBOR-encoded messages and over
+// shaped connections are kept out of them, so that they are not mixed with those of JSON
+// messages over real connections.
+func (condition MeasurementCondition) inTextFiles() bool {
+	return (condition.Encoding == "" || condition.Encoding == EncodingJSON) && condition.Shape == ""
+}
+
+func (condition MeasurementCondition) labelSuffix() string {
+	switch {
+	case condition.Network == NetworkTor && condition.Scheme == SchemeHTTPS:
+		return " over Tor and HTTPS"
+	case condition.Network == NetworkTor:
+		return " over Tor"
+	case condition.Scheme == SchemeHTTPS:
+		return " over HTTPS"
+	default:
+		return ""
+	}
+}
+
+// ParseMeasurementType parses measurement types as set by the app into irmaclient.Client, e.g.
+// "torDisclosureHttpsMeasurement", into the subject of the session to be measured
+// (e.g. "disclosure") and the condition under which it is measured.
+func ParseMeasurementType(measurementType string) (string, MeasurementCondition, error) {
+	condition := MeasurementCondition{Network: NetworkDirect, Scheme: SchemeHTTP}
+	if !strings.HasSuffix(measurementType, measurementTypeSuffix) {
+		return "", condition, errors.Errorf("unknown measurement type %s", measurementType)
+	}
+	subject := strings.TrimSuffix(measurementType, measurementTypeSuffix)
+	if strings.HasPrefix(subject, "tor") {
+		condition.Network = NetworkTor
+		subject = lowerFirst(strings.TrimPrefix(subject, "tor"))
+	}
+	if strings.HasSuffix(subject, "Https") {
+		condition.Scheme = SchemeHTTPS
+		subject = strings.TrimSuffix(subject, "Https")
+	}
+	if subject == "" {
+		return "", condition, errors.Errorf("unknown measurement type %s", measurementType)
+	}
+	return subject, condition, nil
+}
+
+// MeasurementType returns the measurement type that measures sessions of the specified subject
+// under the specified condition, i.e. the inverse of ParseMeasurementType. It returns the empty
+// string if subject is empty.
+func MeasurementType(subject string, condition MeasurementCondition) string {
+	if subject == "" {
+		return ""
+	}
+	if condition.Network == NetworkTor {
+		subject = "tor" + upperFirst(subject)
+	}
+	if condition.Scheme == SchemeHTTPS {
+		subject += "Https"
+	}
+	return subject + measurementTypeSuffix
+}
+
+// MeasurementSubject returns the subject of the measurement operations of sessions of the
+// specified type, or the empty string if such sessions are not measured.
+func MeasurementSubject(action Action) string {
+	return measurementSubjects[action]
+}
+
+func upperFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
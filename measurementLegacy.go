@@ -0,0 +1,139 @@
+package irma
+
+import (
+	"net/http"
+	"time"
+)
+
+// Measurement types of the package-level measurement functions, used by apps from before
+// Measurements and MeasurementKind were introduced.
+//
+// Deprecated: use MeasurementKind instead.
+const (
+	DisclosureNewSession int = iota
+	DisclosureRespondPermission
+	IssuanceNewSession
+	IssuanceRespondPermission
+	TorDisclosureNewSession
+	TorDisclosureRespondPermission
+	TorIssuanceNewSession
+	TorIssuanceRespondPermission
+
+	KssGetCommitments
+	KssGetProofPs
+	TorKssGetCommitments
+	TorKssGetProofPs
+
+	DisclosureHttpsNewSession
+	DisclosureHttpsRespondPermission
+	IssuanceHttpsNewSession
+	IssuanceHttpsRespondPermission
+	TorDisclosureHttpsNewSession
+	TorDisclosureHttpsRespondPermission
+	TorIssuanceHttpsNewSession
+	TorIssuanceHttpsRespondPermission
+
+	KssHttpsGetCommitments
+	KssHttpsGetProofPs
+	TorKssHttpsGetCommitments
+	TorKssHttpsGetProofPs
+)
+
+// legacyMeasurementKinds contains the names of the kinds of the deprecated measurement types, in
+// the order of these types.
+var legacyMeasurementKinds = []string{
+	"disclosureNewSession", "disclosureRespondPermission", "issuanceNewSession", "issuanceRespondPermission",
+	"torDisclosureNewSession", "torDisclosureRespondPermission", "torIssuanceNewSession", "torIssuanceRespondPermission",
+	"kssGetCommitments", "kssGetProofPs", "torKssGetCommitments", "torKssGetProofPs",
+	"disclosureHttpsNewSession", "disclosureHttpsRespondPermission", "issuanceHttpsNewSession", "issuanceHttpsRespondPermission",
+	"torDisclosureHttpsNewSession", "torDisclosureHttpsRespondPermission", "torIssuanceHttpsNewSession", "torIssuanceHttpsRespondPermission",
+	"kssHttpsGetCommitments", "kssHttpsGetProofPs", "torKssHttpsGetCommitments", "torKssHttpsGetProofPs",
+}
+
+func isLegacyMeasurementKind(name string) bool {
+	for _, legacy := range legacyMeasurementKinds {
+		if name == legacy {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultMeasurementsPath is the directory in which the IRMA app kept its measurements before
+// the directory could be configured.
+const DefaultMeasurementsPath = "/data/user/0/foundation.privacybydesign.irmamobile.alpha/v2"
+
+// DefaultMeasurements keeps the measurements of the package-level measurement functions,
+// including the legacy Flutter file.
+//
+// Deprecated: use the Measurements of irmaclient.Client instead.
+var DefaultMeasurements = &Measurements{Path: DefaultMeasurementsPath, FlutterFile: true}
+
+// DefaultResultExporter is used by SendResultsAndResetMeasurements to export the results of
+// DefaultMeasurements. It must be set before the results can be sent.
+//
+// Deprecated: use Measurements.SendResultsAndResetMeasurements instead.
+var DefaultResultExporter ResultExporter
+
+// IncrementMeasurementAndDetermineAgain counts a measurement of the current run of
+// DefaultMeasurements, and returns whether another measurement should be taken.
+//
+// Deprecated: use Measurements.IncrementMeasurementAndDetermineAgain instead.
+func IncrementMeasurementAndDetermineAgain() bool {
+	again, err := DefaultMeasurements.IncrementMeasurementAndDetermineAgain()
+	if err != nil {
+		Logger.Warn("failed to count measurement: ", err)
+	}
+	return again
+}
+
+// SendResultsAndResetMeasurements exports the results of DefaultMeasurements with
+// DefaultResultExporter, and resets them. If the results cannot be exported, they are kept.
+//
+// Deprecated: use Measurements.SendResultsAndResetMeasurements instead.
+func SendResultsAndResetMeasurements() {
+	if DefaultResultExporter == nil {
+		Logger.Warn("not sending measurement results: no DefaultResultExporter set")
+		return
+	}
+	if err := DefaultMeasurements.SendResultsAndResetMeasurements(DefaultResultExporter); err != nil {
+		Logger.Warn("failed to send measurement results: ", err)
+	}
+}
+
+// AddMeasurementResult adds the result, in microseconds, of the specified measurement type to
+// DefaultMeasurements.
+//
+// Deprecated: use Measurements.Add instead.
+func AddMeasurementResult(measurementType int, result int64) {
+	if measurementType < 0 || measurementType >= len(legacyMeasurementKinds) {
+		Logger.Warnf("unknown measurement type %d", measurementType)
+		return
+	}
+	kind, err := ParseMeasurementKind(legacyMeasurementKinds[measurementType])
+	if err == nil {
+		err = DefaultMeasurements.Add(NewMeasurementRecord(kind, time.Duration(result)*time.Microsecond))
+	}
+	if err != nil {
+		Logger.Warn("failed to add measurement result: ", err)
+	}
+}
+
+// StopProgramWhenNeeded stops the program if useTor is set, but the httpClient does not connect
+// over Tor.
+//
+// Deprecated: use CheckTorConnection instead.
+func StopProgramWhenNeeded(useTor bool, httpClient *http.Client) {
+	if err := CheckTorConnection(useTor, httpClient); err != nil {
+		Logger.Fatal(err)
+	}
+}
+
+// ClearFlutterMeasurements empties the legacy Flutter file of DefaultMeasurements.
+//
+// Deprecated: use Measurements.ClearFlutterMeasurements instead.
+func ClearFlutterMeasurements() {
+	if err := DefaultMeasurements.ClearFlutterMeasurements(); err != nil {
+		Logger.Warn("failed to clear Flutter measurements: ", err)
+	}
+}
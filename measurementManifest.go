@@ -0,0 +1,287 @@
+package irma
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-errors/errors"
+)
+
+// MeasurementManifest describes the environment in which the measurements of a run were taken,
+// so that the results of runs can be reproduced and compared: the versions of the software
+// involved, the schemes, servers and transport settings used, the parameters of the campaign, if
+// any, and a hash of the raw data. It is kept up to date in manifest.json while the run is ongoing,
+// and exported along with the results.
+type MeasurementManifest struct {
+	RunID   string    `json:"runId"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+
+	IrmaVersion string   `json:"irmaVersion"`
+	GoVersion   string   `json:"goVersion"`
+	OS          string   `json:"os"`
+	Arch        string   `json:"arch"`
+	TorVersions []string `json:"torVersions,omitempty"`
+
+	Schemes    []*SchemeVersion       `json:"schemes,omitempty"`
+	ServerURLs []string               `json:"serverUrls,omitempty"`
+	Conditions []MeasurementCondition `json:"conditions,omitempty"`
+	Transports []*TransportSettings   `json:"transports,omitempty"`
+
+	Campaign     *MeasurementCampaign `json:"campaign,omitempty"`
+	CampaignSeed int64                `json:"campaignSeed,omitempty"`
+
+	// Files contains the files of raw data of the run, and DataHash the SHA-256 hash over their
+	// names and hashes, as they were when the manifest was last written by WriteManifest
+	Files    []*ManifestFile `json:"files,omitempty"`
+	DataHash string          `json:"dataHash,omitempty"`
+}
+
+// MeasurementEnvironment is the environment in which the measurements of a session were taken,
+// which is added to the manifest of the run when the session is stored.
+type MeasurementEnvironment struct {
+	TorVersion string             `json:"torVersion,omitempty"`
+	Schemes    []*SchemeVersion   `json:"schemes,omitempty"`
+	Transport  *TransportSettings `json:"transport,omitempty"`
+}
+
+// SchemeVersion identifies the version of a scheme by its timestamp.
+type SchemeVersion struct {
+	ID         string     `json:"id"`
+	URL        string     `json:"url,omitempty"`
+	Timestamp  *Timestamp `json:"timestamp,omitempty"`
+	XMLVersion int        `json:"xmlVersion,omitempty"`
+}
+
+// TransportSettings are the settings of an HTTPTransport that affect the duration of its requests.
+type TransportSettings struct {
+	ForceHTTPS   bool          `json:"forceHttps"`
+	Binary       bool          `json:"binary"`
+	Timeout      time.Duration `json:"timeoutNs"` // of each attempt of a request; 0 if none
+	RetryMax     int           `json:"retryMax"`
+	RetryWaitMin time.Duration `json:"retryWaitMinNs"`
+	RetryWaitMax time.Duration `json:"retryWaitMaxNs"`
+}
+
+// ManifestFile is a file of raw data of a run.
+type ManifestFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// SchemeVersions returns the versions of the schemes in the configuration, sorted by identifier.
+func (conf *Configuration) SchemeVersions() []*SchemeVersion {
+	var versions []*SchemeVersion
+	for id, manager := range conf.SchemeManagers {
+		timestamp := manager.Timestamp
+		versions = append(versions, &SchemeVersion{
+			ID:         id.String(),
+			URL:        manager.URL,
+			Timestamp:  &timestamp,
+			XMLVersion: manager.XMLVersion,
+		})
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].ID < versions[j].ID })
+	return versions
+}
+
+// Settings returns the settings of the transport.
+func (transport *HTTPTransport) Settings() *TransportSettings {
+	return &TransportSettings{
+		ForceHTTPS:   transport.ForceHTTPS,
+		Binary:       transport.Binary,
+		Timeout:      transport.client.HTTPClient.Timeout,
+		RetryMax:     transport.client.RetryMax,
+		RetryWaitMin: transport.client.RetryWaitMin,
+		RetryWaitMax: transport.client.RetryWaitMax,
+	}
+}
+
+func newMeasurementManifest(runID string) *MeasurementManifest {
+	now := time.Now()
+	return &MeasurementManifest{
+		RunID:       runID,
+		Created:     now,
+		Updated:     now,
+		IrmaVersion: Version,
+		GoVersion:   runtime.Version(),
+		OS:          runtime.GOOS,
+		Arch:        runtime.GOARCH,
+	}
+}
+
+// addSession adds the context of the session to the manifest.
+func (manifest *MeasurementManifest) addSession(sm *SessionMeasurements) {
+	if sm.ServerURL != "" {
+		manifest.ServerURLs = appendIfMissing(manifest.ServerURLs, serverBaseURL(sm.ServerURL))
+	}
+	if sm.Action != ActionUnknown {
+		found := false
+		for _, condition := range manifest.Conditions {
+			found = found || condition == sm.Condition
+		}
+		if !found {
+			manifest.Conditions = append(manifest.Conditions, sm.Condition)
+		}
+	}
+
+	env := sm.Environment
+	if env == nil {
+		return
+	}
+	if env.TorVersion != "" {
+		manifest.TorVersions = appendIfMissing(manifest.TorVersions, env.TorVersion)
+	}
+	for _, scheme := range env.Schemes {
+		manifest.addScheme(scheme)
+	}
+	if env.Transport != nil {
+		found := false
+		for _, transport := range manifest.Transports {
+			found = found || *transport == *env.Transport
+		}
+		if !found {
+			manifest.Transports = append(manifest.Transports, env.Transport)
+		}
+	}
+}
+
+// addScheme adds the scheme version to the manifest, unless it contains it already. If the
+// scheme was updated during the run, the manifest contains each of its versions.
+func (manifest *MeasurementManifest) addScheme(scheme *SchemeVersion) {
+	for _, s := range manifest.Schemes {
+		if s.ID == scheme.ID && s.XMLVersion == scheme.XMLVersion &&
+			(s.Timestamp == nil) == (scheme.Timestamp == nil) &&
+			(s.Timestamp == nil || time.Time(*s.Timestamp).Equal(time.Time(*scheme.Timestamp))) {
+			return
+		}
+	}
+	manifest.Schemes = append(manifest.Schemes, scheme)
+}
+
+// summary returns a description of the manifest for the summary of the results.
+func (manifest *MeasurementManifest) summary() string {
+	summary := fmt.Sprintf("Measured with irmago %s, built with %s, on %s/%s", manifest.IrmaVersion, manifest.GoVersion, manifest.OS, manifest.Arch)
+	if len(manifest.TorVersions) > 0 {
+		summary += ", using Tor " + strings.Join(manifest.TorVersions, " and ")
+	}
+	return summary + "; see " + fileManifest + " for details. The SHA-256 hash of the data is " + manifest.DataHash + ".\n"
+}
+
+// serverBaseURL returns the URL of the server of the session at the specified URL, i.e. without
+// the session token.
+func serverBaseURL(sessionURL string) string {
+	u := strings.TrimSuffix(sessionURL, "/")
+	if i := strings.LastIndex(u, "/session/"); i >= 0 && !strings.Contains(u[i+len("/session/"):], "/") {
+		u = u[:i]
+	}
+	return u + "/"
+}
+
+func appendIfMissing(list []string, s string) []string {
+	for _, item := range list {
+		if item == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+// Manifest returns the manifest of the current run, or a new one if none was written yet.
+func (m *Measurements) Manifest() (*MeasurementManifest, error) {
+	filePath := m.filePath(fileManifest)
+	if !pathDoesExist(filePath) {
+		runID, err := m.RunID()
+		if err != nil {
+			return nil, err
+		}
+		return newMeasurementManifest(runID), nil
+	}
+	bts, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &MeasurementManifest{}
+	if err = json.Unmarshal(bts, manifest); err != nil {
+		return nil, errors.WrapPrefix(err, "failed to read measurement manifest", 0)
+	}
+	return manifest, nil
+}
+
+// updateManifest applies update to the manifest of the current run and stores it.
+func (m *Measurements) updateManifest(update func(manifest *MeasurementManifest) error) error {
+	manifest, err := m.Manifest()
+	if err != nil {
+		return err
+	}
+	if err = update(manifest); err != nil {
+		return err
+	}
+	manifest.Updated = time.Now()
+	bts, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(m.filePath(fileManifest), bts)
+}
+
+// WriteManifest completes the manifest of the current run with the hashes of its files of raw
+// data, and returns the path of the manifest.
+func (m *Measurements) WriteManifest() (string, error) {
+	err := m.updateManifest(func(manifest *MeasurementManifest) error {
+		manifest.Files = nil
+		total := sha256.New()
+		for _, filePath := range m.dataFiles() {
+			file, err := hashFile(filePath)
+			if err != nil {
+				return err
+			}
+			manifest.Files = append(manifest.Files, file)
+			_, _ = io.WriteString(total, file.Name+" "+file.SHA256+"\n")
+		}
+		manifest.DataHash = hex.EncodeToString(total.Sum(nil))
+		return nil
+	})
+	return m.filePath(fileManifest), err
+}
+
+// dataFiles returns the paths of the existing files of raw data of the current run.
+func (m *Measurements) dataFiles() []string {
+	var names []string
+	for _, kind := range MeasurementKinds() {
+		names = append(names, kind.FileName())
+	}
+	names = append(names, structuredFiles...)
+	var filePaths []string
+	for _, name := range names {
+		if filePath := m.filePath(name); pathDoesExist(filePath) {
+			filePaths = append(filePaths, filePath)
+		}
+	}
+	return filePaths
+}
+
+func hashFile(filePath string) (*ManifestFile, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return nil, err
+	}
+	return &ManifestFile{Name: filepath.Base(filePath), Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
@@ -0,0 +1,201 @@
+package irma
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/go-errors/errors"
+)
+
+// MeasurementOutcome is the outcome of the session in which a measurement was taken.
+type MeasurementOutcome string
+
+const (
+	OutcomeSuccess        = MeasurementOutcome("success")
+	OutcomeTransportError = MeasurementOutcome("transportError")
+	OutcomeTimeout        = MeasurementOutcome("timeout")
+	OutcomeRejected       = MeasurementOutcome("rejected")
+	OutcomeKeyshareError  = MeasurementOutcome("keyshareError")
+	OutcomeCancelled      = MeasurementOutcome("cancelled")
+	OutcomeError          = MeasurementOutcome("error") // any other error
+)
+
+// MeasurementRecord is a single measurement along with the context in which it was taken.
+type MeasurementRecord struct {
+	Timestamp time.Time `json:"timestamp"`
+	RunID     string    `json:"runId"`
+	Kind      string    `json:"kind"`
+	MeasurementKind
+	Duration time.Duration `json:"durationNs"`
+
+	Action          Action             `json:"action,omitempty"`
+	ServerURL       string             `json:"serverUrl,omitempty"`
+	Hostname        string             `json:"hostname,omitempty"`
+	ProtocolVersion *ProtocolVersion   `json:"protocolVersion,omitempty"`
+	SessionID       string             `json:"sessionId,omitempty"`
+	SessionToken    string             `json:"sessionToken,omitempty"`
+	Keyshare        bool               `json:"keyshare"`
+	SchemeManager   string             `json:"schemeManager,omitempty"` // keyshare server of KSS measurements
+	DisclosedAttrs  int                `json:"disclosedAttributes"`
+	ServerDuration  time.Duration      `json:"serverDurationNs,omitempty"` // as reported in the Server-Timing header
+	Outcome         MeasurementOutcome `json:"outcome"`
+	ErrorType       ErrorType          `json:"errorType,omitempty"` // of the error that failed the session
+
+	// Failed is set on the measurement of the operation that failed the session, of which the
+	// duration is the time until the failure.
+	Failed bool `json:"failed,omitempty"`
+
+	// Of revocation measurements: the credential type, the amount of bytes received when fetching
+	// updates, and the amount of revocation events fetched or applied to the witness
+	CredentialType string `json:"credentialType,omitempty"`
+	PayloadSize    int64  `json:"payloadSize,omitempty"`
+	Events         int    `json:"events,omitempty"`
+}
+
+// succeeded returns whether the record is not of a session that did not succeed.
+func (record *MeasurementRecord) succeeded() bool {
+	return record.Outcome == "" || record.Outcome == OutcomeSuccess
+}
+
+// MeasurementRecordWriter writes measurement records to some output.
+type MeasurementRecordWriter interface {
+	WriteRecord(record *MeasurementRecord) error
+}
+
+// JSONLRecordWriter writes measurement records as JSON Lines: one JSON object per line.
+type JSONLRecordWriter struct {
+	encoder *json.Encoder
+}
+
+// CSVRecordWriter writes measurement records as CSV, preceded by a header line.
+type CSVRecordWriter struct {
+	writer *csv.Writer
+	header bool
+}
+
+// measurementRecordColumns contains the header of CSV measurement records.
+var measurementRecordColumns = []string{
+	"timestamp", "runId", "kind", "operation", "network", "scheme", "durationNs",
+	"action", "serverUrl", "hostname", "protocolVersion", "sessionToken", "keyshare", "schemeManager", "disclosedAttributes", "serverDurationNs", "outcome",
+	"credentialType", "payloadSize", "events", "encoding", "shape", "errorType", "failed",
+	"sessionId",
+}
+
+// NewMeasurementRecord returns a record of a measurement of the specified kind that was just taken.
+func NewMeasurementRecord(kind MeasurementKind, duration time.Duration) *MeasurementRecord {
+	return &MeasurementRecord{
+		Timestamp:       time.Now(),
+		Kind:            kind.Name(),
+		MeasurementKind: kind,
+		Duration:        duration,
+		Outcome:         OutcomeSuccess,
+	}
+}
+
+// MeasurementOutcomeOf returns the outcome of a session that finished with the specified error,
+// along with the type of the error if it is a SessionError.
+func MeasurementOutcomeOf(err error) (MeasurementOutcome, ErrorType) {
+	if err == nil {
+		return OutcomeSuccess, ""
+	}
+	serr, ok := err.(*SessionError)
+	if !ok {
+		return OutcomeError, ""
+	}
+	switch serr.ErrorType {
+	case ErrorTransport, ErrorServerResponse, ErrorTimeout, ErrorTor:
+		if serr.ErrorType == ErrorTimeout || isTimeout(serr.Err) {
+			return OutcomeTimeout, serr.ErrorType
+		}
+		return OutcomeTransportError, serr.ErrorType
+	case ErrorRejected:
+		return OutcomeRejected, serr.ErrorType
+	case ErrorKeyshare, ErrorKeyshareUnenrolled:
+		return OutcomeKeyshareError, serr.ErrorType
+	default:
+		return OutcomeError, serr.ErrorType
+	}
+}
+
+// isTimeout returns whether the error, or any error it wraps, is a timeout.
+func isTimeout(err error) bool {
+	for err != nil {
+		if err == context.DeadlineExceeded {
+			return true
+		}
+		if timeout, ok := err.(interface{ Timeout() bool }); ok && timeout.Timeout() {
+			return true
+		}
+		switch e := err.(type) {
+		case *errors.Error:
+			err = e.Err
+		case interface{ Unwrap() error }:
+			err = e.Unwrap()
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+func NewJSONLRecordWriter(w io.Writer) *JSONLRecordWriter {
+	return &JSONLRecordWriter{encoder: json.NewEncoder(w)}
+}
+
+func (w *JSONLRecordWriter) WriteRecord(record *MeasurementRecord) error {
+	return w.encoder.Encode(record)
+}
+
+// NewCSVRecordWriter returns a CSVRecordWriter. If header is false, the header line is omitted,
+// e.g. when appending to a file that already contains records.
+func NewCSVRecordWriter(w io.Writer, header bool) *CSVRecordWriter {
+	return &CSVRecordWriter{writer: csv.NewWriter(w), header: header}
+}
+
+func (w *CSVRecordWriter) WriteRecord(record *MeasurementRecord) error {
+	if w.header {
+		if err := w.writer.Write(measurementRecordColumns); err != nil {
+			return err
+		}
+		w.header = false
+	}
+	version := ""
+	if record.ProtocolVersion != nil {
+		version = record.ProtocolVersion.String()
+	}
+	if err := w.writer.Write([]string{
+		record.Timestamp.Format(time.RFC3339Nano),
+		record.RunID,
+		record.Kind,
+		string(record.Operation),
+		string(record.Network),
+		string(record.Scheme),
+		strconv.FormatInt(int64(record.Duration), 10),
+		string(record.Action),
+		record.ServerURL,
+		record.Hostname,
+		version,
+		record.SessionToken,
+		strconv.FormatBool(record.Keyshare),
+		record.SchemeManager,
+		strconv.Itoa(record.DisclosedAttrs),
+		strconv.FormatInt(int64(record.ServerDuration), 10),
+		string(record.Outcome),
+		record.CredentialType,
+		strconv.FormatInt(record.PayloadSize, 10),
+		strconv.Itoa(record.Events),
+		string(record.Encoding),
+		record.Shape,
+		string(record.ErrorType),
+		strconv.FormatBool(record.Failed),
+		record.SessionID,
+	}); err != nil {
+		return err
+	}
+	w.writer.Flush()
+	return w.writer.Error()
+}
@@ -0,0 +1,564 @@
+package irma
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-errors/errors"
+)
+
+// MeasurementReport aggregates the measurement records of one or more runs: it contains the
+// statistics of each operation under each condition, and compares the conditions with each other.
+// All durations are in microseconds.
+type MeasurementReport struct {
+	Runs       []*MeasurementRunSummary `json:"runs"`
+	Operations []*OperationReport       `json:"operations"`
+	Traffic    []*TrafficReport         `json:"traffic,omitempty"`
+
+	records []*MeasurementRecord // of successful sessions, from which the charts are drawn
+}
+
+// MeasurementRunSummary describes one of the runs of which the records are included in a report.
+type MeasurementRunSummary struct {
+	RunID   string    `json:"runId"`
+	Records int       `json:"records"`
+	First   time.Time `json:"first"`
+	Last    time.Time `json:"last"`
+}
+
+// OperationReport contains the statistics of one operation per condition, along with the
+// comparisons between conditions that differ in either network or scheme.
+type OperationReport struct {
+	Operation   MeasurementOperation   `json:"operation"`
+	Label       string                 `json:"label"`
+	Conditions  []*ConditionStatistics `json:"conditions"`
+	Comparisons []*ConditionComparison `json:"comparisons,omitempty"`
+}
+
+// ConditionStatistics contains the statistics of the measurements of an operation under one
+// condition, along with the amount of times the operation was attempted and succeeded. The
+// statistics are nil if the operation never succeeded in a successful session.
+type ConditionStatistics struct {
+	MeasurementCondition
+	Attempts    int                    `json:"attempts"`
+	Successes   int                    `json:"successes"`
+	SuccessRate float64                `json:"successRate"`
+	Statistics  *MeasurementStatistics `json:"statistics"`
+}
+
+// ConditionComparison compares the measurements of an operation under two conditions. The ratio
+// of medians is that of the compared condition to the baseline condition, so that e.g. a ratio of
+// 3 for Tor against direct means that the operation takes three times as long over Tor.
+type ConditionComparison struct {
+	Baseline     MeasurementCondition `json:"baseline"`
+	Compared     MeasurementCondition `json:"compared"`
+	MedianRatio  float64              `json:"medianRatio"`
+	MannWhitneyU *SignificanceTest    `json:"mannWhitneyU,omitempty"`
+	WelchT       *SignificanceTest    `json:"welchT,omitempty"`
+}
+
+// TrafficReport contains the statistics of the amount of bytes sent and received per session under
+// one condition, in the messages of one type or, if MessageType is empty, in total.
+type TrafficReport struct {
+	MessageType MessageType `json:"messageType,omitempty"`
+	MeasurementCondition
+	Sent     *MeasurementStatistics `json:"sent"`
+	Received *MeasurementStatistics `json:"received"`
+}
+
+// Formats in which a MeasurementReport can be written.
+const (
+	ReportFormatText     = "text"
+	ReportFormatMarkdown = "markdown"
+	ReportFormatJSON     = "json"
+	ReportFormatHTML     = "html"
+)
+
+// ReadMeasurementRecords reads measurement records from JSON Lines, as written by JSONLRecordWriter.
+func ReadMeasurementRecords(r io.Reader) ([]*MeasurementRecord, error) {
+	var records []*MeasurementRecord
+	decoder := json.NewDecoder(r)
+	for decoder.More() {
+		record := &MeasurementRecord{}
+		if err := decoder.Decode(record); err != nil {
+			return nil, errors.WrapPrefix(err, "failed to read measurement records", 0)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// NewMeasurementReport computes the report of the specified records. Records of sessions that did
+// not succeed are not included in the statistics, but they are in the success rates: an attempt of
+// an operation failed if its record is marked as failed.
+func NewMeasurementReport(records []*MeasurementRecord) *MeasurementReport {
+	report := &MeasurementReport{}
+	runs := map[string]*MeasurementRunSummary{}
+	samples := map[MeasurementKind][]float64{}
+	attempts, successes := map[MeasurementKind]int{}, map[MeasurementKind]int{}
+	var occurring []MeasurementCondition
+	for _, record := range records {
+		run := runs[record.RunID]
+		if run == nil {
+			run = &MeasurementRunSummary{RunID: record.RunID, First: record.Timestamp, Last: record.Timestamp}
+			runs[record.RunID] = run
+			report.Runs = append(report.Runs, run)
+		}
+		run.Records++
+		if record.Timestamp.Before(run.First) {
+			run.First = record.Timestamp
+		}
+		if record.Timestamp.After(run.Last) {
+			run.Last = record.Timestamp
+		}
+		occurring = append(occurring, record.MeasurementCondition)
+		attempts[record.MeasurementKind]++
+		if !record.Failed {
+			successes[record.MeasurementKind]++
+		}
+		if record.Outcome != "" && record.Outcome != OutcomeSuccess {
+			continue
+		}
+		report.records = append(report.records, record)
+		samples[record.MeasurementKind] = append(samples[record.MeasurementKind], float64(record.Duration.Microseconds()))
+	}
+
+	conditions := reportConditionsOf(occurring)
+	comparisons := comparedConditionsOf(conditions)
+	for _, op := range reportOperations(records) {
+		opReport := &OperationReport{Operation: op, Label: op.Label()}
+		for _, condition := range conditions {
+			kind := NewMeasurementKind(op, condition)
+			if attempts[kind] == 0 {
+				continue
+			}
+			c := &ConditionStatistics{
+				MeasurementCondition: condition,
+				Attempts:             attempts[kind],
+				Successes:            successes[kind],
+				SuccessRate:          float64(successes[kind]) / float64(attempts[kind]),
+			}
+			if s := samples[kind]; len(s) > 0 {
+				c.Statistics = NewMeasurementStatistics(s)
+			}
+			opReport.Conditions = append(opReport.Conditions, c)
+		}
+		for _, pair := range comparisons {
+			baseline, compared := samples[NewMeasurementKind(op, pair[0])], samples[NewMeasurementKind(op, pair[1])]
+			if len(baseline) > 0 && len(compared) > 0 {
+				opReport.Comparisons = append(opReport.Comparisons, compareConditions(pair[0], pair[1], baseline, compared))
+			}
+		}
+		report.Operations = append(report.Operations, opReport)
+	}
+	return report
+}
+
+// ReadSessionTrafficRecords reads the traffic of sessions written by Measurements to a JSON Lines file.
+func ReadSessionTrafficRecords(r io.Reader) ([]*SessionTrafficRecord, error) {
+	var records []*SessionTrafficRecord
+	decoder := json.NewDecoder(r)
+	for decoder.More() {
+		record := &SessionTrafficRecord{}
+		if err := decoder.Decode(record); err != nil {
+			return nil, errors.WrapPrefix(err, "failed to read traffic records", 0)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// ReadSessionRunRecords reads the results of session runs written by Measurements to a JSON Lines file.
+func ReadSessionRunRecords(r io.Reader) ([]*SessionRunRecord, error) {
+	var records []*SessionRunRecord
+	decoder := json.NewDecoder(r)
+	for decoder.More() {
+		record := &SessionRunRecord{}
+		if err := decoder.Decode(record); err != nil {
+			return nil, errors.WrapPrefix(err, "failed to read session run records", 0)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// AddTraffic adds the statistics of the traffic of the specified sessions to the report. Sessions
+// that did not succeed are not included.
+func (report *MeasurementReport) AddTraffic(records []*SessionTrafficRecord) {
+	type key struct {
+		typ       MessageType
+		condition MeasurementCondition
+	}
+	sent, received := map[key][]float64{}, map[key][]float64{}
+	var occurring []MeasurementCondition
+	add := func(k key, traffic *MessageTraffic) {
+		sent[k] = append(sent[k], float64(traffic.Sent))
+		received[k] = append(received[k], float64(traffic.Received))
+	}
+	for _, record := range records {
+		if record.Outcome != "" && record.Outcome != OutcomeSuccess {
+			continue
+		}
+		occurring = append(occurring, record.MeasurementCondition)
+		add(key{"", record.MeasurementCondition}, &record.MessageTraffic)
+		for typ, traffic := range record.Messages {
+			add(key{typ, record.MeasurementCondition}, traffic)
+		}
+	}
+	conditions := reportConditionsOf(occurring)
+	for _, typ := range append([]MessageType{""}, messageTypeOrder...) {
+		for _, condition := range conditions {
+			k := key{typ, condition}
+			if len(sent[k]) == 0 {
+				continue
+			}
+			report.Traffic = append(report.Traffic, &TrafficReport{
+				MessageType:          typ,
+				MeasurementCondition: condition,
+				Sent:                 NewMeasurementStatistics(sent[k]),
+				Received:             NewMeasurementStatistics(received[k]),
+			})
+		}
+	}
+}
+
+// reportConditions contains the conditions in the order in which they are reported: the known
+// conditions of JSON-encoded sessions, followed by those of CBOR-encoded sessions.
+var reportConditions = append(
+	append([]MeasurementCondition{}, measurementConditions...),
+	withEncoding(measurementConditions, EncodingCBOR)...,
+)
+
+// comparedConditions contains the pairs of conditions that are compared in reports: each
+// condition against the one that differs only in network, only in scheme or only in encoding.
+var comparedConditions = comparedConditionPairs()
+
+func comparedConditionPairs() [][2]MeasurementCondition {
+	differentNetworkOrScheme := [][2]MeasurementCondition{
+		{{Network: NetworkDirect, Scheme: SchemeHTTP}, {Network: NetworkTor, Scheme: SchemeHTTP}},
+		{{Network: NetworkDirect, Scheme: SchemeHTTPS}, {Network: NetworkTor, Scheme: SchemeHTTPS}},
+		{{Network: NetworkDirect, Scheme: SchemeHTTP}, {Network: NetworkDirect, Scheme: SchemeHTTPS}},
+		{{Network: NetworkTor, Scheme: SchemeHTTP}, {Network: NetworkTor, Scheme: SchemeHTTPS}},
+	}
+	pairs := append([][2]MeasurementCondition{}, differentNetworkOrScheme...)
+	for _, pair := range differentNetworkOrScheme {
+		cbor := withEncoding(pair[:], EncodingCBOR)
+		pairs = append(pairs, [2]MeasurementCondition{cbor[0], cbor[1]})
+	}
+	cbor := withEncoding(measurementConditions, EncodingCBOR)
+	for i, condition := range measurementConditions {
+		pairs = append(pairs, [2]MeasurementCondition{condition, cbor[i]})
+	}
+	return pairs
+}
+
+// reportConditionsOf returns the conditions of reportConditions, followed by the other specified
+// conditions, such as those with an emulated network shape, in order of occurrence.
+func reportConditionsOf(occurring []MeasurementCondition) []MeasurementCondition {
+	conditions := append([]MeasurementCondition{}, reportConditions...)
+	known := map[MeasurementCondition]bool{}
+	for _, condition := range conditions {
+		known[condition] = true
+	}
+	for _, condition := range occurring {
+		if !known[condition] {
+			known[condition] = true
+			conditions = append(conditions, condition)
+		}
+	}
+	return conditions
+}
+
+// comparedConditionsOf returns the pairs of comparedConditions, along with pairs comparing each of
+// the specified conditions having an emulated network shape against the same condition without
+// it, and, if it uses the direct network, against the same condition over Tor.
+func comparedConditionsOf(conditions []MeasurementCondition) [][2]MeasurementCondition {
+	pairs := append([][2]MeasurementCondition{}, comparedConditions...)
+	for _, condition := range conditions {
+		if condition.Shape == "" {
+			continue
+		}
+		unshaped := condition
+		unshaped.Shape = ""
+		pairs = append(pairs, [2]MeasurementCondition{unshaped, condition})
+		if condition.Network == NetworkDirect {
+			tor := unshaped
+			tor.Network = NetworkTor
+			pairs = append(pairs, [2]MeasurementCondition{condition, tor})
+		}
+	}
+	return pairs
+}
+
+// withEncoding returns copies of the conditions having the specified encoding.
+func withEncoding(conditions []MeasurementCondition, encoding MessageEncoding) []MeasurementCondition {
+	result := make([]MeasurementCondition, len(conditions))
+	for i, condition := range conditions {
+		condition.Encoding = encoding
+		result[i] = condition
+	}
+	return result
+}
+
+// reportOperations returns the operations occurring in the records: the registered ones in the
+// order of measurementOperations, followed by unregistered ones in order of occurrence.
+func reportOperations(records []*MeasurementRecord) []MeasurementOperation {
+	occurring := map[MeasurementOperation]bool{}
+	var unregistered []MeasurementOperation
+	for _, record := range records {
+		op := record.Operation
+		if occurring[op] {
+			continue
+		}
+		occurring[op] = true
+		if op.info().phase == "" {
+			unregistered = append(unregistered, op)
+		}
+	}
+	var ops []MeasurementOperation
+	for _, info := range measurementOperations {
+		if occurring[info.operation] {
+			ops = append(ops, info.operation)
+		}
+	}
+	return append(ops, unregistered...)
+}
+
+func compareConditions(baseline, compared MeasurementCondition, a, b []float64) *ConditionComparison {
+	comparison := &ConditionComparison{
+		Baseline:     baseline,
+		Compared:     compared,
+		MannWhitneyU: MannWhitneyU(a, b),
+		WelchT:       WelchT(a, b),
+	}
+	if median := percentile(sortedCopy(a), 50); median != 0 {
+		comparison.MedianRatio = percentile(sortedCopy(b), 50) / median
+	}
+	return comparison
+}
+
+// Label returns a short human-readable description of the condition, e.g. "Tor, HTTPS", or
+// "Tor, HTTPS, CBOR" if the session messages were CBOR-encoded, or "direct, HTTP, shaped tor" if
+// the network shape "tor" was emulated.
+func (condition MeasurementCondition) Label() string {
+	network := "direct"
+	if condition.Network == NetworkTor {
+		network = "Tor"
+	}
+	label := network + ", " + strings.ToUpper(string(condition.Scheme))
+	if condition.Encoding != "" && condition.Encoding != EncodingJSON {
+		label += ", " + strings.ToUpper(string(condition.Encoding))
+	}
+	if condition.Shape != "" {
+		label += ", shaped " + condition.Shape
+	}
+	return label
+}
+
+// Write writes the report in the specified format: text, markdown, json or html.
+func (report *MeasurementReport) Write(w io.Writer, format string) error {
+	switch format {
+	case ReportFormatText:
+		return report.writeTables(w, textTableWriter{}, nil)
+	case ReportFormatMarkdown:
+		return report.writeTables(w, markdownTableWriter{}, nil)
+	case ReportFormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	case ReportFormatHTML:
+		return report.writeHTML(w)
+	default:
+		return errors.Errorf("unknown report format %s (must be text, markdown, json or html)", format)
+	}
+}
+
+// reportTableWriter writes the headings and tables of a report in some format.
+type reportTableWriter interface {
+	heading(w io.Writer, level int, text string) error
+	table(w io.Writer, header []string, rows [][]string) error
+}
+
+var (
+	statisticsColumns = []string{"condition", "success", "n", "median (µs)", "95% CI of median", "mean", "stddev", "p90", "p95", "p99", "min", "max"}
+	comparisonColumns = []string{"baseline", "compared", "ratio of medians", "Mann-Whitney U", "p", "Welch t", "df", "p"}
+	runColumns        = []string{"run", "records", "first", "last"}
+	trafficColumns    = []string{"message", "condition", "sessions", "median sent (B)", "mean sent", "max sent", "median received (B)", "mean received", "max received"}
+)
+
+// writeTables writes the tables of the report; if charts is not nil, it is called to write the
+// charts of each operation below its heading.
+func (report *MeasurementReport) writeTables(
+	w io.Writer, tw reportTableWriter, charts func(w io.Writer, op *OperationReport) error,
+) error {
+	if err := tw.heading(w, 1, "Measurement report"); err != nil {
+		return err
+	}
+	var rows [][]string
+	for _, run := range report.Runs {
+		rows = append(rows, []string{
+			run.RunID, fmt.Sprint(run.Records), run.First.Format(time.RFC3339), run.Last.Format(time.RFC3339),
+		})
+	}
+	if err := tw.table(w, runColumns, rows); err != nil {
+		return err
+	}
+	if err := report.writeTraffic(w, tw); err != nil {
+		return err
+	}
+
+	for _, op := range report.Operations {
+		if err := tw.heading(w, 2, op.Label); err != nil {
+			return err
+		}
+		if charts != nil {
+			if err := charts(w, op); err != nil {
+				return err
+			}
+		}
+		rows = nil
+		for _, c := range op.Conditions {
+			row := []string{c.Label(), formatSuccessRate(c)}
+			if s := c.Statistics; s != nil {
+				row = append(row,
+					fmt.Sprint(s.N), formatMicros(s.Median),
+					"["+formatMicros(s.MedianCI[0])+", "+formatMicros(s.MedianCI[1])+"]",
+					formatMicros(s.Mean), formatMicros(s.StdDev), formatMicros(s.P90), formatMicros(s.P95),
+					formatMicros(s.P99), formatMicros(s.Min), formatMicros(s.Max),
+				)
+			} else {
+				row = append(row, "0")
+				for len(row) < len(statisticsColumns) {
+					row = append(row, "-")
+				}
+			}
+			rows = append(rows, row)
+		}
+		if err := tw.table(w, statisticsColumns, rows); err != nil {
+			return err
+		}
+		if len(op.Comparisons) == 0 {
+			continue
+		}
+		rows = nil
+		for _, c := range op.Comparisons {
+			row := []string{c.Baseline.Label(), c.Compared.Label(), fmt.Sprintf("%.2f", c.MedianRatio)}
+			row = append(row, formatSignificanceTest(c.MannWhitneyU, false)...)
+			row = append(row, formatSignificanceTest(c.WelchT, true)...)
+			rows = append(rows, row)
+		}
+		if err := tw.table(w, comparisonColumns, rows); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTraffic writes the table of the traffic per session, if the report contains it.
+func (report *MeasurementReport) writeTraffic(w io.Writer, tw reportTableWriter) error {
+	if len(report.Traffic) == 0 {
+		return nil
+	}
+	if err := tw.heading(w, 2, "Bytes per session"); err != nil {
+		return err
+	}
+	var rows [][]string
+	for _, t := range report.Traffic {
+		message := string(t.MessageType)
+		if message == "" {
+			message = "all messages"
+		}
+		rows = append(rows, []string{
+			message, t.Label(), fmt.Sprint(t.Sent.N),
+			formatBytes(t.Sent.Median), formatBytes(t.Sent.Mean), formatBytes(t.Sent.Max),
+			formatBytes(t.Received.Median), formatBytes(t.Received.Mean), formatBytes(t.Received.Max),
+		})
+	}
+	return tw.table(w, trafficColumns, rows)
+}
+
+func formatMicros(f float64) string {
+	return fmt.Sprintf("%.0f", f)
+}
+
+// formatSuccessRate formats the success rate of the operation under the condition, e.g. "95.0% (19/20)".
+func formatSuccessRate(c *ConditionStatistics) string {
+	return fmt.Sprintf("%.1f%% (%d/%d)", 100*c.SuccessRate, c.Successes, c.Attempts)
+}
+
+func formatBytes(f float64) string {
+	return fmt.Sprintf("%.0f", f)
+}
+
+func formatSignificanceTest(test *SignificanceTest, df bool) []string {
+	var cells []string
+	if test == nil {
+		cells = []string{"-", "-"}
+		if df {
+			cells = append(cells, "-")
+		}
+		return cells
+	}
+	cells = []string{fmt.Sprintf("%.2f", test.Statistic)}
+	if df {
+		cells = append(cells, fmt.Sprintf("%.1f", test.DF))
+	}
+	return append(cells, fmt.Sprintf("%.3g", test.P))
+}
+
+// textTableWriter writes tables as aligned plain text.
+type textTableWriter struct{}
+
+func (textTableWriter) heading(w io.Writer, level int, text string) error {
+	underline := "="
+	if level > 1 {
+		underline = "-"
+	}
+	_, err := fmt.Fprintf(w, "%s\n%s\n\n", text, strings.Repeat(underline, len([]rune(text))))
+	return err
+}
+
+func (textTableWriter) table(w io.Writer, header []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, row := range append([][]string{header}, rows...) {
+		if _, err := fmt.Fprintln(tw, strings.Join(row, "\t")); err != nil {
+			return err
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+// markdownTableWriter writes tables as GitHub flavored Markdown.
+type markdownTableWriter struct{}
+
+func (markdownTableWriter) heading(w io.Writer, level int, text string) error {
+	_, err := fmt.Fprintf(w, "%s %s\n\n", strings.Repeat("#", level), text)
+	return err
+}
+
+func (markdownTableWriter) table(w io.Writer, header []string, rows [][]string) error {
+	separator := make([]string, len(header))
+	for i := range separator {
+		separator[i] = "---"
+	}
+	escaper := strings.NewReplacer("|", `\|`)
+	for _, row := range append([][]string{header, separator}, rows...) {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			cells[i] = escaper.Replace(cell)
+		}
+		if _, err := fmt.Fprintf(w, "| %s |\n", strings.Join(cells, " | ")); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
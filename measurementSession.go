@@ -0,0 +1,585 @@
+package irma
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/markuskreukniet/irmago-measurements/internal/common"
+)
+
+// SessionMeasurements collects the measurements taken during a single session, along with the
+// context of that session. Each session has its own SessionMeasurements, so that concurrent
+// sessions do not mix up each other's measurements.
+//
+// The methods of SessionMeasurements may be called on nil, in which case they do nothing;
+// sessions that are not being measured have no SessionMeasurements.
+type SessionMeasurements struct {
+	Action    Action
+	Condition MeasurementCondition
+
+	// SessionID identifies the session in the measurements of both the client and the server,
+	// to which it is sent in the SessionIDHeader of each request
+	SessionID string
+
+	ServerURL       string
+	Hostname        string
+	ProtocolVersion *ProtocolVersion
+	SessionToken    string
+	Keyshare        bool
+	DisclosedAttrs  int
+	Environment     *MeasurementEnvironment
+
+	// Outcome of the session and the type of the error that failed it, if it did not succeed
+	Outcome   MeasurementOutcome
+	ErrorType ErrorType
+
+	records   []*MeasurementRecord
+	requests  []*RequestTiming
+	bootstrap []*TorBootstrapEvent
+	mutex     sync.Mutex
+
+	// The operation in progress, to which a failure of the session is attributed, and its start
+	phase      MeasurementOperation
+	phaseStart time.Time
+}
+
+// RequestTimingRecord is the timing of a request done during a measured session, along with the
+// context of that session.
+type RequestTimingRecord struct {
+	RunID        string `json:"runId"`
+	SessionID    string `json:"sessionId,omitempty"`
+	SessionToken string `json:"sessionToken,omitempty"`
+	Action       Action `json:"action,omitempty"`
+	MeasurementCondition
+	RequestTiming
+}
+
+// MessageTraffic is the amount of requests done and of bytes sent and received in them. Only the
+// bodies of the requests and responses are counted, not their headers.
+type MessageTraffic struct {
+	Requests int   `json:"requests"`
+	Sent     int64 `json:"sent"`
+	Received int64 `json:"received"`
+}
+
+// SessionTraffic is the traffic of a session, in total and per type of message.
+type SessionTraffic struct {
+	MessageTraffic
+	Messages map[MessageType]*MessageTraffic `json:"messages,omitempty"`
+}
+
+// SessionTrafficRecord is the traffic of a measured session, along with the context of that session.
+type SessionTrafficRecord struct {
+	RunID        string             `json:"runId"`
+	SessionID    string             `json:"sessionId,omitempty"`
+	SessionToken string             `json:"sessionToken,omitempty"`
+	Action       Action             `json:"action,omitempty"`
+	Outcome      MeasurementOutcome `json:"outcome,omitempty"`
+	MeasurementCondition
+	SessionTraffic
+}
+
+// TorBootstrapEvent is a bootstrap status event that the embedded Tor client reported over its
+// control port while connecting to the Tor network.
+type TorBootstrapEvent struct {
+	Time     time.Time     `json:"time"`
+	Elapsed  time.Duration `json:"elapsedNs"` // since Tor was started
+	Progress int           `json:"progress"`  // in percent
+	Tag      string        `json:"tag,omitempty"`
+	Summary  string        `json:"summary,omitempty"`
+}
+
+// TorBootstrapRecord is a bootstrap event of the Tor client used for a measured session, along
+// with the context of that session.
+type TorBootstrapRecord struct {
+	RunID        string `json:"runId"`
+	SessionID    string `json:"sessionId,omitempty"`
+	SessionToken string `json:"sessionToken,omitempty"`
+	Action       Action `json:"action,omitempty"`
+	MeasurementCondition
+	TorBootstrapEvent
+}
+
+// SessionRunRecord is the result of a complete session run to take measurements, e.g. by the
+// irma measure command, regardless of whether the session succeeded.
+type SessionRunRecord struct {
+	RunID        string    `json:"runId"`
+	Index        int       `json:"index"`
+	Action       Action    `json:"action"`
+	ServerURL    string    `json:"serverUrl,omitempty"`
+	SessionID    string    `json:"sessionId,omitempty"` // if the session was measured
+	SessionToken string    `json:"sessionToken,omitempty"`
+	Start        time.Time `json:"start"`
+	MeasurementCondition
+	Duration  time.Duration      `json:"durationNs"`
+	Outcome   MeasurementOutcome `json:"outcome,omitempty"`
+	ErrorType ErrorType          `json:"errorType,omitempty"`
+	Error     string             `json:"error,omitempty"`
+	WarmUp    bool               `json:"warmUp,omitempty"` // if the session was not measured
+}
+
+// SessionMeasurementResult contains the measurements of a finished session along with the context
+// and outcome of the session, as passed to the app. It is JSON-serialisable.
+type SessionMeasurementResult struct {
+	RunID        string `json:"runId"`
+	SessionID    string `json:"sessionId"`
+	SessionToken string `json:"sessionToken,omitempty"`
+	Action       Action `json:"action"`
+	MeasurementCondition
+	ServerURL      string               `json:"serverUrl,omitempty"`
+	Keyshare       bool                 `json:"keyshare"`
+	DisclosedAttrs int                  `json:"disclosedAttributes"`
+	Outcome        MeasurementOutcome   `json:"outcome"`
+	ErrorType      ErrorType            `json:"errorType,omitempty"`
+	Records        []*MeasurementRecord `json:"records"`
+	Traffic        *SessionTraffic      `json:"traffic,omitempty"`
+}
+
+// NewSessionMeasurements returns a SessionMeasurements for a session of the specified type,
+// measured under the specified condition.
+func NewSessionMeasurements(action Action, condition MeasurementCondition) *SessionMeasurements {
+	return &SessionMeasurements{SessionID: common.NewSessionToken(), Action: action, Condition: condition}
+}
+
+// Add records a measurement of the specified operation, which ends it if it was begun.
+func (sm *SessionMeasurements) Add(op MeasurementOperation, duration time.Duration) {
+	if sm == nil {
+		return
+	}
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.add(NewMeasurementRecord(NewMeasurementKind(op, sm.Condition), duration))
+}
+
+// Time starts measuring the specified operation, and returns a function that records the
+// measurement when called, e.g. in a defer statement.
+func (sm *SessionMeasurements) Time(op MeasurementOperation) func() {
+	start := time.Now()
+	return func() {
+		sm.Add(op, time.Since(start))
+	}
+}
+
+// AddRoundTrip records a measurement of the specified operation, consisting of the last request
+// done during the session, along with the time that the server reported to have spent on it.
+func (sm *SessionMeasurements) AddRoundTrip(op MeasurementOperation, duration time.Duration) {
+	if sm == nil {
+		return
+	}
+	record := NewMeasurementRecord(NewMeasurementKind(op, sm.Condition), duration)
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	if len(sm.requests) > 0 {
+		record.ServerDuration = sm.requests[len(sm.requests)-1].ServerTime()
+	}
+	sm.add(record)
+}
+
+// AddKeyshare records a measurement of the specified operation at the keyshare server of the
+// specified scheme manager.
+func (sm *SessionMeasurements) AddKeyshare(op MeasurementOperation, manager SchemeManagerIdentifier, duration time.Duration) {
+	if sm == nil {
+		return
+	}
+	record := NewMeasurementRecord(NewMeasurementKind(op, sm.Condition), duration)
+	record.SchemeManager = manager.String()
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.add(record)
+}
+
+// AddRevocation records a measurement of the specified revocation operation for the credential
+// type, along with the amount of bytes received and the amount of revocation events involved.
+func (sm *SessionMeasurements) AddRevocation(
+	op MeasurementOperation, id CredentialTypeIdentifier, duration time.Duration, size int64, events int,
+) {
+	if sm == nil {
+		return
+	}
+	record := NewMeasurementRecord(NewMeasurementKind(op, sm.Condition), duration)
+	record.CredentialType = id.String()
+	record.PayloadSize = size
+	record.Events = events
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.add(record)
+}
+
+// KeyshareDurations returns the measurements of the specified operation per keyshare server.
+func (sm *SessionMeasurements) KeyshareDurations(op MeasurementOperation) map[SchemeManagerIdentifier]time.Duration {
+	durations := map[SchemeManagerIdentifier]time.Duration{}
+	if sm == nil {
+		return durations
+	}
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	for _, record := range sm.records {
+		if record.Operation == op && record.SchemeManager != "" {
+			durations[NewSchemeManagerIdentifier(record.SchemeManager)] = record.Duration
+		}
+	}
+	return durations
+}
+
+// AddRequestTiming records the timing of a request done during the session, so that
+// SessionMeasurements can be used as the TimingSink of the HTTPTransports of the session.
+func (sm *SessionMeasurements) AddRequestTiming(timing *RequestTiming) {
+	if sm == nil {
+		return
+	}
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.requests = append(sm.requests, timing)
+}
+
+// RequestTimings returns the timings of the requests done during the session so far.
+func (sm *SessionMeasurements) RequestTimings() []*RequestTiming {
+	if sm == nil {
+		return nil
+	}
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	return append([]*RequestTiming{}, sm.requests...)
+}
+
+// Traffic returns the traffic of the requests done during the session so far.
+func (sm *SessionMeasurements) Traffic() *SessionTraffic {
+	traffic := &SessionTraffic{Messages: map[MessageType]*MessageTraffic{}}
+	for _, timing := range sm.RequestTimings() {
+		typ := timing.MessageType
+		if typ == "" {
+			typ = MessageOther
+		}
+		if traffic.Messages[typ] == nil {
+			traffic.Messages[typ] = &MessageTraffic{}
+		}
+		traffic.Messages[typ].add(timing)
+		traffic.add(timing)
+	}
+	return traffic
+}
+
+func (traffic *MessageTraffic) add(timing *RequestTiming) {
+	traffic.Requests++
+	traffic.Sent += timing.RequestSize
+	traffic.Received += timing.ResponseSize
+}
+
+// AddTorBootstrapEvent records a bootstrap event of the Tor client used for the session.
+func (sm *SessionMeasurements) AddTorBootstrapEvent(event *TorBootstrapEvent) {
+	if sm == nil {
+		return
+	}
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.bootstrap = append(sm.bootstrap, event)
+}
+
+// TorBootstrapEvents returns the bootstrap events of the Tor client recorded so far.
+func (sm *SessionMeasurements) TorBootstrapEvents() []*TorBootstrapEvent {
+	if sm == nil {
+		return nil
+	}
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	return append([]*TorBootstrapEvent{}, sm.bootstrap...)
+}
+
+// Adopt moves the measurements taken by other, e.g. while setting up the network connection
+// before the type of the session was known, to sm. The measurements of other keep their own
+// condition.
+func (sm *SessionMeasurements) Adopt(other *SessionMeasurements) {
+	if sm == nil || other == nil || sm == other {
+		return
+	}
+	other.mutex.Lock()
+	records, requests, bootstrap := other.records, other.requests, other.bootstrap
+	other.records, other.requests, other.bootstrap = nil, nil, nil
+	other.mutex.Unlock()
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.records = append(records, sm.records...)
+	sm.requests = append(requests, sm.requests...)
+	sm.bootstrap = append(bootstrap, sm.bootstrap...)
+}
+
+// Begin marks the start of the specified operation, such as a request to the server, so that if
+// the session fails before the next operation begins, the failure is attributed to it.
+func (sm *SessionMeasurements) Begin(op MeasurementOperation) {
+	if sm == nil {
+		return
+	}
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.phase, sm.phaseStart = op, time.Now()
+}
+
+// add appends the record and ends its operation, if it was begun. Measurements taken after the
+// session failed, e.g. of a request that was still in progress when it was aborted, are ignored.
+// The caller must hold the mutex.
+func (sm *SessionMeasurements) add(record *MeasurementRecord) {
+	if sm.Outcome != "" {
+		return
+	}
+	sm.records = append(sm.records, record)
+	if sm.phase == record.Operation {
+		sm.phase = ""
+	}
+}
+
+// Fail records the outcome of a session that did not succeed, along with the type of the error
+// that failed it, if any. If an operation was begun, a measurement of it is recorded having the
+// time until the failure as duration. Only the first failure of a session is recorded.
+func (sm *SessionMeasurements) Fail(outcome MeasurementOutcome, errorType ErrorType) {
+	if sm == nil {
+		return
+	}
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	if sm.Outcome != "" {
+		return
+	}
+	sm.Outcome, sm.ErrorType = outcome, errorType
+	if sm.phase == "" {
+		return
+	}
+	record := NewMeasurementRecord(NewMeasurementKind(sm.phase, sm.Condition), time.Since(sm.phaseStart))
+	record.Failed = true
+	sm.records = append(sm.records, record)
+	sm.phase = ""
+}
+
+// Succeeded returns whether the session did not fail.
+func (sm *SessionMeasurements) Succeeded() bool {
+	return sm == nil || sm.Outcome == "" || sm.Outcome == OutcomeSuccess
+}
+
+// Records returns the measurements taken so far, along with the context of the session.
+func (sm *SessionMeasurements) Records() []*MeasurementRecord {
+	if sm == nil {
+		return nil
+	}
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	for _, record := range sm.records {
+		record.Action = sm.Action
+		record.ServerURL = sm.ServerURL
+		record.Hostname = sm.Hostname
+		record.ProtocolVersion = sm.ProtocolVersion
+		record.SessionID = sm.SessionID
+		record.SessionToken = sm.SessionToken
+		record.Keyshare = sm.Keyshare
+		record.DisclosedAttrs = sm.DisclosedAttrs
+		if sm.Outcome != "" {
+			record.Outcome = sm.Outcome
+			record.ErrorType = sm.ErrorType
+		}
+	}
+	return append([]*MeasurementRecord{}, sm.records...)
+}
+
+// SessionOperations returns the operations measuring the first message, and the response to the
+// server after the user gave permission, of sessions of the specified type.
+func SessionOperations(action Action) (newSession, respondPermission MeasurementOperation) {
+	subject := MeasurementSubject(action)
+	for _, info := range measurementOperations {
+		if info.subject != subject {
+			continue
+		}
+		switch info.phase {
+		case phaseNewSession:
+			newSession = info.operation
+		case phaseRespondPermission:
+			respondPermission = info.operation
+		}
+	}
+	return
+}
+
+// Result returns the measurements of the session, which belong to the run with the specified ID,
+// along with its context and outcome.
+func (sm *SessionMeasurements) Result(runID string) *SessionMeasurementResult {
+	if sm == nil {
+		return nil
+	}
+	result := &SessionMeasurementResult{
+		RunID:                runID,
+		SessionID:            sm.SessionID,
+		SessionToken:         sm.SessionToken,
+		Action:               sm.Action,
+		MeasurementCondition: sm.Condition,
+		ServerURL:            sm.ServerURL,
+		Keyshare:             sm.Keyshare,
+		DisclosedAttrs:       sm.DisclosedAttrs,
+		Outcome:              sm.Outcome,
+		ErrorType:            sm.ErrorType,
+		Records:              sm.Records(),
+	}
+	if result.Outcome == "" {
+		result.Outcome = OutcomeSuccess
+	}
+	for _, record := range result.Records {
+		record.RunID = runID
+	}
+	if traffic := sm.Traffic(); traffic.Requests > 0 {
+		result.Traffic = traffic
+	}
+	return result
+}
+
+// AddSession stores the measurements of a finished session, whether it succeeded or not. If the
+// legacy Flutter file is enabled, those of a successful session that the app knows replace its
+// content.
+func (m *Measurements) AddSession(sm *SessionMeasurements) error {
+	if sm == nil {
+		return nil
+	}
+	records := sm.Records()
+	for _, record := range records {
+		if err := m.add(record); err != nil {
+			return err
+		}
+	}
+	if sm.Succeeded() && m.FlutterFile {
+		if err := m.replaceFlutterMeasurements(records); err != nil {
+			return err
+		}
+	}
+	if err := m.addRequestTimings(sm); err != nil {
+		return err
+	}
+	if err := m.addTorBootstrapEvents(sm); err != nil {
+		return err
+	}
+	if err := m.addTraffic(sm); err != nil {
+		return err
+	}
+	return m.updateManifest(func(manifest *MeasurementManifest) error {
+		manifest.addSession(sm)
+		return nil
+	})
+}
+
+// replaceFlutterMeasurements replaces the contents of the file read by the Flutter app with the
+// records of a session that it knows, in the order in which it expects them.
+func (m *Measurements) replaceFlutterMeasurements(records []*MeasurementRecord) error {
+	if err := m.ClearFlutterMeasurements(); err != nil {
+		return err
+	}
+	var flutter []*MeasurementRecord
+	for _, record := range records {
+		if _, ok := record.flutterOrder(); ok {
+			flutter = append(flutter, record)
+		}
+	}
+	sort.SliceStable(flutter, func(i, j int) bool {
+		a, _ := flutter[i].flutterOrder()
+		b, _ := flutter[j].flutterOrder()
+		return a < b
+	})
+	for _, record := range flutter {
+		if err := m.addFlutterMeasurement(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddSessionRun stores the result of a complete session run.
+func (m *Measurements) AddSessionRun(record *SessionRunRecord) error {
+	if record.RunID == "" {
+		runID, err := m.RunID()
+		if err != nil {
+			return err
+		}
+		record.RunID = runID
+	}
+	return appendToFile(m.filePath(fileSessionsJSONL), func(file *os.File) error {
+		return json.NewEncoder(file).Encode(record)
+	})
+}
+
+// addTraffic stores the traffic of the session.
+func (m *Measurements) addTraffic(sm *SessionMeasurements) error {
+	traffic := sm.Traffic()
+	if traffic.Requests == 0 {
+		return nil
+	}
+	runID, err := m.RunID()
+	if err != nil {
+		return err
+	}
+	return appendToFile(m.filePath(fileTrafficJSONL), func(file *os.File) error {
+		return json.NewEncoder(file).Encode(&SessionTrafficRecord{
+			RunID:                runID,
+			SessionID:            sm.SessionID,
+			SessionToken:         sm.SessionToken,
+			Action:               sm.Action,
+			Outcome:              sm.Outcome,
+			MeasurementCondition: sm.Condition,
+			SessionTraffic:       *traffic,
+		})
+	})
+}
+
+// addRequestTimings stores the timings of the requests done during the session.
+func (m *Measurements) addRequestTimings(sm *SessionMeasurements) error {
+	timings := sm.RequestTimings()
+	if len(timings) == 0 {
+		return nil
+	}
+	runID, err := m.RunID()
+	if err != nil {
+		return err
+	}
+	return appendToFile(m.filePath(fileRequestsJSONL), func(file *os.File) error {
+		encoder := json.NewEncoder(file)
+		for _, timing := range timings {
+			err := encoder.Encode(&RequestTimingRecord{
+				RunID:                runID,
+				SessionID:            sm.SessionID,
+				SessionToken:         sm.SessionToken,
+				Action:               sm.Action,
+				MeasurementCondition: sm.Condition,
+				RequestTiming:        *timing,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// addTorBootstrapEvents stores the bootstrap events of the Tor client used for the session.
+func (m *Measurements) addTorBootstrapEvents(sm *SessionMeasurements) error {
+	events := sm.TorBootstrapEvents()
+	if len(events) == 0 {
+		return nil
+	}
+	runID, err := m.RunID()
+	if err != nil {
+		return err
+	}
+	return appendToFile(m.filePath(fileTorJSONL), func(file *os.File) error {
+		encoder := json.NewEncoder(file)
+		for _, event := range events {
+			err := encoder.Encode(&TorBootstrapRecord{
+				RunID:                runID,
+				SessionID:            sm.SessionID,
+				SessionToken:         sm.SessionToken,
+				Action:               sm.Action,
+				MeasurementCondition: sm.Condition,
+				TorBootstrapEvent:    *event,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,254 @@
+package irma
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+const (
+	// bootstrapResamples is the amount of resamples used to compute bootstrap confidence intervals.
+	bootstrapResamples = 2000
+	// bootstrapSeed makes the bootstrap confidence intervals of a series reproducible.
+	bootstrapSeed = 1
+)
+
+// MeasurementStatistics summarizes a series of measurements. MedianCI is a bootstrap 95%
+// confidence interval of the median, which unlike the mean is not dominated by the heavy tail
+// of e.g. Tor circuit latencies.
+type MeasurementStatistics struct {
+	N        int        `json:"n"`
+	Min      float64    `json:"min"`
+	Max      float64    `json:"max"`
+	Mean     float64    `json:"mean"`
+	Median   float64    `json:"median"`
+	P90      float64    `json:"p90"`
+	P95      float64    `json:"p95"`
+	P99      float64    `json:"p99"`
+	StdDev   float64    `json:"stddev"`
+	MedianCI [2]float64 `json:"medianCi"`
+}
+
+// NewMeasurementStatistics computes the statistics of the specified samples.
+func NewMeasurementStatistics(samples []float64) *MeasurementStatistics {
+	stats := &MeasurementStatistics{N: len(samples)}
+	if len(samples) == 0 {
+		return stats
+	}
+
+	sorted := sortedCopy(samples)
+	stats.Min = sorted[0]
+	stats.Max = sorted[len(sorted)-1]
+	stats.Mean = mean(sorted)
+	stats.Median = percentile(sorted, 50)
+	stats.P90 = percentile(sorted, 90)
+	stats.P95 = percentile(sorted, 95)
+	stats.P99 = percentile(sorted, 99)
+	stats.StdDev = stddev(sorted, stats.Mean)
+	stats.MedianCI = bootstrapMedianCI(sorted)
+	return stats
+}
+
+func (stats *MeasurementStatistics) String() string {
+	return fmt.Sprintf(
+		"n=%d, min=%.0f, max=%.0f, mean=%.0f, median=%.0f, p90=%.0f, p95=%.0f, p99=%.0f, stddev=%.0f, 95%% CI of median=[%.0f, %.0f]",
+		stats.N, stats.Min, stats.Max, stats.Mean, stats.Median, stats.P90, stats.P95, stats.P99, stats.StdDev,
+		stats.MedianCI[0], stats.MedianCI[1],
+	)
+}
+
+func sortedCopy(samples []float64) []float64 {
+	sorted := make([]float64, len(samples))
+	copy(sorted, samples)
+	sort.Float64s(sorted)
+	return sorted
+}
+
+func mean(samples []float64) float64 {
+	var sum float64
+	for _, s := range samples {
+		sum += s
+	}
+	return sum / float64(len(samples))
+}
+
+// stddev computes the sample standard deviation.
+func stddev(samples []float64, mean float64) float64 {
+	if len(samples) < 2 {
+		return 0
+	}
+	var sum float64
+	for _, s := range samples {
+		sum += (s - mean) * (s - mean)
+	}
+	return math.Sqrt(sum / float64(len(samples)-1))
+}
+
+// percentile computes the p-th percentile of the sorted samples, interpolating linearly
+// between the closest ranks.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 1 {
+		return sorted[0]
+	}
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return sorted[lower] + (rank-float64(lower))*(sorted[upper]-sorted[lower])
+}
+
+// bootstrapMedianCI computes a 95% confidence interval of the median of the sorted samples using
+// the percentile bootstrap.
+func bootstrapMedianCI(sorted []float64) [2]float64 {
+	r := rand.New(rand.NewSource(bootstrapSeed))
+	medians := make([]float64, bootstrapResamples)
+	resample := make([]float64, len(sorted))
+	for i := range medians {
+		for j := range resample {
+			resample[j] = sorted[r.Intn(len(sorted))]
+		}
+		sort.Float64s(resample)
+		medians[i] = percentile(resample, 50)
+	}
+	sort.Float64s(medians)
+	return [2]float64{percentile(medians, 2.5), percentile(medians, 97.5)}
+}
+
+// SignificanceTest is the result of a two-sided test of the null hypothesis that two series of
+// measurements come from the same distribution (Mann-Whitney U) or have equal means (Welch's t).
+type SignificanceTest struct {
+	Statistic float64 `json:"statistic"`
+	DF        float64 `json:"df,omitempty"` // degrees of freedom, for Welch's t-test
+	P         float64 `json:"p"`
+}
+
+// MannWhitneyU performs the Mann-Whitney U test on the samples, using the normal approximation
+// with a correction for ties. The statistic is the smaller of the U values of both samples. It
+// returns nil if either sample is empty or all samples are equal.
+func MannWhitneyU(a, b []float64) *SignificanceTest {
+	n1, n2 := float64(len(a)), float64(len(b))
+	if n1 == 0 || n2 == 0 {
+		return nil
+	}
+
+	type sample struct {
+		value float64
+		first bool
+	}
+	samples := make([]sample, 0, len(a)+len(b))
+	for _, v := range a {
+		samples = append(samples, sample{v, true})
+	}
+	for _, v := range b {
+		samples = append(samples, sample{v, false})
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].value < samples[j].value })
+
+	// Assign average ranks to ties, summing the ranks of the first sample
+	var rankSum, tieCorrection float64
+	for i := 0; i < len(samples); {
+		j := i
+		for j < len(samples) && samples[j].value == samples[i].value {
+			j++
+		}
+		rank := float64(i+j+1) / 2 // ranks are 1-based
+		for k := i; k < j; k++ {
+			if samples[k].first {
+				rankSum += rank
+			}
+		}
+		t := float64(j - i)
+		tieCorrection += t*t*t - t
+		i = j
+	}
+
+	n := n1 + n2
+	u1 := rankSum - n1*(n1+1)/2
+	mu := n1 * n2 / 2
+	sigma := math.Sqrt(n1 * n2 / 12 * ((n + 1) - tieCorrection/(n*(n-1))))
+	if sigma == 0 || math.IsNaN(sigma) {
+		return nil
+	}
+	z := math.Max(math.Abs(u1-mu)-0.5, 0) / sigma // with continuity correction
+	return &SignificanceTest{
+		Statistic: math.Min(u1, n1*n2-u1),
+		P:         math.Erfc(z / math.Sqrt2),
+	}
+}
+
+// WelchT performs Welch's unequal variances t-test on the samples. It returns nil if either
+// sample has less than two values or both have no variance.
+func WelchT(a, b []float64) *SignificanceTest {
+	if len(a) < 2 || len(b) < 2 {
+		return nil
+	}
+	n1, n2 := float64(len(a)), float64(len(b))
+	m1, m2 := mean(a), mean(b)
+	s1, s2 := stddev(a, m1), stddev(b, m2)
+	v1, v2 := s1*s1/n1, s2*s2/n2
+	if v1+v2 == 0 {
+		return nil
+	}
+
+	t := (m1 - m2) / math.Sqrt(v1+v2)
+	df := (v1 + v2) * (v1 + v2) / (v1*v1/(n1-1) + v2*v2/(n2-1))
+	return &SignificanceTest{
+		Statistic: t,
+		DF:        df,
+		P:         regularizedIncompleteBeta(df/2, 0.5, df/(df+t*t)),
+	}
+}
+
+// regularizedIncompleteBeta computes I_x(a, b), evaluating its continued fraction using the
+// modified Lentz method.
+func regularizedIncompleteBeta(a, b, x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+	// The continued fraction converges quickly only for x < (a+1)/(a+b+2)
+	if x > (a+1)/(a+b+2) {
+		return 1 - regularizedIncompleteBeta(b, a, 1-x)
+	}
+
+	lgab, _ := math.Lgamma(a + b)
+	lga, _ := math.Lgamma(a)
+	lgb, _ := math.Lgamma(b)
+	front := math.Exp(lgab-lga-lgb+a*math.Log(x)+b*math.Log(1-x)) / a
+
+	const (
+		epsilon    = 1e-14
+		tiny       = 1e-300
+		iterations = 300
+	)
+	c, d := 1.0, 1-(a+b)*x/(a+1)
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	f := d
+	for m := 1; m <= iterations; m++ {
+		fm := float64(m)
+		for _, numerator := range []float64{
+			fm * (b - fm) * x / ((a + 2*fm - 1) * (a + 2*fm)),
+			-(a + fm) * (a + b + fm) * x / ((a + 2*fm) * (a + 2*fm + 1)),
+		} {
+			d = 1 + numerator*d
+			if math.Abs(d) < tiny {
+				d = tiny
+			}
+			c = 1 + numerator/c
+			if math.Abs(c) < tiny {
+				c = tiny
+			}
+			d = 1 / d
+			f *= c * d
+		}
+		if math.Abs(c*d-1) < epsilon {
+			break
+		}
+	}
+	return front * f
+}
@@ -0,0 +1,204 @@
+package irma
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/go-errors/errors"
+)
+
+// ServerRequestRecord is a request as logged by an IRMA server, which logs the session token and
+// the session and request IDs sent by the client when it runs with JSON logging at trace level.
+type ServerRequestRecord struct {
+	RequestID    string    `json:"requestId"`
+	SessionID    string    `json:"sessionId,omitempty"`
+	SessionToken string    `json:"sessionToken,omitempty"`
+	Method       string    `json:"method,omitempty"`
+	URL          string    `json:"url,omitempty"`
+	Received     time.Time `json:"received"`
+	Responded    time.Time `json:"responded"`
+	Status       int       `json:"status,omitempty"`
+}
+
+// TimelineRequest is a single request of a session, as seen by both the client and the server.
+// ClientSend and ClientReceive are measured by the clock of the client, ServerReceive and
+// ServerRespond by the clock of the server.
+type TimelineRequest struct {
+	RequestID     string    `json:"requestId"`
+	Method        string    `json:"method"`
+	URL           string    `json:"url"`
+	ClientSend    time.Time `json:"clientSend"`
+	ServerReceive time.Time `json:"serverReceive"`
+	ServerRespond time.Time `json:"serverRespond"`
+	ClientReceive time.Time `json:"clientReceive"`
+
+	RoundTrip  time.Duration `json:"roundTripNs"`
+	ServerTime time.Duration `json:"serverTimeNs,omitempty"`
+	// OneWayDelay is the estimated time taken by the network in each direction, assuming that it
+	// is symmetric. It is independent of the clocks of the client and server being synchronized.
+	OneWayDelay time.Duration `json:"oneWayDelayNs,omitempty"`
+	// ClockOffset is the estimated amount of time the clock of the server is ahead of the client.
+	ClockOffset time.Duration `json:"clockOffsetNs,omitempty"`
+	// Matched is true if the request was found in the server log.
+	Matched bool `json:"matched"`
+}
+
+// SessionTimeline contains the requests of a session in the order they were sent.
+type SessionTimeline struct {
+	SessionID    string             `json:"sessionId,omitempty"`
+	SessionToken string             `json:"sessionToken"`
+	Action       Action             `json:"action,omitempty"`
+	Requests     []*TimelineRequest `json:"requests"`
+}
+
+// serverLogLine contains the fields of the lines logged by server.LogRequest and server.LogResponse.
+type serverLogLine struct {
+	Time      time.Time `json:"time"`
+	Msg       string    `json:"msg"`
+	RequestID string    `json:"requestId"`
+	SessionID string    `json:"sessionId"`
+	Session   string    `json:"session"`
+	Method    string    `json:"method"`
+	URL       string    `json:"url"`
+	Status    int       `json:"status"`
+	Duration  string    `json:"duration"`
+}
+
+const (
+	serverLogRequest  = "=> request"
+	serverLogResponse = "<= response"
+)
+
+// ParseServerLog reads the requests from the JSON log of an IRMA server. Lines that are not JSON,
+// or that are not about a request sent with a request ID, are skipped.
+func ParseServerLog(r io.Reader) ([]*ServerRequestRecord, error) {
+	var records []*ServerRequestRecord
+	byID := map[string]*ServerRequestRecord{}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024) // lines include the messages
+	for scanner.Scan() {
+		var line serverLogLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil || line.RequestID == "" {
+			continue
+		}
+		record := byID[line.RequestID]
+		if record == nil {
+			record = &ServerRequestRecord{RequestID: line.RequestID}
+			byID[line.RequestID] = record
+			records = append(records, record)
+		}
+		if line.Session != "" {
+			record.SessionToken = line.Session
+		}
+		if line.SessionID != "" {
+			record.SessionID = line.SessionID
+		}
+		switch line.Msg {
+		case serverLogRequest:
+			record.Received = line.Time
+			record.Method = line.Method
+			record.URL = line.URL
+		case serverLogResponse:
+			record.Responded = line.Time
+			record.Status = line.Status
+			// The duration is measured by the server itself and is more precise than the
+			// difference between the timestamps of both lines
+			if d, err := time.ParseDuration(line.Duration); err == nil && !record.Received.IsZero() {
+				record.Responded = record.Received.Add(d)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.WrapPrefix(err, "failed to read server log", 0)
+	}
+	return records, nil
+}
+
+// ReadRequestTimingRecords reads the request timings written by Measurements to a JSON Lines file.
+func ReadRequestTimingRecords(r io.Reader) ([]*RequestTimingRecord, error) {
+	var records []*RequestTimingRecord
+	decoder := json.NewDecoder(r)
+	for decoder.More() {
+		record := &RequestTimingRecord{}
+		if err := decoder.Decode(record); err != nil {
+			return nil, errors.WrapPrefix(err, "failed to read request timings", 0)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// MergeTimelines joins the request timings measured by clients with the requests logged by the
+// server, by the ID of each request, into a timeline per session. Requests of the client
+// that do not occur in the server log are included with Matched set to false.
+//
+// Requests are grouped into sessions by their session ID. The session token is used only for
+// requests without one, measured by older clients; as servers may hand out the same token to
+// several sessions, such requests are not necessarily of a single session.
+func MergeTimelines(client []*RequestTimingRecord, server []*ServerRequestRecord) []*SessionTimeline {
+	byID := map[string]*ServerRequestRecord{}
+	for _, record := range server {
+		byID[record.RequestID] = record
+	}
+
+	type sessionKey struct{ id, token string }
+	var timelines []*SessionTimeline
+	bySession := map[sessionKey]*SessionTimeline{}
+	for _, record := range client {
+		serverRecord := byID[record.RequestID]
+		key := sessionKey{id: record.SessionID, token: record.SessionToken}
+		if serverRecord != nil {
+			if key.id == "" {
+				key.id = serverRecord.SessionID
+			}
+			if key.token == "" {
+				key.token = serverRecord.SessionToken
+			}
+		}
+		timeline := bySession[key]
+		if timeline == nil {
+			timeline = &SessionTimeline{SessionID: key.id, SessionToken: key.token, Action: record.Action}
+			bySession[key] = timeline
+			timelines = append(timelines, timeline)
+		}
+		timeline.Requests = append(timeline.Requests, newTimelineRequest(&record.RequestTiming, serverRecord))
+	}
+
+	for _, timeline := range timelines {
+		sort.SliceStable(timeline.Requests, func(i, j int) bool {
+			return timeline.Requests[i].ClientSend.Before(timeline.Requests[j].ClientSend)
+		})
+	}
+	return timelines
+}
+
+func newTimelineRequest(timing *RequestTiming, server *ServerRequestRecord) *TimelineRequest {
+	request := &TimelineRequest{
+		RequestID:     timing.RequestID,
+		Method:        timing.Method,
+		URL:           timing.URL,
+		ClientSend:    timing.Start,
+		ClientReceive: timing.Start.Add(timing.Total),
+		RoundTrip:     timing.Total,
+		ServerTime:    timing.ServerTime(),
+	}
+	if server == nil || server.Received.IsZero() || server.Responded.IsZero() {
+		return request
+	}
+
+	request.Matched = true
+	request.ServerReceive = server.Received
+	request.ServerRespond = server.Responded
+	if request.ServerTime == 0 {
+		request.ServerTime = server.Responded.Sub(server.Received)
+	}
+	// The usual estimates from NTP, with t0..t3 the client send, server receive, server respond
+	// and client receive times
+	request.OneWayDelay = (request.RoundTrip - server.Responded.Sub(server.Received)) / 2
+	request.ClockOffset = (server.Received.Sub(request.ClientSend) + server.Responded.Sub(request.ClientReceive)) / 2
+	return request
+}
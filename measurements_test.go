@@ -0,0 +1,1414 @@
+package irma
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/gabi/revocation"
+
+	"github.com/markuskreukniet/irmago-measurements/internal/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMeasurementKindNames(t *testing.T) {
+	tor := MeasurementCondition{Network: NetworkTor, Scheme: SchemeHTTP}
+	torHttps := MeasurementCondition{Network: NetworkTor, Scheme: SchemeHTTPS}
+	directHttps := MeasurementCondition{Network: NetworkDirect, Scheme: SchemeHTTPS}
+
+	// These names have to match the file names of earlier measurement runs
+	require.Equal(t, "disclosureNewSession", NewMeasurementKind(OperationDisclosureNewSession, measurementConditions[0]).Name())
+	require.Equal(t, "torIssuanceRespondPermission", NewMeasurementKind(OperationIssuanceRespondPermission, tor).Name())
+	require.Equal(t, "torDisclosureHttpsNewSession", NewMeasurementKind(OperationDisclosureNewSession, torHttps).Name())
+	require.Equal(t, "kssHttpsGetProofPs", NewMeasurementKind(OperationKssGetProofPs, directHttps).Name())
+	require.Equal(t, "torSigningHttpsTimestamp", NewMeasurementKind(OperationSigningTimestamp, torHttps).Name())
+	require.Equal(t, "torKssHttpsGetCommitments.txt", NewMeasurementKind(OperationKssGetCommitments, torHttps).FileName())
+	require.Equal(t, "torBootstrap", NewMeasurementKind(OperationTorBootstrap, tor).Name())
+	require.Equal(t, "torHttpsCircuitRenewal", NewMeasurementKind(OperationTorCircuitRenewal, torHttps).Name())
+
+	require.Equal(t, "disclosure new session over Tor and HTTPS", NewMeasurementKind(OperationDisclosureNewSession, torHttps).Label())
+	require.Equal(t, "torDisclosureNewSession: ", NewMeasurementKind(OperationDisclosureNewSession, tor).flutterText())
+	require.Equal(t, "\nkssGetProofPs: ", NewMeasurementKind(OperationKssGetProofPs, measurementConditions[0]).flutterText())
+
+	// Operations of the Tor client only occur under the two conditions using Tor
+	kinds := MeasurementKinds()
+	require.Len(t, kinds, len(measurementOperations)*len(measurementConditions)-2*3)
+	for _, kind := range kinds {
+		parsed, err := ParseMeasurementKind(kind.Name())
+		require.NoError(t, err)
+		require.Equal(t, kind, parsed)
+	}
+	_, err := ParseMeasurementKind("nonexisting")
+	require.Error(t, err)
+}
+
+func TestParseMeasurementType(t *testing.T) {
+	subject, condition, err := ParseMeasurementType("torDisclosureHttpsMeasurement")
+	require.NoError(t, err)
+	require.Equal(t, MeasurementSubject(ActionDisclosing), subject)
+	require.Equal(t, MeasurementCondition{Network: NetworkTor, Scheme: SchemeHTTPS}, condition)
+
+	subject, _, err = ParseMeasurementType("torSigningMeasurement")
+	require.NoError(t, err)
+	require.Equal(t, MeasurementSubject(ActionSigning), subject)
+
+	subject, condition, err = ParseMeasurementType("issuanceMeasurement")
+	require.NoError(t, err)
+	require.Equal(t, MeasurementSubject(ActionIssuing), subject)
+	require.Equal(t, MeasurementCondition{Network: NetworkDirect, Scheme: SchemeHTTP}, condition)
+
+	_, _, err = ParseMeasurementType("")
+	require.Error(t, err)
+	_, _, err = ParseMeasurementType("torMeasurement")
+	require.Error(t, err)
+
+	for _, subject := range []string{MeasurementSubject(ActionDisclosing), MeasurementSubject(ActionIssuing), MeasurementSubject(ActionSigning)} {
+		for _, condition := range measurementConditions {
+			parsedSubject, parsedCondition, err := ParseMeasurementType(MeasurementType(subject, condition))
+			require.NoError(t, err)
+			require.Equal(t, subject, parsedSubject)
+			require.Equal(t, condition, parsedCondition)
+		}
+	}
+	require.Empty(t, MeasurementType("", measurementConditions[0]))
+}
+
+func TestTextFileConditions(t *testing.T) {
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+	m := NewMeasurements(storage)
+	m.FlutterFile = true
+
+	jsonCondition := MeasurementCondition{Network: NetworkDirect, Scheme: SchemeHTTP, Encoding: EncodingJSON}
+	cborCondition := MeasurementCondition{Network: NetworkDirect, Scheme: SchemeHTTP, Encoding: EncodingCBOR}
+	kind := NewMeasurementKind(OperationDisclosureNewSession, jsonCondition)
+	require.NoError(t, m.Add(NewMeasurementRecord(kind, time.Millisecond)))
+	require.NoError(t, m.Add(NewMeasurementRecord(NewMeasurementKind(OperationDisclosureNewSession, cborCondition), 2*time.Millisecond)))
+	shapedCondition := MeasurementCondition{Network: NetworkDirect, Scheme: SchemeHTTP, Shape: "tor"}
+	require.NoError(t, m.Add(NewMeasurementRecord(NewMeasurementKind(OperationDisclosureNewSession, shapedCondition), 3*time.Millisecond)))
+
+	// CBOR measurements and those over shaped connections are only kept as structured records
+	records, err := m.Records()
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	bts, err := ioutil.ReadFile(filepath.Join(storage, kind.FileName()))
+	require.NoError(t, err)
+	require.Equal(t, "measurement: 1000", string(bts))
+	bts, err = ioutil.ReadFile(filepath.Join(storage, fileFlutter))
+	require.NoError(t, err)
+	require.Equal(t, "disclosureNewSession: 1000", string(bts))
+}
+
+func TestLegacyMeasurementFunctions(t *testing.T) {
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+	defer func(m *Measurements, exporter ResultExporter) {
+		DefaultMeasurements, DefaultResultExporter = m, exporter
+	}(DefaultMeasurements, DefaultResultExporter)
+	DefaultMeasurements = &Measurements{Path: filepath.Join(storage, "measurements"), FlutterFile: true}
+
+	require.Len(t, legacyMeasurementKinds, TorKssHttpsGetProofPs+1)
+	for _, name := range legacyMeasurementKinds {
+		_, err := ParseMeasurementKind(name)
+		require.NoError(t, err)
+	}
+
+	ClearFlutterMeasurements()
+	AddMeasurementResult(TorKssHttpsGetProofPs, 1500)
+	AddMeasurementResult(-1, 1500)
+	require.True(t, IncrementMeasurementAndDetermineAgain())
+	bts, err := ioutil.ReadFile(filepath.Join(storage, "measurements", "torKssHttpsGetProofPs.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "measurement: 1500", string(bts))
+	bts, err = ioutil.ReadFile(filepath.Join(storage, "measurements", fileFlutter))
+	require.NoError(t, err)
+	require.Equal(t, "\ntorKssHttpsGetProofPs: 1500", string(bts))
+
+	// Without an exporter the results are kept
+	SendResultsAndResetMeasurements()
+	require.True(t, pathDoesExist(filepath.Join(storage, "measurements", "torKssHttpsGetProofPs.txt")))
+	DefaultResultExporter = &DirectoryExporter{Path: filepath.Join(storage, "archive")}
+	SendResultsAndResetMeasurements()
+	require.False(t, pathDoesExist(filepath.Join(storage, "measurements", "torKssHttpsGetProofPs.txt")))
+}
+
+func TestMeasurementsPath(t *testing.T) {
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+
+	path := filepath.Join(storage, "measurements")
+	m := NewMeasurements(path)
+	m.FlutterFile = true
+	kind := NewMeasurementKind(OperationDisclosureNewSession, measurementConditions[0])
+	require.NoError(t, m.ClearFlutterMeasurements())
+	require.NoError(t, m.Add(NewMeasurementRecord(kind, 1200*time.Microsecond)))
+	require.NoError(t, m.Add(NewMeasurementRecord(kind, 800*time.Microsecond)))
+
+	bts, err := ioutil.ReadFile(filepath.Join(path, kind.FileName()))
+	require.NoError(t, err)
+	require.Equal(t, "measurement: 1200\nmeasurement: 800", string(bts))
+	results, err := determineMeasurementResults(filepath.Join(path, kind.FileName()))
+	require.NoError(t, err)
+	require.Equal(t, []float64{1200, 800}, results)
+
+	bts, err = ioutil.ReadFile(filepath.Join(path, fileFlutter))
+	require.NoError(t, err)
+	require.Equal(t, "disclosureNewSession: 1200disclosureNewSession: 800", string(bts))
+
+	again, err := m.IncrementMeasurementAndDetermineAgain()
+	require.NoError(t, err)
+	require.True(t, again)
+	done, err := determineMeasurementsDone(filepath.Join(path, fileMeasurementsDone))
+	require.NoError(t, err)
+	require.Equal(t, 1, done)
+}
+
+func TestMeasurementRecordWriters(t *testing.T) {
+	kind := NewMeasurementKind(OperationKssGetCommitments, MeasurementCondition{Network: NetworkTor, Scheme: SchemeHTTPS})
+	record := NewMeasurementRecord(kind, 1500*time.Microsecond)
+	record.RunID = "run"
+	record.Action = ActionDisclosing
+	record.ProtocolVersion = NewVersion(2, 6)
+	record.Keyshare = true
+
+	var buf bytes.Buffer
+	require.NoError(t, NewJSONLRecordWriter(&buf).WriteRecord(record))
+	require.NoError(t, NewJSONLRecordWriter(&buf).WriteRecord(record))
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	require.Len(t, lines, 2)
+	var parsed MeasurementRecord
+	require.NoError(t, json.Unmarshal(lines[0], &parsed))
+	require.Equal(t, kind, parsed.MeasurementKind)
+	require.Equal(t, "torKssHttpsGetCommitments", parsed.Kind)
+	require.Equal(t, 1500*time.Microsecond, parsed.Duration)
+	require.Equal(t, "2.6", parsed.ProtocolVersion.String())
+
+	buf.Reset()
+	writer := NewCSVRecordWriter(&buf, true)
+	require.NoError(t, writer.WriteRecord(record))
+	require.NoError(t, writer.WriteRecord(record))
+	rows, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	require.Equal(t, measurementRecordColumns, rows[0])
+	require.Equal(t, "1500000", rows[1][6])
+	require.Equal(t, "2.6", rows[2][10])
+}
+
+func TestMeasurementsAddRecord(t *testing.T) {
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+
+	m := NewMeasurements(storage)
+	kind := NewMeasurementKind(OperationIssuanceNewSession, measurementConditions[0])
+	require.NoError(t, m.Add(NewMeasurementRecord(kind, 2*time.Millisecond)))
+	require.NoError(t, m.Add(NewMeasurementRecord(kind, 4*time.Millisecond)))
+
+	bts, err := ioutil.ReadFile(filepath.Join(storage, kind.FileName()))
+	require.NoError(t, err)
+	require.Equal(t, "measurement: 2000\nmeasurement: 4000", string(bts))
+
+	f, err := ioutil.ReadFile(filepath.Join(storage, fileRecordsCSV))
+	require.NoError(t, err)
+	rows, err := csv.NewReader(bytes.NewReader(f)).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	require.Equal(t, runID(t, m), rows[1][1])
+	require.Equal(t, rows[1][1], rows[2][1])
+}
+
+func TestSessionMeasurements(t *testing.T) {
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+
+	newSession, respondPermission := SessionOperations(ActionIssuing)
+	require.Equal(t, OperationIssuanceNewSession, newSession)
+	require.Equal(t, OperationIssuanceRespondPermission, respondPermission)
+	newSession, respondPermission = SessionOperations(ActionSigning)
+	require.Equal(t, OperationSigningNewSession, newSession)
+	require.Equal(t, OperationSigningRespondPermission, respondPermission)
+	newSession, respondPermission = SessionOperations(ActionIssuing)
+
+	// Measurements of sessions that are not measured are ignored
+	var none *SessionMeasurements
+	none.Add(newSession, time.Millisecond)
+	require.Empty(t, none.Records())
+
+	condition := MeasurementCondition{Network: NetworkTor, Scheme: SchemeHTTP}
+	first := NewSessionMeasurements(ActionIssuing, condition)
+	second := NewSessionMeasurements(ActionIssuing, condition)
+	first.Add(newSession, time.Millisecond)
+	second.Add(newSession, 3*time.Millisecond)
+	first.Add(respondPermission, 2*time.Millisecond)
+	first.SessionToken = "token"
+	first.Keyshare = true
+
+	records := first.Records()
+	require.Len(t, records, 2)
+	require.Equal(t, "torIssuanceRespondPermission", records[1].Kind)
+	require.Equal(t, "token", records[1].SessionToken)
+	require.True(t, records[0].Keyshare)
+	require.Len(t, second.Records(), 1)
+
+	m := NewMeasurements(storage)
+	require.NoError(t, m.AddSession(second))
+	require.False(t, pathDoesExist(filepath.Join(storage, fileFlutter)))
+	m.FlutterFile = true
+	require.NoError(t, m.AddSession(first))
+	bts, err := ioutil.ReadFile(filepath.Join(storage, fileFlutter))
+	require.NoError(t, err)
+	require.Equal(t, "torIssuanceNewSession: 1000\ntorIssuanceRespondPermission: 2000", string(bts))
+
+	// The Flutter file keeps its format: measurements of kinds that the app does not know are left
+	// out, and those of the keyshare server follow the response to the server
+	keyshare := NewSessionMeasurements(ActionIssuing, condition)
+	keyshare.Add(newSession, time.Millisecond)
+	keyshare.Add(OperationKssGetCommitments, 3*time.Millisecond)
+	keyshare.Add(OperationKssGetProofPs, 4*time.Millisecond)
+	keyshare.Add(OperationKssRound, 5*time.Millisecond)
+	keyshare.Add(OperationClientProofBuilders, 6*time.Millisecond)
+	keyshare.Add(respondPermission, 2*time.Millisecond)
+	require.NoError(t, m.AddSession(keyshare))
+	bts, err = ioutil.ReadFile(filepath.Join(storage, fileFlutter))
+	require.NoError(t, err)
+	require.Equal(t, "torIssuanceNewSession: 1000\ntorIssuanceRespondPermission: 2000"+
+		"\ntorKssGetCommitments: 3000\ntorKssGetProofPs: 4000", string(bts))
+
+	result := first.Result(runID(t, m))
+	require.Equal(t, OutcomeSuccess, result.Outcome)
+	require.Equal(t, condition, result.MeasurementCondition)
+	require.Equal(t, "token", result.SessionToken)
+	require.Len(t, result.Records, 2)
+	require.Equal(t, result.RunID, result.Records[1].RunID)
+	require.Nil(t, result.Traffic)
+	require.Nil(t, none.Result(result.RunID))
+}
+
+func TestFailedSessionMeasurements(t *testing.T) {
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+	m := NewMeasurements(storage)
+	m.FlutterFile = true
+
+	condition := MeasurementCondition{Network: NetworkDirect, Scheme: SchemeHTTP}
+	succeeded := NewSessionMeasurements(ActionDisclosing, condition)
+	succeeded.Add(OperationDisclosureNewSession, time.Millisecond)
+	require.True(t, succeeded.Succeeded())
+	require.NoError(t, m.AddSession(succeeded))
+
+	sm := NewSessionMeasurements(ActionDisclosing, condition)
+	sm.Begin(OperationDisclosureNewSession)
+	sm.Add(OperationDisclosureNewSession, time.Millisecond)
+	sm.Begin(OperationDisclosureRespondPermission)
+	time.Sleep(10 * time.Millisecond)
+	sm.Fail(MeasurementOutcomeOf(&SessionError{ErrorType: ErrorRejected}))
+	require.False(t, sm.Succeeded())
+	require.NoError(t, m.AddSession(sm))
+
+	records, err := readMeasurementRecords(m.filePath(fileRecordsJSONL))
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	require.Equal(t, OutcomeSuccess, records[0].Outcome)
+	require.Equal(t, OutcomeRejected, records[1].Outcome)
+	require.Equal(t, ErrorRejected, records[1].ErrorType)
+	require.False(t, records[1].Failed)
+	failure := records[2]
+	require.Equal(t, OperationDisclosureRespondPermission, failure.Operation)
+	require.True(t, failure.Failed)
+	require.True(t, failure.Duration >= 10*time.Millisecond)
+	result := sm.Result(runID(t, m))
+	require.Equal(t, OutcomeRejected, result.Outcome)
+	require.Equal(t, ErrorRejected, result.ErrorType)
+
+	// The results shown in the app are still those of the last successful session
+	bts, err := ioutil.ReadFile(filepath.Join(storage, fileFlutter))
+	require.NoError(t, err)
+	require.Equal(t, "disclosureNewSession: 1000", string(bts))
+
+	// A failure without an operation in progress records only the outcome
+	cancelled := NewSessionMeasurements(ActionDisclosing, condition)
+	cancelled.Fail(OutcomeCancelled, "")
+	require.Empty(t, cancelled.Records())
+	require.Equal(t, OutcomeCancelled, cancelled.Outcome)
+}
+
+func TestMeasurementOutcomes(t *testing.T) {
+	outcome, typ := MeasurementOutcomeOf(nil)
+	require.Equal(t, OutcomeSuccess, outcome)
+	require.Empty(t, typ)
+	outcome, _ = MeasurementOutcomeOf(errors.New("other"))
+	require.Equal(t, OutcomeError, outcome)
+	outcome, typ = MeasurementOutcomeOf(&SessionError{ErrorType: ErrorKeyshare})
+	require.Equal(t, OutcomeKeyshareError, outcome)
+	require.Equal(t, ErrorKeyshare, typ)
+	outcome, _ = MeasurementOutcomeOf(&SessionError{ErrorType: ErrorCrypto})
+	require.Equal(t, OutcomeError, outcome)
+	outcome, _ = MeasurementOutcomeOf(&SessionError{ErrorType: ErrorTimeout})
+	require.Equal(t, OutcomeTimeout, outcome)
+
+	// Transport errors are timeouts if the last attempt of the request timed out
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer server.Close()
+	transport := NewHTTPTransport(server.URL, false, &http.Client{Timeout: 20 * time.Millisecond})
+	err := transport.Get("", &struct{}{})
+	require.Error(t, err)
+	outcome, typ = MeasurementOutcomeOf(err)
+	require.Equal(t, OutcomeTimeout, outcome)
+	require.Equal(t, ErrorTransport, typ)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, listener.Close())
+	transport = NewHTTPTransport("http://"+listener.Addr().String(), false)
+	outcome, _ = MeasurementOutcomeOf(transport.Get("", &struct{}{}))
+	require.Equal(t, OutcomeTransportError, outcome)
+}
+
+func TestKeyshareMeasurements(t *testing.T) {
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+
+	sm := NewSessionMeasurements(ActionIssuing, measurementConditions[0])
+	sm.AddKeyshare(OperationKssGetCommitments, NewSchemeManagerIdentifier("irma-demo"), 2*time.Millisecond)
+	sm.AddKeyshare(OperationKssGetCommitments, NewSchemeManagerIdentifier("test"), 5*time.Millisecond)
+	sm.AddKeyshare(OperationKssGetProofPs, NewSchemeManagerIdentifier("test"), 3*time.Millisecond)
+	sm.Add(OperationKssRound, 11*time.Millisecond)
+
+	require.Equal(t, map[SchemeManagerIdentifier]time.Duration{
+		NewSchemeManagerIdentifier("irma-demo"): 2 * time.Millisecond,
+		NewSchemeManagerIdentifier("test"):      5 * time.Millisecond,
+	}, sm.KeyshareDurations(OperationKssGetCommitments))
+
+	m := NewMeasurements(storage)
+	require.NoError(t, m.AddSession(sm))
+	records, err := readMeasurementRecords(m.filePath(fileRecordsJSONL))
+	require.NoError(t, err)
+	require.Len(t, records, 4)
+	require.Equal(t, "test", records[1].SchemeManager)
+	require.Equal(t, "kssRound", records[3].Kind)
+
+	summary := keyshareSummary(records)
+	require.Contains(t, summary, "KSS GetCommitments statistics at keyshare server irma-demo are: n=1, min=2000")
+	require.Contains(t, summary, "KSS GetCommitments statistics at keyshare server test are: n=1, min=5000")
+	require.Contains(t, summary, "KSS GetProofPs statistics at keyshare server test are")
+	require.NotContains(t, summary, "KSS round")
+}
+
+func TestClientMeasurements(t *testing.T) {
+	sm := NewSessionMeasurements(ActionDisclosing, measurementConditions[0])
+	stop := sm.Time(OperationClientProofs)
+	time.Sleep(time.Millisecond)
+	stop()
+	sm.DisclosedAttrs = 3
+
+	other := NewSessionMeasurements(ActionIssuing, measurementConditions[0])
+	other.Add(OperationClientProofs, 2*time.Millisecond)
+	other.Add(OperationIssuanceRespondPermission, 5*time.Millisecond)
+
+	records := append(sm.Records(), other.Records()...)
+	require.Len(t, records, 3)
+	require.Equal(t, "clientProofs", records[0].Kind)
+	require.True(t, records[0].Duration >= time.Millisecond)
+	require.Equal(t, 3, records[0].DisclosedAttrs)
+
+	summary := clientSummary(records)
+	require.Contains(t, summary, "The client Proofs statistics of disclosing sessions disclosing 3 attributes are: n=1")
+	require.Contains(t, summary, "The client Proofs statistics of issuing sessions disclosing 0 attributes are: n=1, min=2000")
+	require.NotContains(t, summary, "respond permission")
+}
+
+func TestRevocationMeasurements(t *testing.T) {
+	id := NewCredentialTypeIdentifier("irma-demo.MijnOverheid.root")
+	var paths []string
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		bts, err := MarshalBinary(&revocation.Update{})
+		require.NoError(t, err)
+		_, _ = w.Write(bts)
+	}))
+	defer ts.Close()
+
+	// The revocation client uses the specified HTTP client, as the test server's certificate is
+	// not trusted otherwise, and reports the timing of its requests
+	sm := NewSessionMeasurements(ActionDisclosing, MeasurementCondition{Network: NetworkTor, Scheme: SchemeHTTPS})
+	client := RevocationClient{
+		Settings:   RevocationSettings{id: {RevocationServerURL: ts.URL}},
+		HTTPClient: ts.Client(),
+		TimingSink: sm,
+	}
+	update, err := client.FetchUpdateLatest(id, 2, 10)
+	require.NoError(t, err)
+	require.Empty(t, update.Events)
+	require.Equal(t, []string{"/revocation/irma-demo.MijnOverheid.root/update/10/2"}, paths)
+	timings := sm.RequestTimings()
+	require.Len(t, timings, 1)
+	require.NotZero(t, timings[0].ResponseSize)
+
+	sm.AddRevocation(OperationRevocationFetchUpdate, id, 3*time.Millisecond, timings[0].ResponseSize, 0)
+	sm.AddRevocation(OperationRevocationWitnessUpdate, id, time.Millisecond, 0, 4)
+	records := sm.Records()
+	require.Len(t, records, 2)
+	require.Equal(t, "torRevocationHttpsFetchUpdate", records[0].Kind)
+	require.Equal(t, id.String(), records[0].CredentialType)
+	require.Equal(t, timings[0].ResponseSize, records[0].PayloadSize)
+	require.Equal(t, "torRevocationHttpsWitnessUpdate", records[1].Kind)
+	require.Equal(t, 4, records[1].Events)
+
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+	m := NewMeasurements(storage)
+	require.NoError(t, m.AddSession(sm))
+	file, err := os.Open(m.filePath(fileRecordsCSV))
+	require.NoError(t, err)
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	columns := map[string]int{}
+	for i, column := range rows[0] {
+		columns[column] = i
+	}
+	require.Equal(t, id.String(), rows[1][columns["credentialType"]])
+	require.Equal(t, "4", rows[2][columns["events"]])
+}
+
+func TestCheckTorConnection(t *testing.T) {
+	require.NoError(t, CheckTorConnection(false, nil))
+
+	// A session that must use Tor does not pass without a Tor client
+	err := CheckTorConnection(true, nil)
+	require.Error(t, err)
+	serr, ok := err.(*SessionError)
+	require.True(t, ok)
+	require.Equal(t, ErrorTor, serr.ErrorType)
+}
+
+func TestTorMeasurements(t *testing.T) {
+	condition := MeasurementCondition{Network: NetworkTor, Scheme: SchemeHTTPS}
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// Measurements taken before the session type is known are adopted by the session
+	setup := NewSessionMeasurements(ActionUnknown, condition)
+	setup.AddTorBootstrapEvent(&TorBootstrapEvent{Time: start, Progress: 5, Tag: "conn"})
+	setup.AddTorBootstrapEvent(&TorBootstrapEvent{Time: start.Add(time.Second), Elapsed: time.Second, Progress: 100, Tag: "done"})
+	setup.Add(OperationTorBootstrap, time.Second)
+	setup.Add(OperationTorFirstDialer, 2*time.Second)
+
+	sm := NewSessionMeasurements(ActionDisclosing, condition)
+	sm.Add(OperationDisclosureNewSession, time.Millisecond)
+	sm.Adopt(setup)
+	require.Empty(t, setup.Records())
+	require.Empty(t, setup.TorBootstrapEvents())
+	records := sm.Records()
+	require.Len(t, records, 3)
+	require.Equal(t, OperationTorBootstrap, records[0].Operation)
+	require.Equal(t, ActionDisclosing, records[0].Action)
+	require.Equal(t, OperationDisclosureNewSession, records[2].Operation)
+
+	// Adopting nil, as done when the client does not measure, or into nil does nothing
+	sm.Adopt(nil)
+	var unmeasured *SessionMeasurements
+	unmeasured.Adopt(sm)
+	require.Len(t, sm.Records(), 3)
+
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+	m := NewMeasurements(storage)
+	require.NoError(t, m.AddSession(sm))
+	require.FileExists(t, m.filePath("torHttpsBootstrap.txt"))
+
+	file, err := os.Open(m.filePath(fileTorJSONL))
+	require.NoError(t, err)
+	defer file.Close()
+	var events []*TorBootstrapRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record TorBootstrapRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		events = append(events, &record)
+	}
+	require.Len(t, events, 2)
+	require.Equal(t, runID(t, m), events[1].RunID)
+	require.Equal(t, condition, events[1].MeasurementCondition)
+	require.Equal(t, 100, events[1].Progress)
+	require.Equal(t, time.Second, events[1].Elapsed)
+}
+
+func TestMeasurementStatistics(t *testing.T) {
+	stats := NewMeasurementStatistics([]float64{5, 1, 4, 2, 3})
+	require.Equal(t, 5, stats.N)
+	require.Equal(t, 1.0, stats.Min)
+	require.Equal(t, 5.0, stats.Max)
+	require.Equal(t, 3.0, stats.Mean)
+	require.Equal(t, 3.0, stats.Median)
+	require.InDelta(t, 4.6, stats.P90, 1e-9)
+	require.InDelta(t, 4.96, stats.P99, 1e-9)
+	require.InDelta(t, 1.5811, stats.StdDev, 1e-4)
+	require.True(t, stats.MedianCI[0] <= stats.Median && stats.Median <= stats.MedianCI[1])
+	require.True(t, stats.MedianCI[0] >= stats.Min && stats.MedianCI[1] <= stats.Max)
+	require.Equal(t, stats.MedianCI, NewMeasurementStatistics([]float64{1, 2, 3, 4, 5}).MedianCI)
+
+	stats = NewMeasurementStatistics([]float64{7})
+	require.Equal(t, 7.0, stats.P95)
+	require.Equal(t, 0.0, stats.StdDev)
+	require.Equal(t, [2]float64{7, 7}, stats.MedianCI)
+
+	require.Equal(t, 0, NewMeasurementStatistics(nil).N)
+}
+
+func TestSignificanceTests(t *testing.T) {
+	a := []float64{1, 2, 3, 4, 5}
+	b := []float64{6, 7, 8, 9, 10}
+
+	// Reference values computed with scipy.stats
+	welch := WelchT(a, b)
+	require.InDelta(t, -5, welch.Statistic, 1e-9)
+	require.InDelta(t, 8, welch.DF, 1e-9)
+	require.InDelta(t, 0.0010528, welch.P, 1e-6)
+	mwu := MannWhitneyU(a, b)
+	require.Equal(t, 0.0, mwu.Statistic)
+	require.InDelta(t, 0.012186, mwu.P, 1e-5)
+
+	mwu = MannWhitneyU([]float64{1, 2, 2, 3, 5}, []float64{2, 3, 4, 4, 6})
+	require.Equal(t, 6.5, mwu.Statistic)
+	require.InDelta(t, 0.24184, mwu.P, 1e-4)
+
+	require.InDelta(t, 1, WelchT(a, a).P, 1e-9)
+	require.Nil(t, WelchT(a, b[:1]))
+	require.Nil(t, WelchT([]float64{1, 1}, []float64{1, 1}))
+	require.Nil(t, MannWhitneyU(a, nil))
+	require.Nil(t, MannWhitneyU([]float64{1, 1}, []float64{1}))
+	require.InDelta(t, 0.5, regularizedIncompleteBeta(1, 1, 0.5), 1e-12)
+}
+
+func TestMeasurementReport(t *testing.T) {
+	tor := MeasurementCondition{Network: NetworkTor, Scheme: SchemeHTTP}
+	var records []*MeasurementRecord
+	for i := 1; i <= 5; i++ {
+		direct := NewMeasurementRecord(NewMeasurementKind(OperationDisclosureNewSession, measurementConditions[0]), time.Duration(i)*time.Millisecond)
+		direct.RunID = "direct"
+		slow := NewMeasurementRecord(NewMeasurementKind(OperationDisclosureNewSession, tor), time.Duration(3*i)*time.Millisecond)
+		slow.RunID = "tor"
+		records = append(records, direct, slow)
+	}
+	failed := NewMeasurementRecord(NewMeasurementKind(OperationDisclosureNewSession, tor), time.Hour)
+	failed.RunID, failed.Outcome, failed.Failed = "tor", OutcomeTimeout, true
+	records = append(records, failed)
+	records = append(records, NewMeasurementRecord(NewMeasurementKind(OperationClientProofs, tor), time.Millisecond))
+	// An operation that never succeeded is reported with its success rate only
+	rejected := NewMeasurementRecord(NewMeasurementKind(OperationDisclosureRespondPermission, tor), time.Second)
+	rejected.RunID, rejected.Outcome, rejected.Failed = "tor", OutcomeRejected, true
+	records = append(records, rejected)
+
+	report := NewMeasurementReport(records)
+	require.Len(t, report.Runs, 3)
+	require.Equal(t, 5, report.Runs[0].Records)
+	require.Equal(t, 7, report.Runs[1].Records)
+	require.Len(t, report.Operations, 3)
+	op := report.Operations[0]
+	require.Equal(t, OperationDisclosureNewSession, op.Operation)
+	require.Len(t, op.Conditions, 2)
+	require.Equal(t, tor, op.Conditions[1].MeasurementCondition)
+	require.Equal(t, 5, op.Conditions[1].Statistics.N)
+	require.Equal(t, 6, op.Conditions[1].Attempts)
+	require.Equal(t, 5, op.Conditions[1].Successes)
+	require.InDelta(t, 5.0/6, op.Conditions[1].SuccessRate, 1e-9)
+	require.Equal(t, 1.0, op.Conditions[0].SuccessRate)
+	respond := report.Operations[1]
+	require.Equal(t, OperationDisclosureRespondPermission, respond.Operation)
+	require.Len(t, respond.Conditions, 1)
+	require.Zero(t, respond.Conditions[0].SuccessRate)
+	require.Nil(t, respond.Conditions[0].Statistics)
+	require.Len(t, op.Comparisons, 1)
+	require.Equal(t, tor, op.Comparisons[0].Compared)
+	require.InDelta(t, 3, op.Comparisons[0].MedianRatio, 1e-9)
+	require.NotNil(t, op.Comparisons[0].MannWhitneyU)
+	require.NotNil(t, op.Comparisons[0].WelchT)
+	require.Empty(t, report.Operations[2].Comparisons)
+
+	var text, markdown, js bytes.Buffer
+	require.NoError(t, report.Write(&text, ReportFormatText))
+	require.Contains(t, text.String(), "disclosure new session\n----------------------")
+	require.Contains(t, text.String(), "direct, HTTP  Tor, HTTP  3.00")
+	require.NoError(t, report.Write(&markdown, ReportFormatMarkdown))
+	require.Contains(t, markdown.String(), "## client Proofs")
+	require.Contains(t, markdown.String(), "| Tor, HTTP | 83.3% (5/6) | 5 | 9000 |")
+	require.Contains(t, markdown.String(), "| Tor, HTTP | 0.0% (0/1) | 0 | - |")
+	require.NoError(t, report.Write(&js, ReportFormatJSON))
+	var parsed MeasurementReport
+	require.NoError(t, json.Unmarshal(js.Bytes(), &parsed))
+	require.Equal(t, report.Operations[0].Comparisons[0].MedianRatio, parsed.Operations[0].Comparisons[0].MedianRatio)
+	require.Error(t, report.Write(&js, "xml"))
+
+	var page bytes.Buffer
+	require.NoError(t, report.Write(&page, ReportFormatHTML))
+	require.Contains(t, page.String(), "<h2>disclosure new session</h2>")
+	require.Equal(t, 3*len(report.Operations), strings.Count(page.String(), "<svg "))
+	require.Contains(t, page.String(), "tor (Tor, HTTP)")
+	// The page must be viewable offline, so it must not load anything
+	require.NotContains(t, page.String(), "<script")
+	require.NotContains(t, page.String(), "src=")
+	require.NotContains(t, strings.ReplaceAll(page.String(), `xmlns="http://www.w3.org/2000/svg"`, ""), "http")
+}
+
+func TestNiceTicks(t *testing.T) {
+	min, max, ticks := niceTicks(0.3, 97)
+	require.Equal(t, 0.0, min)
+	require.Equal(t, 100.0, max)
+	require.Equal(t, []float64{0, 20, 40, 60, 80, 100}, ticks)
+	require.Equal(t, "40", formatTick(ticks[2], ticks))
+
+	min, max, ticks = niceTicks(0, 1)
+	require.Equal(t, 0.0, min)
+	require.Equal(t, 1.0, max)
+	require.Equal(t, "0.2", formatTick(ticks[1], ticks))
+
+	min, max, _ = niceTicks(5, 5)
+	require.True(t, min <= 5 && max > 5)
+}
+
+// startSMTPServer starts a minimal SMTP server that accepts a single email, which it sends to the
+// returned channel.
+func startSMTPServer(t *testing.T) (int, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	mails := make(chan string, 1)
+
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { _, _ = conn.Write([]byte(s + "\r\n")) }
+		reply("220 localhost")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.Fields(line)[0]); cmd {
+			case "EHLO", "HELO", "MAIL", "RCPT", "RSET", "NOOP":
+				reply("250 OK")
+			case "DATA":
+				reply("354 go ahead")
+				var data strings.Builder
+				for {
+					line, err = r.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				mails <- data.String()
+				reply("250 OK")
+			case "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("502 not implemented")
+			}
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr).Port, mails
+}
+
+type failingExporter struct{}
+
+func (failingExporter) Export(*MeasurementExport) error {
+	return errors.New("export failed")
+}
+
+func addTestMeasurements(t *testing.T, m *Measurements) MeasurementKind {
+	kind := NewMeasurementKind(OperationDisclosureNewSession, measurementConditions[0])
+	require.NoError(t, m.Add(NewMeasurementRecord(kind, time.Millisecond)))
+	again, err := m.IncrementMeasurementAndDetermineAgain()
+	require.NoError(t, err)
+	require.True(t, again)
+	return kind
+}
+
+func runID(t *testing.T, m *Measurements) string {
+	id, err := m.RunID()
+	require.NoError(t, err)
+	return id
+}
+
+func TestSMTPExporter(t *testing.T) {
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+	m := NewMeasurements(storage)
+	addTestMeasurements(t, m)
+
+	port, mails := startSMTPServer(t)
+	exporter := &SMTPExporter{
+		Host: "127.0.0.1",
+		Port: port,
+		From: "measurements@example.com",
+		To:   []string{"researcher@example.com"},
+	}
+	require.NoError(t, m.SendResultsAndResetMeasurements(exporter))
+
+	mail := <-mails
+	require.Contains(t, mail, "To: researcher@example.com")
+	require.Contains(t, mail, "disclosure new session statistics")
+	require.Contains(t, mail, "filename=\"measurements.jsonl\"")
+
+	// The port defaults to that of the TLS mode
+	addr, err := (&SMTPExporter{Host: "example.com"}).address()
+	require.NoError(t, err)
+	require.Equal(t, "example.com:587", addr)
+	addr, err = (&SMTPExporter{Host: "example.com", TLSMode: SMTPTLS}).address()
+	require.NoError(t, err)
+	require.Equal(t, "example.com:465", addr)
+	_, err = (&SMTPExporter{Host: "example.com", Port: 70000}).address()
+	require.Error(t, err)
+}
+
+func TestWebhookExporter(t *testing.T) {
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+	m := NewMeasurements(storage)
+	kind := addTestMeasurements(t, m)
+	id := runID(t, m)
+
+	var msg webhookMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		require.Equal(t, "secret", r.Header.Get("Authorization"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&msg))
+	}))
+	defer server.Close()
+
+	exporter := &WebhookExporter{URL: server.URL + "/", Headers: map[string]string{"Authorization": "secret"}}
+	require.NoError(t, m.SendResultsAndResetMeasurements(exporter))
+	require.Equal(t, id, msg.RunID)
+	require.Equal(t, "measurement: 1000", msg.Files[kind.FileName()])
+	require.Contains(t, msg.Files, fileRecordsCSV)
+
+	exporter.URL = server.URL + "/nonexisting"
+	addTestMeasurements(t, m)
+	require.Error(t, m.SendResultsAndResetMeasurements(exporter))
+}
+
+func TestDirectoryExporter(t *testing.T) {
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+	m := NewMeasurements(filepath.Join(storage, "measurements"))
+	kind := addTestMeasurements(t, m)
+	id := runID(t, m)
+
+	exporter := &DirectoryExporter{Path: filepath.Join(storage, "archive")}
+	require.NoError(t, m.SendResultsAndResetMeasurements(exporter))
+	bts, err := ioutil.ReadFile(filepath.Join(storage, "archive", id, kind.FileName()))
+	require.NoError(t, err)
+	require.Equal(t, "measurement: 1000", string(bts))
+	bts, err = ioutil.ReadFile(filepath.Join(storage, "archive", id, summaryFileName))
+	require.NoError(t, err)
+	require.Contains(t, string(bts), "n=1")
+
+	// the measurements were reset
+	require.False(t, pathDoesExist(filepath.Join(storage, "measurements", kind.FileName())))
+	require.NotEqual(t, id, runID(t, m))
+}
+
+func TestMeasurementManifest(t *testing.T) {
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+	m := NewMeasurements(filepath.Join(storage, "measurements"))
+	id := runID(t, m)
+
+	conf, err := NewConfiguration(filepath.Join("testdata", "irma_configuration"), ConfigurationOptions{})
+	require.NoError(t, err)
+	require.NoError(t, conf.ParseFolder())
+	transport := NewHTTPTransport("https://example.com/irma/", false)
+
+	sm := NewSessionMeasurements(ActionDisclosing, measurementConditions[0])
+	sm.ServerURL = "https://example.com/irma/session/token"
+	sm.Environment = &MeasurementEnvironment{TorVersion: "0.4.2.5", Schemes: conf.SchemeVersions(), Transport: transport.Settings()}
+	sm.Add(OperationDisclosureNewSession, time.Millisecond)
+	require.NoError(t, m.AddSession(sm))
+	sm.ServerURL = "https://example.com/irma/session/other/"
+	require.NoError(t, m.AddSession(sm))
+
+	exporter := &DirectoryExporter{Path: filepath.Join(storage, "archive")}
+	require.NoError(t, m.SendResultsAndResetMeasurements(exporter))
+	require.False(t, pathDoesExist(filepath.Join(storage, "measurements", fileManifest)))
+
+	bts, err := ioutil.ReadFile(filepath.Join(storage, "archive", id, fileManifest))
+	require.NoError(t, err)
+	var manifest MeasurementManifest
+	require.NoError(t, json.Unmarshal(bts, &manifest))
+	require.Equal(t, id, manifest.RunID)
+	require.Equal(t, Version, manifest.IrmaVersion)
+	require.Equal(t, []string{"0.4.2.5"}, manifest.TorVersions)
+	require.Equal(t, []string{"https://example.com/irma/"}, manifest.ServerURLs)
+	require.Equal(t, []MeasurementCondition{measurementConditions[0]}, manifest.Conditions)
+	require.Equal(t, []*TransportSettings{transport.Settings()}, manifest.Transports)
+	require.Len(t, manifest.Schemes, len(conf.SchemeManagers))
+	require.Equal(t, "irma-demo", manifest.Schemes[0].ID)
+
+	// the hashes of the files match the exported files
+	require.NotEmpty(t, manifest.Files)
+	for _, file := range manifest.Files {
+		exported, err := hashFile(filepath.Join(storage, "archive", id, file.Name))
+		require.NoError(t, err)
+		require.Equal(t, file, exported)
+	}
+	require.Len(t, manifest.DataHash, 64)
+
+	bts, err = ioutil.ReadFile(filepath.Join(storage, "archive", id, summaryFileName))
+	require.NoError(t, err)
+	require.Contains(t, string(bts), "using Tor 0.4.2.5")
+	require.Contains(t, string(bts), manifest.DataHash)
+}
+
+func TestFailedExportKeepsMeasurements(t *testing.T) {
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+	m := NewMeasurements(storage)
+	kind := addTestMeasurements(t, m)
+	id := runID(t, m)
+
+	require.Error(t, m.SendResultsAndResetMeasurements(failingExporter{}))
+	require.True(t, pathDoesExist(filepath.Join(storage, kind.FileName())))
+	require.Equal(t, id, runID(t, m))
+	done, err := determineMeasurementsDone(filepath.Join(storage, fileMeasurementsDone))
+	require.NoError(t, err)
+	require.Equal(t, 1, done)
+}
+
+func TestTransportRequestTiming(t *testing.T) {
+	var requestIDs []string
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestIDs = append(requestIDs, r.Header.Get(RequestIDHeader))
+		_, _ = ioutil.ReadAll(r.Body)
+		time.Sleep(10 * time.Millisecond)
+		_, _ = w.Write([]byte("VALID"))
+	}))
+	defer ts.Close()
+
+	sm := NewSessionMeasurements(ActionDisclosing, MeasurementCondition{Network: NetworkDirect, Scheme: SchemeHTTPS})
+	transport := NewHTTPTransport(ts.URL, false, ts.Client())
+	transport.TimingSink = sm
+
+	var response string
+	require.NoError(t, transport.Post("proofs", &response, "message"))
+	require.NoError(t, transport.Post("proofs", &response, "message"))
+	require.Equal(t, "VALID", response)
+
+	timings := sm.RequestTimings()
+	require.Len(t, timings, 2)
+	first := timings[0]
+	require.Equal(t, http.MethodPost, first.Method)
+	require.Equal(t, ts.URL+"/proofs", first.URL)
+	require.Equal(t, http.StatusOK, first.StatusCode)
+	require.Equal(t, int64(len("message")), first.RequestSize)
+	require.Equal(t, int64(len("VALID")), first.ResponseSize)
+	require.Equal(t, MessageProofs, first.MessageType)
+	require.Equal(t, EncodingJSON, first.Encoding)
+	require.Equal(t, 0, first.Retries)
+	require.False(t, first.ConnectionReused)
+	require.NotZero(t, first.Dial)
+	require.NotZero(t, first.TLSHandshake)
+	require.True(t, first.TimeToFirstByte >= 10*time.Millisecond)
+	require.True(t, first.Total >= first.TimeToFirstByte)
+	if timings[1].ConnectionReused {
+		require.Zero(t, timings[1].TLSHandshake)
+	}
+	require.Equal(t, requestIDs, []string{first.RequestID, timings[1].RequestID})
+	require.NotEqual(t, first.RequestID, timings[1].RequestID)
+
+	// Failed requests are recorded as well
+	ts.Close()
+	require.Error(t, transport.Get("", &response))
+	timings = sm.RequestTimings()
+	require.Len(t, timings, 3)
+	require.NotEmpty(t, timings[2].Error)
+	require.Equal(t, 2, timings[2].Retries)
+
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+	m := NewMeasurements(storage)
+	require.NoError(t, m.AddSession(sm))
+	bts, err := ioutil.ReadFile(m.filePath(fileRequestsJSONL))
+	require.NoError(t, err)
+	lines := bytes.Split(bytes.TrimSpace(bts), []byte("\n"))
+	require.Len(t, lines, 3)
+	var record RequestTimingRecord
+	require.NoError(t, json.Unmarshal(lines[0], &record))
+	require.Equal(t, runID(t, m), record.RunID)
+	require.Equal(t, SchemeHTTPS, record.Scheme)
+	require.Equal(t, first.TLSHandshake, record.TLSHandshake)
+}
+
+func TestSessionTraffic(t *testing.T) {
+	require.Equal(t, MessageSessionRequest, messageTypeOf(http.MethodGet, ""))
+	require.Equal(t, MessageCancel, messageTypeOf(http.MethodDelete, ""))
+	require.Equal(t, MessageKeyshareCommitments, messageTypeOf(http.MethodPost, "prove/getCommitments"))
+	require.Equal(t, MessageRevocationUpdate, messageTypeOf(http.MethodGet, "/revocation/irma-demo.MijnOverheid.root/update/10/2"))
+	require.Equal(t, MessageRevocation, messageTypeOf(http.MethodGet, "/revocation/irma-demo.MijnOverheid.root/updateevents"))
+	require.Equal(t, MessageOther, messageTypeOf(http.MethodGet, "unknown"))
+
+	condition := MeasurementCondition{Network: NetworkDirect, Scheme: SchemeHTTPS, Encoding: EncodingCBOR}
+	require.Equal(t, "direct, HTTPS, CBOR", condition.Label())
+	sm := NewSessionMeasurements(ActionIssuing, condition)
+	sm.AddRequestTiming(&RequestTiming{MessageType: MessageSessionRequest, ResponseSize: 500})
+	sm.AddRequestTiming(&RequestTiming{MessageType: MessageCommitments, RequestSize: 3000, ResponseSize: 2000})
+	sm.AddRequestTiming(&RequestTiming{MessageType: MessageKeyshareCommitments, RequestSize: 100, ResponseSize: 400})
+	sm.AddRequestTiming(&RequestTiming{MessageType: MessageKeyshareCommitments, RequestSize: 100, ResponseSize: 300})
+
+	traffic := sm.Traffic()
+	require.Equal(t, MessageTraffic{Requests: 4, Sent: 3200, Received: 3200}, traffic.MessageTraffic)
+	require.Len(t, traffic.Messages, 3)
+	require.Equal(t, &MessageTraffic{Requests: 2, Sent: 200, Received: 700}, traffic.Messages[MessageKeyshareCommitments])
+
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+	m := NewMeasurements(storage)
+	require.NoError(t, m.AddSession(sm))
+	require.NoError(t, m.AddSession(NewSessionMeasurements(ActionIssuing, condition))) // no traffic
+	records, err := m.TrafficRecords()
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	require.Equal(t, runID(t, m), records[0].RunID)
+	require.Equal(t, condition, records[0].MeasurementCondition)
+	require.Equal(t, traffic.Messages, records[0].Messages)
+
+	report := NewMeasurementReport(nil)
+	report.AddTraffic(records)
+	require.Len(t, report.Traffic, 4)
+	require.Empty(t, report.Traffic[0].MessageType)
+	require.Equal(t, 3200.0, report.Traffic[0].Sent.Median)
+	require.Equal(t, MessageSessionRequest, report.Traffic[1].MessageType)
+	require.Equal(t, MessageKeyshareCommitments, report.Traffic[3].MessageType)
+	var text bytes.Buffer
+	require.NoError(t, report.Write(&text, ReportFormatText))
+	require.Contains(t, text.String(), "Bytes per session")
+	require.Contains(t, text.String(), "keyshareCommitments")
+
+	// Measurements of sessions with CBOR messages are compared to those with JSON messages
+	jsonCondition := condition
+	jsonCondition.Encoding = ""
+	op := NewMeasurementReport([]*MeasurementRecord{
+		NewMeasurementRecord(NewMeasurementKind(OperationIssuanceRespondPermission, condition), time.Millisecond),
+		NewMeasurementRecord(NewMeasurementKind(OperationIssuanceRespondPermission, jsonCondition), 2*time.Millisecond),
+	}).Operations[0]
+	require.Len(t, op.Conditions, 2)
+	require.Equal(t, jsonCondition, op.Conditions[0].MeasurementCondition)
+	require.Len(t, op.Comparisons, 1)
+	require.Equal(t, condition, op.Comparisons[0].Compared)
+	require.InDelta(t, 0.5, op.Comparisons[0].MedianRatio, 1e-9)
+}
+
+func TestNetworkShape(t *testing.T) {
+	shape, err := ParseNetworkShape("tor")
+	require.NoError(t, err)
+	require.Equal(t, "tor", shape.String())
+	require.Equal(t, 250*time.Millisecond, shape.Latency)
+
+	shape, err = ParseNetworkShape("latency=100ms, jitter=10ms,bandwidth=500k,stall=0.01,stall-duration=1s,seed=1")
+	require.NoError(t, err)
+	require.Equal(t, int64(500000), shape.Bandwidth)
+	require.Equal(t, 0.01, shape.StallProbability)
+	require.Equal(t, "latency=100ms,jitter=10ms,bandwidth=500000,stall=0.01,stall-duration=1s,seed=1", shape.String())
+	parsed, err := ParseNetworkShape(shape.String())
+	require.NoError(t, err)
+	require.Equal(t, shape, parsed)
+
+	for _, spec := range []string{"", "latency", "latency=fast", "unknown=1", "stall=2", "jitter=-1s"} {
+		_, err = ParseNetworkShape(spec)
+		require.Error(t, err, spec)
+	}
+
+	// Data is delivered in order after its latency, limited to the bandwidth
+	shape = &NetworkShape{Latency: 20 * time.Millisecond, Jitter: 5 * time.Millisecond, Bandwidth: 1000000, Seed: 1}
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	listener = shape.Listener(listener)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(conn, conn)
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	start := time.Now()
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	go func() { _, _ = conn.Write(data) }()
+	echo := make([]byte, len(data))
+	_, err = io.ReadFull(conn, echo)
+	require.NoError(t, err)
+	require.Equal(t, data, echo)
+	// 30ms for the latency in both directions, and 100ms to receive the data at 1MB/s
+	require.True(t, time.Since(start) >= 130*time.Millisecond, "echo took only %s", time.Since(start))
+
+	// Shaped connections keep their own read deadline
+	client := shape.Conn(conn)
+	defer client.Close()
+	require.NoError(t, client.SetReadDeadline(time.Now().Add(-time.Second)))
+	_, err = client.Read(echo)
+	netErr, ok := err.(net.Error)
+	require.True(t, ok)
+	require.True(t, netErr.Timeout())
+
+	// Closing does not hang when the peer does not read the data written to it
+	defer func(timeout time.Duration) { shapedConnCloseTimeout = timeout }(shapedConnCloseTimeout)
+	shapedConnCloseTimeout = 50 * time.Millisecond
+	local, remote := net.Pipe()
+	defer remote.Close()
+	unread := shape.Conn(local)
+	_, err = unread.Write(data)
+	require.NoError(t, err)
+	closed := make(chan error)
+	go func() { closed <- unread.Close() }()
+	select {
+	case err = <-closed:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("closing shaped connection hangs")
+	}
+
+	// Writes to a peer that stalls time out, and do not keep the connection from being closed
+	local, remote = net.Pipe()
+	defer remote.Close()
+	stalled := shape.Conn(local)
+	require.NoError(t, stalled.SetWriteDeadline(time.Now().Add(100*time.Millisecond)))
+	for err == nil {
+		_, err = stalled.Write([]byte("stalled"))
+	}
+	netErr, ok = err.(net.Error)
+	require.True(t, ok, "%v", err)
+	require.True(t, netErr.Timeout())
+	require.NoError(t, stalled.SetWriteDeadline(time.Time{}))
+	written := make(chan error)
+	go func() {
+		_, err := stalled.Write([]byte("stalled"))
+		written <- err
+	}()
+	go func() { closed <- stalled.Close() }()
+	for _, c := range []chan error{written, closed} {
+		select {
+		case err = <-c:
+		case <-time.After(5 * time.Second):
+			t.Fatal("closing shaped connection hangs on blocked write")
+		}
+	}
+	require.NoError(t, err)
+
+	// Requests of HTTP clients are delayed in both directions
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("shaped"))
+	}))
+	defer server.Close()
+	start = time.Now()
+	res, err := shape.HTTPClient().Get(server.URL)
+	require.NoError(t, err)
+	bts, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	require.Equal(t, "shaped", string(bts))
+	require.True(t, time.Since(start) >= 30*time.Millisecond)
+
+	// Shaped conditions are compared against the unshaped and Tor conditions
+	direct := MeasurementCondition{Network: NetworkDirect, Scheme: SchemeHTTP}
+	shaped := MeasurementCondition{Network: NetworkDirect, Scheme: SchemeHTTP, Shape: "tor"}
+	tor := MeasurementCondition{Network: NetworkTor, Scheme: SchemeHTTP}
+	require.Equal(t, "direct, HTTP, shaped tor", shaped.Label())
+	var records []*MeasurementRecord
+	for i, condition := range []MeasurementCondition{direct, shaped, tor} {
+		for j := 1; j <= 3; j++ {
+			duration := time.Duration((i+1)*j) * time.Millisecond
+			records = append(records, NewMeasurementRecord(NewMeasurementKind(OperationDisclosureNewSession, condition), duration))
+		}
+	}
+	op := NewMeasurementReport(records).Operations[0]
+	require.Len(t, op.Conditions, 3)
+	require.Equal(t, shaped, op.Conditions[2].MeasurementCondition)
+	var compared [][2]MeasurementCondition
+	for _, comparison := range op.Comparisons {
+		compared = append(compared, [2]MeasurementCondition{comparison.Baseline, comparison.Compared})
+	}
+	require.Equal(t, [][2]MeasurementCondition{{direct, tor}, {direct, shaped}, {shaped, tor}}, compared)
+}
+
+func TestMeasurementCampaign(t *testing.T) {
+	direct := MeasurementCondition{Network: NetworkDirect}
+	tor := MeasurementCondition{Network: NetworkTor}
+	shaped := MeasurementCondition{Network: NetworkDirect, Shape: "tor"}
+	cbor := MeasurementCondition{Network: NetworkDirect, Encoding: EncodingCBOR}
+
+	campaign := &MeasurementCampaign{}
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"conditions": [{"network": "direct"}, {"network": "tor"}, {"network": "direct", "shape": "tor"}],
+		"samples": 4, "warmUp": 1, "pause": "1ms", "order": "latin-square", "seed": 1
+	}`), campaign))
+	require.NoError(t, campaign.Validate())
+	require.Equal(t, []MeasurementCondition{direct, tor, shaped}, campaign.Conditions)
+	require.Equal(t, time.Millisecond, campaign.Pause)
+	bts, err := json.Marshal(campaign)
+	require.NoError(t, err)
+	require.Contains(t, string(bts), `"pause":"1ms"`)
+
+	// Warm-up sessions come first, followed by rounds containing each condition once
+	schedule := campaign.Schedule(campaign.Seed)
+	require.Len(t, schedule, 3+4*3)
+	for i, sample := range schedule {
+		require.Equal(t, i, sample.Index)
+		require.Equal(t, i < 3, sample.WarmUp)
+	}
+	for round := 0; round < 4; round++ {
+		seen := map[MeasurementCondition]bool{}
+		for _, sample := range schedule[3+3*round : 3+3*round+3] {
+			seen[sample.MeasurementCondition] = true
+		}
+		require.Len(t, seen, 3)
+	}
+	require.Equal(t, schedule, campaign.Schedule(campaign.Seed))
+
+	// In balanced Latin squares, each symbol immediately precedes each other symbol equally often
+	for n := 2; n <= 5; n++ {
+		square := balancedLatinSquare(n)
+		precedes := map[[2]int]int{}
+		for _, row := range square {
+			for j := 1; j < n; j++ {
+				precedes[[2]int{row[j-1], row[j]}]++
+			}
+		}
+		require.Len(t, precedes, n*(n-1))
+		for _, count := range precedes {
+			require.Equal(t, len(square)/n, count)
+		}
+	}
+
+	campaign.Order = CampaignOrderBlocked
+	schedule = campaign.Schedule(1)
+	require.Equal(t, direct, schedule[3].MeasurementCondition)
+	require.Equal(t, direct, schedule[6].MeasurementCondition)
+	require.Equal(t, shaped, schedule[14].MeasurementCondition)
+
+	for _, invalid := range []*MeasurementCampaign{
+		{Samples: 1},
+		{Conditions: []MeasurementCondition{direct}},
+		{Conditions: []MeasurementCondition{direct, direct}, Samples: 1},
+		{Conditions: []MeasurementCondition{{Network: "carrier pigeon"}}, Samples: 1},
+		{Conditions: []MeasurementCondition{direct}, Samples: 1, Order: "alphabetical"},
+	} {
+		require.Error(t, invalid.Validate())
+	}
+
+	// A campaign that is interrupted resumes where it stopped
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+	m := NewMeasurements(storage)
+	campaign = &MeasurementCampaign{Conditions: []MeasurementCondition{direct, cbor}, Samples: 3, WarmUp: 1}
+	var performed []*CampaignSample
+	perform := func(sample *CampaignSample) (*SessionRunRecord, error) {
+		if len(performed) == 5 {
+			return nil, errors.New("interrupted")
+		}
+		performed = append(performed, sample)
+		return &SessionRunRecord{Action: ActionDisclosing, MeasurementCondition: sample.MeasurementCondition}, nil
+	}
+	require.Error(t, m.RunCampaign(campaign, perform))
+	done, total, err := m.CampaignProgress()
+	require.NoError(t, err)
+	require.Equal(t, 5, done)
+	require.Equal(t, 8, total)
+	// The checkpoint is not removed by sending the results halfway
+	require.Error(t, m.SendResultsAndResetMeasurements(&DirectoryExporter{Path: filepath.Join(storage, "archive")}))
+	require.True(t, pathDoesExist(m.filePath(fileCampaign)))
+	require.Error(t, m.RunCampaign(&MeasurementCampaign{Conditions: []MeasurementCondition{tor}, Samples: 1}, perform))
+
+	first := performed
+	performed = nil
+	require.NoError(t, m.RunCampaign(campaign, func(sample *CampaignSample) (*SessionRunRecord, error) {
+		performed = append(performed, sample)
+		return &SessionRunRecord{Action: ActionDisclosing, MeasurementCondition: sample.MeasurementCondition}, nil
+	}))
+	require.Len(t, performed, 3)
+	require.Equal(t, 5, performed[0].Index)
+	checkpoint, err := m.readCampaignCheckpoint()
+	require.NoError(t, err)
+	require.Equal(t, checkpoint.Schedule, append(first, performed...))
+
+	bts, err = ioutil.ReadFile(m.filePath(fileSessionsJSONL))
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(bts)), "\n")
+	require.Len(t, lines, 8)
+	require.Contains(t, lines[0], `"warmUp":true`)
+	require.Contains(t, lines[7], `"index":7`)
+
+	manifest, err := m.Manifest()
+	require.NoError(t, err)
+	require.Equal(t, campaign, manifest.Campaign)
+	require.Equal(t, checkpoint.Seed, manifest.CampaignSeed)
+
+	// A session of which the result was stored just before the interruption is not performed again
+	m = NewMeasurements(filepath.Join(storage, "stored"))
+	performed = nil
+	require.Error(t, m.RunCampaign(campaign, perform))
+	checkpoint, err = m.readCampaignCheckpoint()
+	require.NoError(t, err)
+	stored := checkpoint.Schedule[checkpoint.Done]
+	require.NoError(t, m.AddSessionRun(&SessionRunRecord{
+		Index: stored.Index, WarmUp: stored.WarmUp, Action: ActionDisclosing, MeasurementCondition: stored.MeasurementCondition,
+	}))
+	performed = nil
+	require.NoError(t, m.RunCampaign(campaign, func(sample *CampaignSample) (*SessionRunRecord, error) {
+		performed = append(performed, sample)
+		return &SessionRunRecord{Action: ActionDisclosing, MeasurementCondition: sample.MeasurementCondition}, nil
+	}))
+	require.Len(t, performed, 2)
+	require.Equal(t, 6, performed[0].Index)
+	runs, err := m.SessionRuns()
+	require.NoError(t, err)
+	require.Len(t, runs, 8)
+	for i, run := range runs {
+		require.Equal(t, i, run.Index)
+	}
+
+	// The amount of measurements per run of the app can be configured
+	m.Samples = 2
+	again, err := m.IncrementMeasurementAndDetermineAgain()
+	require.NoError(t, err)
+	require.True(t, again)
+	again, err = m.IncrementMeasurementAndDetermineAgain()
+	require.NoError(t, err)
+	require.False(t, again)
+}
+
+func TestMergeTimelines(t *testing.T) {
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	offset := time.Second // the clock of the server is ahead
+	client := []*RequestTimingRecord{
+		{SessionID: "a", SessionToken: "token", Action: ActionDisclosing, RequestTiming: RequestTiming{
+			RequestID: "second", Method: http.MethodPost, URL: "https://example.com/irma/session/token/proofs",
+			Start: start.Add(100 * time.Millisecond), Total: 50 * time.Millisecond,
+		}},
+		{SessionID: "a", SessionToken: "token", Action: ActionDisclosing, RequestTiming: RequestTiming{
+			RequestID: "first", Method: http.MethodGet, URL: "https://example.com/irma/session/token",
+			Start: start, Total: 30 * time.Millisecond,
+		}},
+		{SessionToken: "other", RequestTiming: RequestTiming{RequestID: "unknown", Start: start, Total: time.Millisecond}},
+		// A later session to which the server handed out the same token
+		{SessionID: "b", SessionToken: "token", Action: ActionDisclosing, RequestTiming: RequestTiming{
+			RequestID: "third", Method: http.MethodGet, URL: "https://example.com/irma/session/token",
+			Start: start.Add(time.Second), Total: 30 * time.Millisecond,
+		}},
+	}
+
+	serverLog := strings.Join([]string{
+		`not JSON`,
+		`{"level":"info","msg":"Server started","time":"2020-01-01T12:00:00Z"}`,
+		`{"level":"trace","method":"GET","msg":"=> request","requestId":"first","session":"token","sessionId":"a","time":"` +
+			start.Add(offset+10*time.Millisecond).Format(time.RFC3339Nano) + `","url":"/irma/session/token"}`,
+		`{"duration":"10ms","level":"trace","msg":"<= response","requestId":"first","session":"token","status":200,"time":"` +
+			start.Add(offset+21*time.Millisecond).Format(time.RFC3339Nano) + `"}`,
+		`{"level":"trace","method":"POST","msg":"=> request","requestId":"second","session":"token","time":"` +
+			start.Add(offset+110*time.Millisecond).Format(time.RFC3339Nano) + `","url":"/irma/session/token/proofs"}`,
+		`{"duration":"30ms","level":"trace","msg":"<= response","requestId":"second","session":"token","status":200,"time":"` +
+			start.Add(offset+140*time.Millisecond).Format(time.RFC3339Nano) + `"}`,
+		`{"level":"trace","method":"GET","msg":"=> request","requestId":"third","session":"token","sessionId":"b","time":"` +
+			start.Add(offset+time.Second+10*time.Millisecond).Format(time.RFC3339Nano) + `","url":"/irma/session/token"}`,
+		`{"duration":"10ms","level":"trace","msg":"<= response","requestId":"third","session":"token","sessionId":"b","status":200,"time":"` +
+			start.Add(offset+time.Second+21*time.Millisecond).Format(time.RFC3339Nano) + `"}`,
+	}, "\n")
+	server, err := ParseServerLog(strings.NewReader(serverLog))
+	require.NoError(t, err)
+	require.Len(t, server, 3)
+	require.Equal(t, "token", server[0].SessionToken)
+	require.Equal(t, "a", server[0].SessionID)
+	require.Equal(t, http.MethodGet, server[0].Method)
+	require.Equal(t, 200, server[0].Status)
+	require.Equal(t, 10*time.Millisecond, server[0].Responded.Sub(server[0].Received))
+
+	timelines := MergeTimelines(client, server)
+	require.Len(t, timelines, 3)
+	timeline := timelines[0]
+	require.Equal(t, "a", timeline.SessionID)
+	require.Equal(t, "token", timeline.SessionToken)
+	require.Equal(t, ActionDisclosing, timeline.Action)
+	require.Len(t, timeline.Requests, 2)
+
+	first := timeline.Requests[0]
+	require.Equal(t, "first", first.RequestID)
+	require.True(t, first.Matched)
+	require.Equal(t, start.Add(offset+10*time.Millisecond), first.ServerReceive)
+	require.Equal(t, 10*time.Millisecond, first.ServerTime)
+	require.Equal(t, 10*time.Millisecond, first.OneWayDelay)
+	require.Equal(t, offset, first.ClockOffset)
+
+	second := timeline.Requests[1]
+	require.Equal(t, "second", second.RequestID)
+	require.Equal(t, 10*time.Millisecond, second.OneWayDelay)
+	require.Equal(t, offset, second.ClockOffset)
+
+	require.Equal(t, "other", timelines[1].SessionToken)
+	require.False(t, timelines[1].Requests[0].Matched)
+
+	// Sessions sharing a token are kept apart by their session IDs
+	require.Equal(t, "b", timelines[2].SessionID)
+	require.Equal(t, "token", timelines[2].SessionToken)
+	require.Len(t, timelines[2].Requests, 1)
+	require.Equal(t, "third", timelines[2].Requests[0].RequestID)
+	require.True(t, timelines[2].Requests[0].Matched)
+}
+
+func TestServerTimings(t *testing.T) {
+	timings := ServerTimings{}.
+		Add("verify", "disclosure verification", 2*time.Millisecond).
+		Add("sign", "", time.Millisecond).
+		Add("sign", "", 500*time.Microsecond).
+		Add(ServerTimingTotal, "", 4*time.Millisecond)
+	header := timings.String()
+	require.Equal(t, `verify;desc="disclosure verification";dur=2.000, sign;dur=1.500, total;dur=4.000`, header)
+	require.Equal(t, timings, ParseServerTimings(header))
+
+	parsed := ParseServerTimings(`cache;desc="a, b; c", db;dur=53.2, ;dur=1, app;dur=x`)
+	require.Len(t, parsed, 3)
+	require.Equal(t, "a, b; c", parsed[0].Description)
+	require.Equal(t, 53200*time.Microsecond, parsed[1].Duration)
+	require.Zero(t, parsed[2].Duration)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(ServerTimingHeader, timings.String())
+		_, _ = w.Write([]byte("VALID"))
+	}))
+	defer ts.Close()
+	sm := NewSessionMeasurements(ActionDisclosing, measurementConditions[0])
+	transport := NewHTTPTransport(ts.URL, false)
+	transport.TimingSink = sm
+	var response string
+	require.NoError(t, transport.Post("proofs", &response, "message"))
+	sm.AddRoundTrip(OperationDisclosureRespondPermission, 10*time.Millisecond)
+
+	require.Equal(t, timings, sm.RequestTimings()[0].ServerTimings)
+	require.Equal(t, 4*time.Millisecond, sm.Records()[0].ServerDuration)
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/fxamacker/cbor"
 	"github.com/go-errors/errors"
 	"github.com/privacybydesign/gabi"
+	"github.com/privacybydesign/gabi/big"
 )
 
 // Status encodes the status of an IRMA session (e.g., connected).
@@ -21,6 +22,16 @@ type Status string
 const (
 	MinVersionHeader = "X-IRMA-MinProtocolVersion"
 	MaxVersionHeader = "X-IRMA-MaxProtocolVersion"
+
+	// ServerTimingHeader contains the time the server spent handling a request, see
+	// https://www.w3.org/TR/server-timing/.
+	ServerTimingHeader = "Server-Timing"
+
+	// RequestIDHeader identifies a request in the measurements of both the client and the server.
+	RequestIDHeader = "X-IRMA-RequestID"
+	// SessionIDHeader identifies the session of a request in the measurements of both the client
+	// and the server. Unlike the session token it is unique per measured session.
+	SessionIDHeader = "X-IRMA-SessionID"
 )
 
 // ProtocolVersion encodes the IRMA protocol version of an IRMA session.
@@ -223,6 +234,12 @@ const (
 	ErrorInvalidRequest = ErrorType("invalidRequest")
 	// Recovered panic
 	ErrorPanic = ErrorType("panic")
+	// Error storing measurements
+	ErrorMeasurement = ErrorType("measurement")
+	// Error starting Tor, or client is configured to use Tor but is not connected over Tor
+	ErrorTor = ErrorType("tor")
+	// Session did not finish in time
+	ErrorTimeout = ErrorType("timeout")
 )
 
 type Disclosure struct {
@@ -247,6 +264,74 @@ type IssueCommitmentMessage struct {
 	Indices DisclosedAttributeIndices `json:"indices,omitempty"`
 }
 
+// binaryProofList is a gabi.ProofList that can be decoded from CBOR. As in JSON, the type of the
+// proofs is not encoded, so it is inferred from their contents like gabi.ProofList.UnmarshalJSON does.
+type binaryProofList gabi.ProofList
+
+func (pl *binaryProofList) UnmarshalCBOR(data []byte) error {
+	var raw []cbor.RawMessage
+	if err := cbor.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	proofs := make(binaryProofList, 0, len(raw))
+	for _, bts := range raw {
+		proofd := &gabi.ProofD{}
+		if err := cbor.Unmarshal(bts, proofd); err != nil {
+			return err
+		}
+		if proofd.A != nil {
+			proofs = append(proofs, proofd)
+			continue
+		}
+		proofu := &gabi.ProofU{}
+		if err := cbor.Unmarshal(bts, proofu); err != nil {
+			return err
+		}
+		if proofu.U != nil {
+			proofs = append(proofs, proofu)
+			continue
+		}
+		return errors.New("unknown proof type found in proof list")
+	}
+	*pl = proofs
+	return nil
+}
+
+func (d *Disclosure) UnmarshalCBOR(data []byte) error {
+	var tmp struct {
+		Proofs  binaryProofList           `json:"proofs"`
+		Indices DisclosedAttributeIndices `json:"indices"`
+	}
+	if err := cbor.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+	d.Proofs, d.Indices = gabi.ProofList(tmp.Proofs), tmp.Indices
+	return nil
+}
+
+func (msg *IssueCommitmentMessage) UnmarshalCBOR(data []byte) error {
+	var tmp struct {
+		U          *big.Int                  `json:"U,omitempty"`
+		Nonce2     *big.Int                  `json:"n_2"`
+		Proofs     binaryProofList           `json:"combinedProofs"`
+		ProofPjwt  string                    `json:"proofPJwt,omitempty"`
+		ProofPjwts map[string]string         `json:"proofPJwts,omitempty"`
+		Indices    DisclosedAttributeIndices `json:"indices,omitempty"`
+	}
+	if err := cbor.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+	msg.IssueCommitmentMessage = &gabi.IssueCommitmentMessage{
+		U:          tmp.U,
+		Nonce2:     tmp.Nonce2,
+		Proofs:     gabi.ProofList(tmp.Proofs),
+		ProofPjwt:  tmp.ProofPjwt,
+		ProofPjwts: tmp.ProofPjwts,
+	}
+	msg.Indices = tmp.Indices
+	return nil
+}
+
 func (err ErrorType) Error() string {
 	return string(err)
 }
@@ -5,14 +5,15 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/cretz/bine/control"
 	"github.com/cretz/bine/tor"
+	"github.com/go-errors/errors"
 	"github.com/ipsn/go-libtor"
 	"golang.org/x/net/html"
 )
@@ -35,75 +36,156 @@ func getTitle(n *html.Node) string {
 	return ""
 }
 
+// enableTorNetwork enables the network of t and waits until it has bootstrapped, recording the
+// bootstrap events that it reports over its control port. Unlike t.EnableNetwork, it listens to
+// these events before enabling the network, so that no events are missed.
+func enableTorNetwork(ctx context.Context, t *tor.Tor, start time.Time, measurements *SessionMeasurements) error {
+	// Events are relayed while the control port is read, also during the SETCONF below
+	events := make(chan control.Event, 100)
+	if err := t.Control.AddEventListener(events, control.EventCodeStatusClient); err != nil {
+		return err
+	}
+	defer t.Control.RemoveEventListener(events, control.EventCodeStatusClient)
+
+	if err := t.Control.SetConf(control.KeyVals("DisableNetwork", "0")...); err != nil {
+		return err
+	}
+
+	eventCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make(chan error, 1)
+	go func() { errs <- t.Control.HandleEvents(eventCtx) }()
+	for {
+		select {
+		case <-eventCtx.Done():
+			return eventCtx.Err()
+		case err := <-errs:
+			return err
+		case event := <-events:
+			status, _ := event.(*control.StatusEvent)
+			if status == nil || status.Action != "BOOTSTRAP" {
+				continue
+			}
+			if status.Severity == "ERR" {
+				return errors.Errorf("tor bootstrap failed: %s", status.Arguments["WARNING"])
+			}
+			progress, _ := strconv.Atoi(status.Arguments["PROGRESS"])
+			now := time.Now()
+			measurements.AddTorBootstrapEvent(&TorBootstrapEvent{
+				Time:     now,
+				Elapsed:  now.Sub(start),
+				Progress: progress,
+				Tag:      status.Arguments["TAG"],
+				Summary:  status.Arguments["SUMMARY"],
+			})
+			if progress == 100 {
+				return nil
+			}
+		}
+	}
+}
+
 // public functions
 
-func MakeTorHttpClient(dataDir string) (*tor.Tor, func(), *http.Client) {
+// MakeTorHttpClient starts Tor and returns an HTTP client that connects over it. If measurements
+// is not nil, the bootstrap of Tor and the time until its dialer was available are recorded in it.
+func MakeTorHttpClient(dataDir string, measurements *SessionMeasurements) (*tor.Tor, func(), *http.Client, error) {
+	start := time.Now()
+
 	// Start tor with some defaults + elevated verbosity
 	fmt.Println("Starting, please wait a bit...")
 	t, err := tor.Start(nil, &tor.StartConf{ProcessCreator: libtor.Creator, DebugWriter: os.Stderr, DataDir: dataDir})
 	if err != nil {
-		log.Panicf("Failed to start tor: %v", err)
+		return nil, nil, nil, errors.WrapPrefix(err, "failed to start tor", 0)
 	}
 	// defer t.Close()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
 	// defer cancel()
 
+	if err = enableTorNetwork(ctx, t, start, measurements); err != nil {
+		cancel()
+		_ = t.Close()
+		return nil, nil, nil, errors.WrapPrefix(err, "failed to enable tor network", 0)
+	}
+	measurements.Add(OperationTorBootstrap, time.Since(start))
+
 	// Make connection
-	dialer, err := t.Dialer(ctx, nil)
+	dialer, err := t.Dialer(ctx, &tor.DialConf{SkipEnableNetwork: true})
 	if err != nil {
-		log.Panicf("err", err)
+		cancel()
+		_ = t.Close()
+		return nil, nil, nil, errors.WrapPrefix(err, "failed to make tor dialer", 0)
 	}
+	measurements.Add(OperationTorFirstDialer, time.Since(start))
 
-	return t, cancel, &http.Client{Transport: &http.Transport{DialContext: dialer.DialContext}}
+	return t, cancel, &http.Client{Transport: &http.Transport{DialContext: dialer.DialContext}}, nil
 }
 
-func IsClientConnectedToTor(httpClient *http.Client) bool {
+// TorVersion returns the version of the running Tor.
+func TorVersion(t *tor.Tor) (string, error) {
+	info, err := t.Control.GetInfo("version")
+	if err != nil {
+		return "", errors.WrapPrefix(err, "failed to get tor version", 0)
+	}
+	if len(info) == 0 {
+		return "", errors.New("tor did not report its version")
+	}
+	return info[0].Val, nil
+}
+
+func IsClientConnectedToTor(httpClient *http.Client) (bool, error) {
 	resp, err := httpClient.Get("https://check.torproject.org")
 	if err != nil {
-		log.Fatal("err", err)
+		return false, err
 	}
 	defer resp.Body.Close()
 
 	parsed, err := html.Parse(resp.Body)
 	if err != nil {
-		log.Fatal("err", err)
+		return false, err
 	}
 
-	if strings.Contains(getTitle(parsed), "Congratulations.") {
-		return true
-	} else {
-		return false
-	}
+	return strings.Contains(getTitle(parsed), "Congratulations."), nil
 }
 
-func RenewTorCircuit(tor *tor.Tor, cancel func()) (func(), *http.Client) {
-	tor.Control.SetConf(control.KeyVals("DisableNetwork", "1")...)
+// RenewTorCircuit makes Tor build new circuits by disabling and enabling its network, and returns
+// an HTTP client that connects over the new circuits. If measurements is not nil, the duration of
+// the renewal is recorded in it.
+func RenewTorCircuit(tor *tor.Tor, cancel func(), measurements *SessionMeasurements) (func(), *http.Client, error) {
+	start := time.Now()
+	if err := tor.Control.SetConf(control.KeyVals("DisableNetwork", "1")...); err != nil {
+		return cancel, nil, errors.WrapPrefix(err, "failed to disable tor network", 0)
+	}
 
 	cancel()
 
 	ctx := context.Background()
-	tor.EnableNetwork(ctx, false)
+	if err := tor.EnableNetwork(ctx, false); err != nil {
+		return func() {}, nil, errors.WrapPrefix(err, "failed to enable tor network", 0)
+	}
 	ctx, cancel = context.WithTimeout(ctx, 3*time.Minute)
 
 	// Make connection // TODO: copied code
 	dialer, err := tor.Dialer(ctx, nil)
 	if err != nil {
-		log.Panicf("err", err)
+		return cancel, nil, errors.WrapPrefix(err, "failed to make tor dialer", 0)
 	}
+	measurements.Add(OperationTorCircuitRenewal, time.Since(start))
 
-	return cancel, &http.Client{Transport: &http.Transport{DialContext: dialer.DialContext}}
+	return cancel, &http.Client{Transport: &http.Transport{DialContext: dialer.DialContext}}, nil
 }
 
-func PrintExternalIpClient(httpClient *http.Client) {
+func PrintExternalIpClient(httpClient *http.Client) error {
 	resp, err := httpClient.Get("https://api.ipify.org?format=text")
 	if err != nil {
-		log.Panicf("err", err)
+		return err
 	}
 	defer resp.Body.Close()
 	ip, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Panicf("err", err)
+		return err
 	}
 	fmt.Printf("External IP is: %s\n", ip)
+	return nil
 }
@@ -0,0 +1,510 @@
+package irma
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-errors/errors"
+)
+
+// NetworkShape describes network conditions to emulate on connections, so that measurements over
+// Tor can be compared with measurements under controlled conditions, e.g. on localhost.
+//
+// Both directions of a shaped connection are shaped separately. The data sent in either direction
+// is limited to Bandwidth, and is delivered after Latency plus a random deviation of at most Jitter.
+// With probability StallProbability, data is held back for another StallDuration, as if it had to
+// be retransmitted after packet loss. The order of the data is always kept. Shaping both ends of a
+// connection, e.g. at the client and at the server, shapes it twice.
+type NetworkShape struct {
+	Name             string        `json:"name,omitempty"` // of the preset, if the shape is one
+	Latency          time.Duration `json:"latencyNs,omitempty"`
+	Jitter           time.Duration `json:"jitterNs,omitempty"`
+	Bandwidth        int64         `json:"bandwidth,omitempty"` // in bytes per second, 0 for unlimited
+	StallProbability float64       `json:"stallProbability,omitempty"`
+	StallDuration    time.Duration `json:"stallDurationNs,omitempty"`
+	Seed             int64         `json:"seed,omitempty"` // of the random deviations, 0 for a random seed
+}
+
+// networkShapes contains the presets of ParseNetworkShape.
+var networkShapes = map[string]NetworkShape{
+	// Roughly a Tor circuit: about 0.5s round trip time and some megabits per second
+	"tor": {Latency: 250 * time.Millisecond, Jitter: 75 * time.Millisecond, Bandwidth: 250000, StallProbability: 0.01, StallDuration: time.Second},
+	// Roughly a 3G mobile connection
+	"3g": {Latency: 100 * time.Millisecond, Jitter: 20 * time.Millisecond, Bandwidth: 200000, StallProbability: 0.005, StallDuration: 500 * time.Millisecond},
+}
+
+var errShapedConnClosed = errors.New("use of closed shaped connection")
+
+// shapedConnCloseTimeout is how long Close waits, after the written data should have been
+// delivered, for the peer to accept it before closing the connection anyway.
+var shapedConnCloseTimeout = 5 * time.Second
+
+// ParseNetworkShape parses the name of a preset ("tor" or "3g"), or a comma-separated list of
+// parameters, e.g. "latency=100ms,jitter=10ms,bandwidth=500k,stall=0.01,stall-duration=1s,seed=1".
+// The bandwidth is in bytes per second, optionally with suffix k, M or G.
+func ParseNetworkShape(spec string) (*NetworkShape, error) {
+	if preset, ok := networkShapes[spec]; ok {
+		preset.Name = spec
+		return &preset, nil
+	}
+
+	shape := &NetworkShape{}
+	for _, param := range strings.Split(spec, ",") {
+		parts := strings.SplitN(param, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("invalid network shape parameter %s: expected a preset or key=value", param)
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		var err error
+		switch key {
+		case "latency":
+			shape.Latency, err = time.ParseDuration(value)
+		case "jitter":
+			shape.Jitter, err = time.ParseDuration(value)
+		case "bandwidth":
+			shape.Bandwidth, err = parseByteRate(value)
+		case "stall":
+			shape.StallProbability, err = strconv.ParseFloat(value, 64)
+		case "stall-duration":
+			shape.StallDuration, err = time.ParseDuration(value)
+		case "seed":
+			shape.Seed, err = strconv.ParseInt(value, 10, 64)
+		default:
+			return nil, errors.Errorf("unknown network shape parameter %s", key)
+		}
+		if err != nil {
+			return nil, errors.WrapPrefix(err, "invalid network shape parameter "+key, 0)
+		}
+	}
+	if shape.Latency < 0 || shape.Jitter < 0 || shape.Bandwidth < 0 || shape.StallDuration < 0 {
+		return nil, errors.New("network shape parameters must not be negative")
+	}
+	if shape.StallProbability < 0 || shape.StallProbability > 1 {
+		return nil, errors.New("network shape stall probability must be between 0 and 1")
+	}
+	return shape, nil
+}
+
+func parseByteRate(value string) (int64, error) {
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(value, "k"):
+		multiplier = 1000
+	case strings.HasSuffix(value, "M"):
+		multiplier = 1000 * 1000
+	case strings.HasSuffix(value, "G"):
+		multiplier = 1000 * 1000 * 1000
+	}
+	if multiplier != 1 {
+		value = value[:len(value)-1]
+	}
+	rate, err := strconv.ParseInt(value, 10, 64)
+	return rate * multiplier, err
+}
+
+// String returns the name of the preset, or else the parameters of the shape as accepted by
+// ParseNetworkShape.
+func (shape *NetworkShape) String() string {
+	if shape.Name != "" {
+		return shape.Name
+	}
+	var params []string
+	if shape.Latency != 0 {
+		params = append(params, "latency="+shape.Latency.String())
+	}
+	if shape.Jitter != 0 {
+		params = append(params, "jitter="+shape.Jitter.String())
+	}
+	if shape.Bandwidth != 0 {
+		params = append(params, "bandwidth="+strconv.FormatInt(shape.Bandwidth, 10))
+	}
+	if shape.StallProbability != 0 {
+		params = append(params, "stall="+strconv.FormatFloat(shape.StallProbability, 'g', -1, 64))
+	}
+	if shape.StallDuration != 0 {
+		params = append(params, "stall-duration="+shape.StallDuration.String())
+	}
+	if shape.Seed != 0 {
+		params = append(params, "seed="+strconv.FormatInt(shape.Seed, 10))
+	}
+	return strings.Join(params, ",")
+}
+
+// Conn returns a connection that shapes the traffic over conn. If shape is nil, conn is returned.
+func (shape *NetworkShape) Conn(conn net.Conn) net.Conn {
+	if shape == nil {
+		return conn
+	}
+	seed := shape.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	c := &shapedConn{
+		Conn:            conn,
+		outgoing:        &networkShaper{shape: shape, random: rand.New(rand.NewSource(seed))},
+		incoming:        &networkShaper{shape: shape, random: rand.New(rand.NewSource(seed + 1))},
+		writing:         make(chan struct{}, 1),
+		writes:          make(chan shapedChunk, 64),
+		stopWrites:      make(chan struct{}),
+		written:         make(chan struct{}),
+		reads:           make(chan shapedChunk, 64),
+		deadlineChanged: make(chan struct{}),
+		closing:         make(chan struct{}),
+		closeTimeout:    shapedConnCloseTimeout,
+	}
+	go c.writeLoop()
+	go c.readLoop()
+	return c
+}
+
+// DialContext returns a function dialing with dial, or with a net.Dialer if dial is nil, whose
+// connections are shaped.
+func (shape *NetworkShape) DialContext(
+	dial func(ctx context.Context, network, addr string) (net.Conn, error),
+) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return shape.Conn(conn), nil
+	}
+}
+
+// HTTPClient returns an HTTP client whose connections are shaped, which can be passed to
+// NewHTTPTransport.
+func (shape *NetworkShape) HTTPClient() *http.Client {
+	return &http.Client{Transport: &http.Transport{DialContext: shape.DialContext(nil)}}
+}
+
+// Listener returns a listener whose accepted connections are shaped.
+func (shape *NetworkShape) Listener(listener net.Listener) net.Listener {
+	return &shapedListener{Listener: listener, shape: shape}
+}
+
+type shapedListener struct {
+	net.Listener
+	shape *NetworkShape
+}
+
+func (l *shapedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.shape.Conn(conn), nil
+}
+
+// networkShaper schedules the delivery of the data sent in one direction of a shaped connection.
+type networkShaper struct {
+	shape  *NetworkShape
+	random *rand.Rand
+
+	linkFree  time.Time // when the previous data has been sent, given the bandwidth
+	delivered time.Time // when the previous data is delivered
+}
+
+// schedule returns when the data of the specified size, sent at the specified time, is delivered.
+func (s *networkShaper) schedule(size int, sent time.Time) time.Time {
+	if s.shape.Bandwidth > 0 {
+		if s.linkFree.After(sent) {
+			sent = s.linkFree
+		}
+		sent = sent.Add(time.Duration(int64(size) * int64(time.Second) / s.shape.Bandwidth))
+		s.linkFree = sent
+	}
+	delay := s.shape.Latency
+	if s.shape.Jitter > 0 {
+		delay += time.Duration((2*s.random.Float64() - 1) * float64(s.shape.Jitter))
+	}
+	if s.shape.StallProbability > 0 && s.random.Float64() < s.shape.StallProbability {
+		delay += s.shape.StallDuration
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	delivered := sent.Add(delay)
+	if delivered.Before(s.delivered) {
+		delivered = s.delivered
+	}
+	s.delivered = delivered
+	return delivered
+}
+
+// shapedChunk is data sent over a shaped connection, along with when it is delivered.
+type shapedChunk struct {
+	data []byte
+	err  error
+	at   time.Time
+}
+
+// shapedConn shapes the traffic over a connection. Written data is delivered to the underlying
+// connection in the background when its time has come, so that writes do not block unless much
+// data is held back. Data read from the underlying connection in the background is returned by
+// Read when its time has come. As data may be held back, shapedConn keeps its own deadlines.
+type shapedConn struct {
+	net.Conn
+	outgoing, incoming *networkShaper
+
+	writing    chan struct{} // held by the current writer, so that data is queued in the order it is scheduled
+	writes     chan shapedChunk
+	stopWrites chan struct{} // closed by Close
+	written    chan struct{} // closed when all writes have been delivered
+
+	writeMutex sync.Mutex
+	closed     bool
+	lastWrite  time.Time // when the data written last is due to be delivered
+
+	errMutex sync.Mutex
+	writeErr error
+
+	readMutex sync.Mutex
+	reads     chan shapedChunk
+	current   *shapedChunk
+
+	deadlineMutex   sync.Mutex
+	readDeadline    time.Time
+	writeDeadline   time.Time
+	deadlineChanged chan struct{} // closed and replaced when the read deadline changes
+
+	closing      chan struct{}
+	closeTimeout time.Duration
+}
+
+type shapedTimeoutError struct{}
+
+func (shapedTimeoutError) Error() string   { return "i/o timeout" }
+func (shapedTimeoutError) Timeout() bool   { return true }
+func (shapedTimeoutError) Temporary() bool { return true }
+
+// Write queues the data for delivery. If too much data is held back already, it waits until
+// there is room, until the write deadline passes or until the connection is closed.
+func (c *shapedConn) Write(b []byte) (int, error) {
+	c.deadlineMutex.Lock()
+	deadline := c.writeDeadline
+	c.deadlineMutex.Unlock()
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		if !time.Now().Before(deadline) {
+			return 0, shapedTimeoutError{}
+		}
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case c.writing <- struct{}{}:
+		defer func() { <-c.writing }()
+	case <-c.stopWrites:
+		return 0, errShapedConnClosed
+	case <-timeout:
+		return 0, shapedTimeoutError{}
+	}
+
+	c.writeMutex.Lock()
+	if c.closed {
+		c.writeMutex.Unlock()
+		return 0, errShapedConnClosed
+	}
+	if err := c.writeError(); err != nil {
+		c.writeMutex.Unlock()
+		return 0, err
+	}
+	chunk := shapedChunk{data: append([]byte{}, b...), at: c.outgoing.schedule(len(b), time.Now())}
+	c.writeMutex.Unlock()
+
+	select {
+	case c.writes <- chunk:
+	case <-c.stopWrites:
+		return 0, errShapedConnClosed
+	case <-timeout:
+		return 0, shapedTimeoutError{}
+	}
+	c.writeMutex.Lock()
+	c.lastWrite = chunk.at
+	c.writeMutex.Unlock()
+	return len(b), nil
+}
+
+func (c *shapedConn) writeError() error {
+	c.errMutex.Lock()
+	defer c.errMutex.Unlock()
+	return c.writeErr
+}
+
+func (c *shapedConn) writeLoop() {
+	defer close(c.written)
+	for {
+		select {
+		case chunk := <-c.writes:
+			c.deliver(chunk)
+		case <-c.stopWrites:
+			// Deliver the data that was queued before the connection was closed
+			for {
+				select {
+				case chunk := <-c.writes:
+					c.deliver(chunk)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// deliver writes the chunk to the underlying connection when its time has come.
+func (c *shapedConn) deliver(chunk shapedChunk) {
+	time.Sleep(time.Until(chunk.at))
+	if c.writeError() != nil {
+		return
+	}
+	if _, err := c.Conn.Write(chunk.data); err != nil {
+		c.errMutex.Lock()
+		c.writeErr = err
+		c.errMutex.Unlock()
+	}
+}
+
+func (c *shapedConn) readLoop() {
+	for {
+		buf := make([]byte, 32*1024)
+		n, err := c.Conn.Read(buf)
+		if n == 0 && err == nil {
+			continue
+		}
+		chunk := shapedChunk{data: buf[:n], err: err, at: c.incoming.schedule(n, time.Now())}
+		select {
+		case c.reads <- chunk:
+		case <-c.closing:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (c *shapedConn) Read(b []byte) (int, error) {
+	c.readMutex.Lock()
+	defer c.readMutex.Unlock()
+
+	// Wait until data has been read from the underlying connection and its time has come
+	for {
+		c.deadlineMutex.Lock()
+		deadline, changed := c.readDeadline, c.deadlineChanged
+		c.deadlineMutex.Unlock()
+		now := time.Now()
+		if !deadline.IsZero() && !now.Before(deadline) {
+			return 0, shapedTimeoutError{}
+		}
+
+		var reads <-chan shapedChunk
+		wakeup := deadline
+		if c.current == nil {
+			reads = c.reads
+		} else if now.Before(c.current.at) {
+			if wakeup.IsZero() || c.current.at.Before(wakeup) {
+				wakeup = c.current.at
+			}
+		} else {
+			break
+		}
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !wakeup.IsZero() {
+			timer = time.NewTimer(wakeup.Sub(now))
+			timeout = timer.C
+		}
+		select {
+		case chunk := <-reads:
+			c.current = &chunk
+		case <-timeout:
+		case <-changed:
+		case <-c.closing:
+			return 0, errShapedConnClosed
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+
+	n := copy(b, c.current.data)
+	c.current.data = c.current.data[n:]
+	switch {
+	case len(c.current.data) > 0:
+		return n, nil
+	case c.current.err == nil:
+		c.current = nil
+		return n, nil
+	case n > 0:
+		// The error is returned by the next call
+		return n, nil
+	default:
+		return 0, c.current.err
+	}
+}
+
+func (c *shapedConn) SetDeadline(t time.Time) error {
+	_ = c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+func (c *shapedConn) SetWriteDeadline(t time.Time) error {
+	c.deadlineMutex.Lock()
+	c.writeDeadline = t
+	c.deadlineMutex.Unlock()
+	return c.Conn.SetWriteDeadline(t)
+}
+
+func (c *shapedConn) SetReadDeadline(t time.Time) error {
+	c.deadlineMutex.Lock()
+	defer c.deadlineMutex.Unlock()
+	c.readDeadline = t
+	close(c.deadlineChanged)
+	c.deadlineChanged = make(chan struct{})
+	return nil
+}
+
+// Close closes the connection after the data written to it has been delivered. If the peer does
+// not accept the data before the write deadline, or within shapedConnCloseTimeout after it should
+// have been delivered, the connection is closed anyway and the remaining data is discarded.
+func (c *shapedConn) Close() error {
+	c.writeMutex.Lock()
+	if c.closed {
+		c.writeMutex.Unlock()
+		return errShapedConnClosed
+	}
+	c.closed = true
+	close(c.stopWrites)
+	deadline := c.lastWrite.Add(c.closeTimeout)
+	c.writeMutex.Unlock()
+
+	c.deadlineMutex.Lock()
+	if !c.writeDeadline.IsZero() && c.writeDeadline.Before(deadline) {
+		deadline = c.writeDeadline
+	}
+	c.deadlineMutex.Unlock()
+	if now := time.Now(); deadline.Before(now) {
+		deadline = now
+	}
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case <-c.written:
+	case <-timer.C:
+	}
+	close(c.closing)
+	return c.Conn.Close()
+}
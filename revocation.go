@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"math/bits"
+	"net/http"
 	"sort"
 	"strings"
 	"sync"
@@ -51,7 +52,11 @@ type (
 	RevocationClient struct {
 		Conf     *Configuration
 		Settings RevocationSettings
-		http     *HTTPTransport
+		// HTTPClient, if set, is used to contact the revocation servers, e.g. over Tor
+		HTTPClient *http.Client
+		// TimingSink, if set, receives the timing of each request to the revocation servers
+		TimingSink RequestTimingSink
+		http       *HTTPTransport
 	}
 
 	// RevocationKeys contains helper functions for retrieving revocation private and public keys
@@ -970,8 +975,13 @@ func (client RevocationClient) getMultiple(urls []string, path string, dest inte
 
 func (client RevocationClient) transport(forceHTTPS bool) *HTTPTransport {
 	if client.http == nil {
-		client.http = NewHTTPTransport("", forceHTTPS)
+		if client.HTTPClient != nil {
+			client.http = NewHTTPTransport("", forceHTTPS, client.HTTPClient)
+		} else {
+			client.http = NewHTTPTransport("", forceHTTPS)
+		}
 		client.http.Binary = true
+		client.http.TimingSink = client.TimingSink
 	}
 	return client.http
 }
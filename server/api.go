@@ -33,13 +33,14 @@ type SessionPackage struct {
 // SessionResult contains session information such as the session status, type, possible errors,
 // and disclosed attributes or attribute-based signature if appropriate to the session type.
 type SessionResult struct {
-	Token       string                       `json:"token"`
-	Status      Status                       `json:"status"`
-	Type        irma.Action                  `json:"type"'`
-	ProofStatus irma.ProofStatus             `json:"proofStatus,omitempty"`
-	Disclosed   [][]*irma.DisclosedAttribute `json:"disclosed,omitempty"`
-	Signature   *irma.SignedMessage          `json:"signature,omitempty"`
-	Err         *irma.RemoteError            `json:"error,omitempty"`
+	Token       string                        `json:"token"`
+	Status      Status                        `json:"status"`
+	Type        irma.Action                   `json:"type"'`
+	ProofStatus irma.ProofStatus              `json:"proofStatus,omitempty"`
+	Disclosed   [][]*irma.DisclosedAttribute  `json:"disclosed,omitempty"`
+	Signature   *irma.SignedMessage           `json:"signature,omitempty"`
+	Err         *irma.RemoteError             `json:"error,omitempty"`
+	Timings     map[string]irma.ServerTimings `json:"timings,omitempty"` // time spent per protocol message, if server timing is enabled
 
 	LegacySession bool `json:"-"` // true if request was started with legacy (i.e. pre-condiscon) session request
 }
@@ -163,6 +164,22 @@ func WriteBinaryResponse(w http.ResponseWriter, object interface{}, rerr *irma.R
 	_, _ = w.Write(bts)
 }
 
+// BinaryRequest returns whether the body of the request is CBOR-encoded, in which case the
+// response is expected to be CBOR-encoded as well.
+func BinaryRequest(r *http.Request) bool {
+	return r.Header.Get("Content-Type") == "application/octet-stream"
+}
+
+// WriteEncodedResponse writes the specified object or error to the http.ResponseWriter, as CBOR
+// if binary is true and as JSON otherwise.
+func WriteEncodedResponse(w http.ResponseWriter, binary bool, object interface{}, rerr *irma.RemoteError) {
+	if binary {
+		WriteBinaryResponse(w, object, rerr)
+	} else {
+		WriteResponse(w, object, rerr)
+	}
+}
+
 // WriteResponse writes the specified object or error as JSON to the http.ResponseWriter.
 func WriteResponse(w http.ResponseWriter, object interface{}, rerr *irma.RemoteError) {
 	status, bts := JsonResponse(object, rerr)
@@ -376,13 +393,17 @@ func LogWarning(err error) {
 	_ = log(logrus.WarnLevel, err)
 }
 
-func LogRequest(typ, proto, method, url, from string, headers http.Header, message []byte) {
+// LogRequest logs an incoming request. If set, the session token and the IDs of the session and
+// the request as sent by the client are logged too, so that the log can be joined with client
+// measurements.
+func LogRequest(typ, proto, method, url, from, session, sessionID, requestID string, headers http.Header, message []byte) {
 	fields := logrus.Fields{
 		"type":   typ,
 		"proto":  proto,
 		"method": method,
 		"url":    url,
 	}
+	addRequestIdentifiers(fields, session, sessionID, requestID)
 	if len(headers) > 0 {
 		fields["headers"] = headers
 	}
@@ -399,11 +420,13 @@ func LogRequest(typ, proto, method, url, from string, headers http.Header, messa
 	Logger.WithFields(fields).Tracef("=> request")
 }
 
-func LogResponse(status int, duration time.Duration, binary bool, response []byte) {
+// LogResponse logs the response to a request, see LogRequest.
+func LogResponse(status int, duration time.Duration, binary bool, session, sessionID, requestID string, response []byte) {
 	fields := logrus.Fields{
 		"status":   status,
 		"duration": duration.String(),
 	}
+	addRequestIdentifiers(fields, session, sessionID, requestID)
 	if len(response) > 0 {
 		if binary {
 			fields["response"] = hex.EncodeToString(response)
@@ -419,6 +442,31 @@ func LogResponse(status int, duration time.Duration, binary bool, response []byt
 	}
 }
 
+func addRequestIdentifiers(fields logrus.Fields, session, sessionID, requestID string) {
+	if session != "" {
+		fields["session"] = session
+	}
+	if sessionID != "" {
+		fields["sessionId"] = sessionID
+	}
+	if requestID != "" {
+		fields["requestId"] = requestID
+	}
+}
+
+// SessionTokenFromPath returns the session token from the path of a request to the session
+// endpoints of an IRMA server, e.g. /irma/session/<token>/proofs, or the empty string if the
+// path does not contain a session token.
+func SessionTokenFromPath(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	for i := 0; i < len(parts)-1; i++ {
+		if parts[i] == "session" {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
+
 func ToJson(o interface{}) string {
 	bts, _ := json.Marshal(o)
 	return string(bts)
@@ -434,7 +482,8 @@ func NewLogger(verbosity int, quiet bool, json bool) *logrus.Logger {
 
 	logger.Level = Verbosity(verbosity)
 	if json {
-		logger.SetFormatter(&logrus.JSONFormatter{})
+		// Nanosecond timestamps allow the log to be joined with client measurements
+		logger.SetFormatter(&logrus.JSONFormatter{TimestampFormat: time.RFC3339Nano})
 	} else {
 		logger.SetFormatter(&prefixed.TextFormatter{
 			FullTimestamp: true,
@@ -474,7 +523,10 @@ func LogMiddleware(typ string, opts LogOptions) func(next http.Handler) http.Han
 			if opts.From {
 				from = r.RemoteAddr
 			}
-			LogRequest(typ, r.Proto, r.Method, r.URL.String(), from, headers, message)
+			session := SessionTokenFromPath(r.URL.Path)
+			sessionID := r.Header.Get(irma.SessionIDHeader)
+			requestID := r.Header.Get(irma.RequestIDHeader)
+			LogRequest(typ, r.Proto, r.Method, r.URL.String(), from, session, sessionID, requestID, headers, message)
 
 			// copy output of HTTP handler to our buffer for later logging
 			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
@@ -501,7 +553,7 @@ func LogMiddleware(typ string, opts LogOptions) func(next http.Handler) http.Han
 				if opts.EncodeBinary && ww.Header().Get("Content-Type") != "application/json" {
 					hexencode = true
 				}
-				LogResponse(ww.Status(), time.Since(start), hexencode, resp)
+				LogResponse(ww.Status(), time.Since(start), hexencode, session, sessionID, requestID, resp)
 			}()
 
 			// start timer and preform request
@@ -73,3 +73,11 @@ func TestParseSessionRequest(t *testing.T) {
 		require.Error(t, err)
 	})
 }
+
+func TestSessionTokenFromPath(t *testing.T) {
+	require.Equal(t, "abc", server.SessionTokenFromPath("/irma/session/abc/proofs"))
+	require.Equal(t, "abc", server.SessionTokenFromPath("/session/abc"))
+	require.Equal(t, "abc", server.SessionTokenFromPath("/session/abc/"))
+	require.Equal(t, "", server.SessionTokenFromPath("/session"))
+	require.Equal(t, "", server.SessionTokenFromPath("/revocation/update"))
+}
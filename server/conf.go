@@ -71,6 +71,9 @@ type Configuration struct {
 	LogJSON bool `json:"log_json" mapstructure:"log_json"`
 	// Custom logger instance. If specified, Verbose, Quiet and LogJSON are ignored.
 	Logger *logrus.Logger `json:"-"`
+	// Metrics of the server. If specified, the server records the outcomes of sessions and the
+	// revocation updates in it.
+	Metrics *Metrics `json:"-"`
 
 	// Connection string for revocation database
 	RevocationDBConnStr string `json:"revocation_db_str" mapstructure:"revocation_db_str"`
@@ -81,6 +84,11 @@ type Configuration struct {
 
 	// Production mode: enables safer and stricter defaults and config checking
 	Production bool `json:"production" mapstructure:"production"`
+
+	// EnableServerTiming sends the time spent on each protocol message in the Server-Timing
+	// header, and includes these timings in session results, for measurements. Not allowed in
+	// production mode, as it exposes the duration of verification, revocation and signing.
+	EnableServerTiming bool `json:"server_timing" mapstructure:"server_timing"`
 }
 
 // Check ensures that the Configuration is loaded, usable and free of errors.
@@ -100,6 +108,7 @@ func (conf *Configuration) Check() error {
 		conf.verifyRevocation,
 		conf.verifyStaticSessions,
 		conf.verifyJwtPrivateKey,
+		conf.verifyServerTiming,
 	} {
 		if err := f(); err != nil {
 			_ = LogError(err)
@@ -397,6 +406,17 @@ func (conf *Configuration) verifyEmail() error {
 	return nil
 }
 
+func (conf *Configuration) verifyServerTiming() error {
+	if !conf.EnableServerTiming {
+		return nil
+	}
+	if conf.Production {
+		return errors.New("server_timing must not be used in production mode")
+	}
+	conf.Logger.Warn("Server timing enabled: the time spent on each protocol message is disclosed to clients and requestors")
+	return nil
+}
+
 func (conf *Configuration) verifyJwtPrivateKey() error {
 	if conf.JwtPrivateKey == "" && conf.JwtPrivateKeyFile == "" {
 		return nil
@@ -70,12 +70,16 @@ func New(conf *server.Configuration) (*Server, error) {
 			if err := s.conf.IrmaConfiguration.Revocation.SyncIfOld(credid, settings.Tolerance/2); err != nil {
 				s.conf.Logger.Errorf("failed to update revocation database for %s", credid.String())
 				_ = server.LogError(err)
+				s.conf.Metrics.RevocationUpdate(credid, server.RevocationUpdateSyncFailed)
 			}
 		}
 	})
 
 	s.stopScheduler = s.scheduler.Start()
 
+	conf.Metrics.SetActiveSessions(s.sessions.count)
+	conf.Metrics.SetSchemeUpdateFailures(conf.IrmaConfiguration.SchemeUpdateFailures)
+
 	return s, nil
 }
 
@@ -36,6 +36,7 @@ func (session *session) handleGetRequest(min, max *irma.ProtocolVersion) (irma.S
 	if session.status != server.StatusInitialized {
 		return nil, server.RemoteError(server.ErrorUnexpectedRequest, "Session already started")
 	}
+	defer session.timeStep("handler", "handleGetRequest")()
 
 	session.markAlive()
 	logger := session.conf.Logger.WithFields(logrus.Fields{"session": session.token})
@@ -46,6 +47,9 @@ func (session *session) handleGetRequest(min, max *irma.ProtocolVersion) (irma.S
 	if err = session.conf.IrmaConfiguration.Revocation.SetRevocationUpdates(session.request.Base()); err != nil {
 		return nil, session.fail(server.ErrorRevocation, err.Error())
 	}
+	for credid := range session.request.Base().Revocation {
+		session.conf.Metrics.RevocationUpdate(credid, server.RevocationUpdateSession)
+	}
 
 	// Handle legacy clients that do not support condiscon, by attempting to convert the condiscon
 	// session request to the legacy session request format
@@ -92,13 +96,16 @@ func (session *session) handlePostSignature(signature *irma.SignedMessage) (*irm
 	if session.status != server.StatusConnected {
 		return nil, server.RemoteError(server.ErrorUnexpectedRequest, "Session not yet started or already finished")
 	}
+	defer session.timeStep("handler", "handlePostSignature")()
 	session.markAlive()
 
 	var err error
 	var rerr *irma.RemoteError
 	session.result.Signature = signature
+	stopTiming := session.timeStep("verify", "signature verification")
 	session.result.Disclosed, session.result.ProofStatus, err = signature.Verify(
 		session.conf.IrmaConfiguration, session.request.(*irma.SignatureRequest))
+	stopTiming()
 	if err == nil {
 		session.setStatus(server.StatusDone)
 	} else {
@@ -115,12 +122,15 @@ func (session *session) handlePostDisclosure(disclosure *irma.Disclosure) (*irma
 	if session.status != server.StatusConnected {
 		return nil, server.RemoteError(server.ErrorUnexpectedRequest, "Session not yet started or already finished")
 	}
+	defer session.timeStep("handler", "handlePostDisclosure")()
 	session.markAlive()
 
 	var err error
 	var rerr *irma.RemoteError
+	stopTiming := session.timeStep("verify", "disclosure verification")
 	session.result.Disclosed, session.result.ProofStatus, err = disclosure.Verify(
 		session.conf.IrmaConfiguration, session.request.(*irma.DisclosureRequest))
+	stopTiming()
 	if err == nil {
 		session.setStatus(server.StatusDone)
 	} else {
@@ -137,6 +147,7 @@ func (session *session) handlePostCommitments(commitments *irma.IssueCommitmentM
 	if session.status != server.StatusConnected {
 		return nil, server.RemoteError(server.ErrorUnexpectedRequest, "Session not yet started or already finished")
 	}
+	defer session.timeStep("handler", "handlePostCommitments")()
 	session.markAlive()
 
 	request := session.request.(*irma.IssuanceRequest)
@@ -173,9 +184,11 @@ func (session *session) handlePostCommitments(commitments *irma.IssueCommitmentM
 
 	// Verify all proofs and check disclosed attributes, if any, against request
 	now := time.Now()
+	stopTiming := session.timeStep("verify", "commitment verification")
 	session.result.Disclosed, session.result.ProofStatus, err = commitments.Disclosure().VerifyAgainstRequest(
 		session.conf.IrmaConfiguration, request, request.GetContext(), request.GetNonce(nil), pubkeys, &now, false,
 	)
+	stopTiming()
 	if err != nil {
 		if err == irma.ErrMissingPublicKey {
 			return nil, session.fail(server.ErrorUnknownPublicKey, "")
@@ -205,7 +218,9 @@ func (session *session) handlePostCommitments(commitments *irma.IssueCommitmentM
 		if err != nil {
 			return nil, session.fail(server.ErrorIssuanceFailed, err.Error())
 		}
+		stopTiming := session.timeStep("sign", "issuance signatures")
 		sig, err := issuer.IssueSignature(proof.U, attrs, witness, commitments.Nonce2)
+		stopTiming()
 		if err != nil {
 			return nil, session.fail(server.ErrorIssuanceFailed, err.Error())
 		}
@@ -217,48 +232,55 @@ func (session *session) handlePostCommitments(commitments *irma.IssueCommitmentM
 }
 
 func (s *Server) handleSessionCommitments(w http.ResponseWriter, r *http.Request) {
+	binary := server.BinaryRequest(r)
 	commitments := &irma.IssueCommitmentMessage{}
 	bts, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		server.WriteError(w, server.ErrorMalformedInput, err.Error())
+		server.WriteEncodedResponse(w, binary, nil, server.RemoteError(server.ErrorMalformedInput, err.Error()))
 		return
 	}
-	if err := irma.UnmarshalValidate(bts, commitments); err != nil {
-		server.WriteError(w, server.ErrorMalformedInput, err.Error())
+	if err := unmarshalMessage(binary, bts, commitments); err != nil {
+		server.WriteEncodedResponse(w, binary, nil, server.RemoteError(server.ErrorMalformedInput, err.Error()))
 		return
 	}
-	res, rerr := r.Context().Value("session").(*session).handlePostCommitments(commitments)
-	server.WriteResponse(w, res, rerr)
+	session := r.Context().Value("session").(*session)
+	res, rerr := session.handlePostCommitments(commitments)
+	session.writeResponse(w, binary, "postCommitments", res, rerr)
 }
 
 func (s *Server) handleSessionProofs(w http.ResponseWriter, r *http.Request) {
+	binary := server.BinaryRequest(r)
 	bts, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		server.WriteError(w, server.ErrorMalformedInput, err.Error())
+		server.WriteEncodedResponse(w, binary, nil, server.RemoteError(server.ErrorMalformedInput, err.Error()))
 		return
 	}
 	session := r.Context().Value("session").(*session)
 	var res interface{}
 	var rerr *irma.RemoteError
+	var message string
 	switch session.action {
 	case irma.ActionDisclosing:
 		disclosure := &irma.Disclosure{}
-		if err := irma.UnmarshalValidate(bts, disclosure); err != nil {
-			server.WriteError(w, server.ErrorMalformedInput, err.Error())
+		if err := unmarshalMessage(binary, bts, disclosure); err != nil {
+			server.WriteEncodedResponse(w, binary, nil, server.RemoteError(server.ErrorMalformedInput, err.Error()))
 			return
 		}
+		message = "postDisclosure"
 		res, rerr = session.handlePostDisclosure(disclosure)
 	case irma.ActionSigning:
 		signature := &irma.SignedMessage{}
-		if err := irma.UnmarshalValidate(bts, signature); err != nil {
-			server.WriteError(w, server.ErrorMalformedInput, err.Error())
+		if err := unmarshalMessage(binary, bts, signature); err != nil {
+			server.WriteEncodedResponse(w, binary, nil, server.RemoteError(server.ErrorMalformedInput, err.Error()))
 			return
 		}
+		message = "postSignature"
 		res, rerr = session.handlePostSignature(signature)
 	default:
+		message = "postProofs"
 		rerr = server.RemoteError(server.ErrorInvalidRequest, "")
 	}
-	server.WriteResponse(w, res, rerr)
+	session.writeResponse(w, binary, message, res, rerr)
 }
 
 func (s *Server) handleSessionStatus(w http.ResponseWriter, r *http.Request) {
@@ -297,7 +319,7 @@ func (s *Server) handleSessionGet(w http.ResponseWriter, r *http.Request) {
 	}
 	session := r.Context().Value("session").(*session)
 	res, err := session.handleGetRequest(&min, &max)
-	server.WriteResponse(w, res, err)
+	session.writeResponse(w, false, "getRequest", res, err)
 }
 
 func (s *Server) handleStaticMessage(w http.ResponseWriter, r *http.Request) {
@@ -330,6 +352,7 @@ func (s *Server) handleRevocationGetEvents(w http.ResponseWriter, r *http.Reques
 		server.WriteBinaryResponse(w, nil, server.RemoteError(server.ErrorRevocation, err.Error()))
 		return
 	}
+	s.conf.Metrics.RevocationUpdate(cred, server.RevocationUpdateServed)
 	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", irma.RevocationParameters.EventsCacheMaxAge))
 	server.WriteBinaryResponse(w, events, nil)
 }
@@ -370,6 +393,7 @@ func (s *Server) handleRevocationGetUpdateLatest(w http.ResponseWriter, r *http.
 		server.WriteBinaryResponse(w, nil, server.RemoteError(server.ErrorRevocation, err.Error()))
 		return
 	}
+	s.conf.Metrics.RevocationUpdate(cred, server.RevocationUpdateServed)
 	var mintime int64
 	for _, u := range updates {
 		if u.SignedAccumulator.Accumulator.Time < mintime || mintime == 0 {
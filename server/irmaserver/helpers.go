@@ -36,6 +36,9 @@ func (session *session) markAlive() {
 func (session *session) setStatus(status server.Status) {
 	session.conf.Logger.WithFields(logrus.Fields{"session": session.token, "prevStatus": session.prevStatus, "status": status}).
 		Info("Session status updated")
+	if status.Finished() && !session.status.Finished() {
+		session.conf.Metrics.SessionFinished(session.action, status)
+	}
 	session.status = status
 	session.result.Status = status
 	session.sessions.update(session)
@@ -60,6 +63,49 @@ func (session *session) fail(err server.Error, message string) *irma.RemoteError
 	return rerr
 }
 
+// timeStep starts timing a step in handling the current protocol message, and returns a
+// function that records its duration when called.
+func (session *session) timeStep(name, description string) func() {
+	start := time.Now()
+	return func() {
+		session.requestTimings = session.requestTimings.Add(name, description, time.Since(start))
+	}
+}
+
+// writeResponse writes the response to the current protocol message, CBOR-encoded if binary is
+// true. If server timing is enabled, the time spent on it is sent along in the Server-Timing
+// header, and these timings are stored on the session and its result.
+func (session *session) writeResponse(
+	w http.ResponseWriter, binary bool, message string, object interface{}, rerr *irma.RemoteError,
+) {
+	timings := session.requestTimings.Add(irma.ServerTimingTotal, "", time.Since(session.requestStart))
+	session.requestTimings = nil
+	if session.conf.EnableServerTiming {
+		w.Header().Set(irma.ServerTimingHeader, timings.String())
+		// The result may have been handed out already, e.g. by GetSessionResult which does not
+		// lock the session, so it gets a new map rather than seeing this one change
+		updated := make(map[string]irma.ServerTimings, len(session.timings)+1)
+		for m, t := range session.timings {
+			updated[m] = t
+		}
+		updated[message] = timings
+		session.timings = updated
+		if session.result != nil {
+			session.result.Timings = updated
+		}
+	}
+	server.WriteEncodedResponse(w, binary, object, rerr)
+}
+
+// unmarshalMessage decodes and validates a protocol message sent by the client, which is
+// CBOR-encoded if binary is true and JSON otherwise.
+func unmarshalMessage(binary bool, bts []byte, dest interface{}) error {
+	if binary {
+		return irma.UnmarshalValidateBinary(bts, dest)
+	}
+	return irma.UnmarshalValidate(bts, dest)
+}
+
 func (session *session) chooseProtocolVersion(minClient, maxClient *irma.ProtocolVersion) (*irma.ProtocolVersion, error) {
 	// Set minimum supported version to 2.5 if condiscon compatibility is required
 	minServer := minProtocolVersion
@@ -149,7 +195,9 @@ func (session *session) computeAttributes(
 	sk *gabi.PrivateKey, cred *irma.CredentialRequest,
 ) ([]*big.Int, *revocation.Witness, error) {
 	id := cred.CredentialTypeID
+	stopTiming := session.timeStep("computeWitness", "nonrevocation witness")
 	witness, err := session.computeWitness(sk, cred)
+	stopTiming()
 	if err != nil {
 		return nil, nil, err
 	}
@@ -405,6 +453,8 @@ func (s *Server) sessionMiddleware(next http.Handler) http.Handler {
 		ctx := r.Context()
 		session.Lock()
 		session.locked = true
+		session.requestStart = time.Now()
+		server.SetRequestAction(r, session.action)
 		defer func() {
 			if session.prevStatus != session.status {
 				session.prevStatus = session.status
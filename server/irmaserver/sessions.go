@@ -36,6 +36,11 @@ type session struct {
 
 	kssProofs map[irma.SchemeManagerIdentifier]*gabi.ProofP
 
+	// Time spent on the protocol messages handled so far, and on the one currently being handled
+	timings        map[string]irma.ServerTimings
+	requestTimings irma.ServerTimings
+	requestStart   time.Time
+
 	conf     *server.Configuration
 	sessions sessionStore
 }
@@ -53,6 +58,7 @@ type sessionStore interface {
 	add(session *session)
 	update(session *session)
 	deleteExpired()
+	count() map[irma.Action]map[server.Status]int
 	stop()
 }
 
@@ -107,6 +113,22 @@ func (s *memorySessionStore) stop() {
 	}
 }
 
+// count returns the amount of sessions in the store per session type and status.
+func (s *memorySessionStore) count() map[irma.Action]map[server.Status]int {
+	s.RLock()
+	defer s.RUnlock()
+	counts := map[irma.Action]map[server.Status]int{}
+	for _, session := range s.requestor {
+		session.Lock()
+		if counts[session.action] == nil {
+			counts[session.action] = map[server.Status]int{}
+		}
+		counts[session.action][session.status]++
+		session.Unlock()
+	}
+	return counts
+}
+
 func (s *memorySessionStore) deleteExpired() {
 	// First check which sessions have expired
 	// We don't need a write lock for this yet, so postpone that for actual deleting
@@ -0,0 +1,288 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	irma "github.com/markuskreukniet/irmago-measurements"
+)
+
+// OpenMetricsContentType is the content type of the metrics written by Metrics.
+const OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// RequestDurationBuckets are the upper bounds, in seconds, of the buckets of the request latency
+// histogram. They extend to 30 seconds as requests over Tor can take that long.
+var RequestDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+// Metrics collects metrics of an IRMA server, and writes them in the OpenMetrics text format
+// (which Prometheus can scrape). The methods of Metrics may be called on nil, in which case they
+// do nothing, so that servers without metrics need not check whether they are enabled.
+type Metrics struct {
+	mutex sync.Mutex
+
+	requests           map[requestLabels]*histogram
+	sessionOutcomes    map[sessionLabels]uint64
+	revocationUpdates  map[revocationLabels]uint64
+	activeSessions     func() map[irma.Action]map[Status]int
+	schemeUpdateErrors func() uint64
+}
+
+type requestLabels struct {
+	route, method, action string
+}
+
+type sessionLabels struct {
+	action irma.Action
+	status Status
+}
+
+type revocationLabels struct {
+	credential irma.CredentialTypeIdentifier
+	kind       string
+}
+
+// Kinds of revocation updates counted by Metrics.
+const (
+	RevocationUpdateServed     = "served"      // update requested from the revocation endpoints
+	RevocationUpdateSession    = "session"     // update included in a session request for the client
+	RevocationUpdateSyncFailed = "sync_failed" // failed to fetch updates from the revocation server
+)
+
+type histogram struct {
+	counts []uint64 // per bucket of RequestDurationBuckets, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// NewMetrics returns a new Metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests:          map[requestLabels]*histogram{},
+		sessionOutcomes:   map[sessionLabels]uint64{},
+		revocationUpdates: map[revocationLabels]uint64{},
+	}
+}
+
+// ObserveRequest records the duration of a request to the specified route.
+func (m *Metrics) ObserveRequest(route, method string, action irma.Action, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	labels := requestLabels{route: route, method: method, action: string(action)}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	h := m.requests[labels]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(RequestDurationBuckets))}
+		m.requests[labels] = h
+	}
+	seconds := duration.Seconds()
+	for i, bound := range RequestDurationBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// SessionFinished counts a session that reached the specified final status.
+func (m *Metrics) SessionFinished(action irma.Action, status Status) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.sessionOutcomes[sessionLabels{action: action, status: status}]++
+}
+
+// RevocationUpdate counts a revocation update of the specified kind for the credential type.
+func (m *Metrics) RevocationUpdate(credential irma.CredentialTypeIdentifier, kind string) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.revocationUpdates[revocationLabels{credential: credential, kind: kind}]++
+}
+
+// SetActiveSessions sets the function returning the amount of sessions in memory per session
+// type and status, which is called whenever the metrics are written.
+func (m *Metrics) SetActiveSessions(f func() map[irma.Action]map[Status]int) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.activeSessions = f
+}
+
+// SetSchemeUpdateFailures sets the function returning the amount of failed scheme updates, which
+// is called whenever the metrics are written.
+func (m *Metrics) SetSchemeUpdateFailures(f func() uint64) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.schemeUpdateErrors = f
+}
+
+// requestMetricsKey is the context key of the action of the session that a request is about.
+type requestMetricsKey struct{}
+
+// SetRequestAction records the type of the session that the request is about, so that its
+// duration is recorded under that session type by the MetricsMiddleware.
+func SetRequestAction(r *http.Request, action irma.Action) {
+	if a, ok := r.Context().Value(requestMetricsKey{}).(*irma.Action); ok {
+		*a = action
+	}
+}
+
+// MetricsMiddleware records the duration of each request per route; the route is determined
+// using the specified function after the request has been handled.
+func (m *Metrics) MetricsMiddleware(route func(r *http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if m == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			start := time.Now()
+			action := new(irma.Action)
+			r = r.WithContext(context.WithValue(r.Context(), requestMetricsKey{}, action))
+			next.ServeHTTP(w, r)
+			m.ObserveRequest(route(r), r.Method, *action, time.Since(start))
+		})
+	}
+}
+
+// WriteTo writes the metrics in the OpenMetrics text format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	if m == nil {
+		n, err := io.WriteString(w, "# EOF\n")
+		return int64(n), err
+	}
+	// The functions providing the gauges take locks of their own, so call them unlocked
+	m.mutex.Lock()
+	activeSessions, schemeUpdateErrors := m.activeSessions, m.schemeUpdateErrors
+	m.mutex.Unlock()
+	var sessions map[irma.Action]map[Status]int
+	if activeSessions != nil {
+		sessions = activeSessions()
+	}
+
+	var b strings.Builder
+	m.mutex.Lock()
+	m.writeRequests(&b)
+	m.writeSessions(&b, sessions)
+	m.writeRevocationUpdates(&b)
+	m.mutex.Unlock()
+	if schemeUpdateErrors != nil {
+		writeFamily(&b, "irma_scheme_update_failures", "counter", "Failed scheme updates.")
+		fmt.Fprintf(&b, "irma_scheme_update_failures_total %d\n", schemeUpdateErrors())
+	}
+
+	b.WriteString("# EOF\n")
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// ServeHTTP writes the metrics as response.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", OpenMetricsContentType)
+	_, _ = m.WriteTo(w)
+}
+
+func (m *Metrics) writeRequests(b *strings.Builder) {
+	writeFamily(b, "irma_request_duration_seconds", "histogram", "Duration of handling requests per route and session type.")
+	keys := make([]requestLabels, 0, len(m.requests))
+	for labels := range m.requests {
+		keys = append(keys, labels)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].action < keys[j].action
+	})
+	for _, labels := range keys {
+		h := m.requests[labels]
+		l := formatLabels("route", labels.route, "method", labels.method, "action", labels.action)
+		var cumulative uint64
+		for i, bound := range RequestDurationBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(b, "irma_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", l, formatFloat(bound), cumulative)
+		}
+		fmt.Fprintf(b, "irma_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", l, h.count)
+		fmt.Fprintf(b, "irma_request_duration_seconds_sum{%s} %s\n", l, formatFloat(h.sum))
+		fmt.Fprintf(b, "irma_request_duration_seconds_count{%s} %d\n", l, h.count)
+	}
+}
+
+func (m *Metrics) writeSessions(b *strings.Builder, active map[irma.Action]map[Status]int) {
+	if active != nil {
+		writeFamily(b, "irma_sessions_active", "gauge", "Sessions in memory per session type and status.")
+		var lines []string
+		for action, statuses := range active {
+			for status, count := range statuses {
+				lines = append(lines, fmt.Sprintf("irma_sessions_active{%s} %d\n",
+					formatLabels("action", string(action), "status", string(status)), count))
+			}
+		}
+		sort.Strings(lines)
+		b.WriteString(strings.Join(lines, ""))
+	}
+
+	writeFamily(b, "irma_sessions_finished", "counter", "Finished sessions per session type and outcome.")
+	var lines []string
+	for labels, count := range m.sessionOutcomes {
+		lines = append(lines, fmt.Sprintf("irma_sessions_finished_total{%s} %d\n",
+			formatLabels("action", string(labels.action), "status", string(labels.status)), count))
+	}
+	sort.Strings(lines)
+	b.WriteString(strings.Join(lines, ""))
+}
+
+func (m *Metrics) writeRevocationUpdates(b *strings.Builder) {
+	writeFamily(b, "irma_revocation_updates", "counter", "Revocation updates per credential type and kind.")
+	var lines []string
+	for labels, count := range m.revocationUpdates {
+		lines = append(lines, fmt.Sprintf("irma_revocation_updates_total{%s} %d\n",
+			formatLabels("credential", labels.credential.String(), "kind", labels.kind), count))
+	}
+	sort.Strings(lines)
+	b.WriteString(strings.Join(lines, ""))
+}
+
+func writeFamily(b *strings.Builder, name, typ, help string) {
+	fmt.Fprintf(b, "# TYPE %s %s\n# HELP %s %s\n", name, typ, name, help)
+}
+
+// formatLabels formats the specified label name/value pairs.
+func formatLabels(pairs ...string) string {
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	labels := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		labels = append(labels, fmt.Sprintf(`%s="%s"`, pairs[i], escaper.Replace(pairs[i+1])))
+	}
+	return strings.Join(labels, ",")
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return fmt.Sprintf("%g", f)
+}
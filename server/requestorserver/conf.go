@@ -55,6 +55,16 @@ type Configuration struct {
 	StaticPath string `json:"static_path" mapstructure:"static_path"`
 	// Host static files under this URL prefix
 	StaticPrefix string `json:"static_prefix" mapstructure:"static_prefix"`
+
+	// Expose metrics in the OpenMetrics format (which Prometheus can scrape) at /metrics
+	EnableMetrics bool `json:"metrics" mapstructure:"metrics"`
+	// If specified, requests to /metrics must include this token in the Authorization header
+	MetricsToken string `json:"metrics_token" mapstructure:"metrics_token"`
+
+	// Emulate these network conditions on the connections to the server, for measurements (see
+	// irma.ParseNetworkShape). Not allowed in production mode.
+	NetworkShape string `json:"network_shape" mapstructure:"network_shape"`
+	networkShape *irma.NetworkShape
 }
 
 // Permissions specify which attributes or credential a requestor may verify or issue.
@@ -256,6 +266,24 @@ func (conf *Configuration) initialize() error {
 		}
 	}
 
+	if conf.EnableMetrics && conf.MetricsToken == "" {
+		if conf.Production {
+			return errors.New("If metrics are enabled in production mode, metrics_token must be specified")
+		}
+		conf.Logger.Warn("Metrics enabled without metrics_token: anyone who can reach this server can read them")
+	}
+
+	if conf.NetworkShape != "" {
+		if conf.Production {
+			return errors.New("network_shape must not be used in production mode")
+		}
+		var err error
+		if conf.networkShape, err = irma.ParseNetworkShape(conf.NetworkShape); err != nil {
+			return err
+		}
+		conf.Logger.Warn("Emulating network shape ", conf.networkShape.String(), " on all connections")
+	}
+
 	if len(conf.StaticSessions) != 0 && conf.JwtRSAPrivateKey == nil {
 		conf.Logger.Warn("Static sessions enabled and no JWT private key installed. Ensure that POSTs to the callback URLs of static sessions are trustworthy by keeping the callback URLs secret and by using HTTPS.")
 	}
@@ -6,12 +6,14 @@ package requestorserver
 
 import (
 	"context"
+	"crypto/subtle"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"time"
 
@@ -115,11 +117,19 @@ func (s *Server) startServer(handler http.Handler, name, addr string, port int,
 		s.stopped <- struct{}{}
 	}()
 
+	listener, err := net.Listen("tcp", fulladdr)
+	if err != nil {
+		return err
+	}
+	if s.conf.networkShape != nil {
+		listener = s.conf.networkShape.Listener(listener)
+	}
+
 	if tlsConf != nil {
 		s.conf.Logger.Info(name, " TLS enabled")
-		return filterStopError(serv.ListenAndServeTLS("", ""))
+		return filterStopError(serv.ServeTLS(listener, "", ""))
 	} else {
-		return filterStopError(serv.ListenAndServe())
+		return filterStopError(serv.Serve(listener))
 	}
 }
 
@@ -140,6 +150,9 @@ func (s *Server) Stop() {
 }
 
 func New(config *Configuration) (*Server, error) {
+	if config.EnableMetrics && config.Metrics == nil {
+		config.Metrics = server.NewMetrics()
+	}
 	irmaserv, err := irmaserver.New(config.Configuration)
 	if err != nil {
 		return nil, err
@@ -161,6 +174,7 @@ var corsOptions = cors.Options{
 
 func (s *Server) ClientHandler() http.Handler {
 	router := chi.NewRouter()
+	router.Use(s.conf.Metrics.MetricsMiddleware(routePattern))
 	router.Use(cors.New(corsOptions).Handler)
 	s.attachClientEndpoints(router)
 	return router
@@ -177,6 +191,7 @@ func (s *Server) attachClientEndpoints(router *chi.Mux) {
 // and IRMA client messages.
 func (s *Server) Handler() http.Handler {
 	router := chi.NewRouter()
+	router.Use(s.conf.Metrics.MetricsMiddleware(routePattern))
 	router.Use(cors.New(corsOptions).Handler)
 
 	if !s.conf.separateClientServer() {
@@ -224,9 +239,21 @@ func (s *Server) Handler() http.Handler {
 		r.Post("/revocation", s.handleRevocation)
 	})
 
+	if s.conf.EnableMetrics {
+		router.Get("/metrics", s.handleMetrics)
+	}
+
 	return router
 }
 
+// routePattern returns the route of the request for the metrics, e.g. /irma/session/{token}/proofs.
+func routePattern(r *http.Request) string {
+	if rctx, ok := r.Context().Value(chi.RouteCtxKey).(*chi.Context); ok && rctx.RoutePattern() != "" {
+		return rctx.RoutePattern()
+	}
+	return "unmatched"
+}
+
 func (s *Server) StaticFilesHandler() http.Handler {
 	if len(s.conf.URL) > 6 {
 		url := s.conf.URL[:len(s.conf.URL)-6] + s.conf.StaticPrefix
@@ -434,6 +461,15 @@ func (s *Server) handleJwtProofs(w http.ResponseWriter, r *http.Request) {
 	server.WriteString(w, resultJwt)
 }
 
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if s.conf.MetricsToken != "" &&
+		subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(s.conf.MetricsToken)) != 1 {
+		server.WriteError(w, server.ErrorUnauthorized, "")
+		return
+	}
+	s.conf.Metrics.ServeHTTP(w, r)
+}
+
 func (s *Server) handlePublicKey(w http.ResponseWriter, r *http.Request) {
 	if s.conf.JwtRSAPrivateKey == nil {
 		server.WriteError(w, server.ErrorUnsupported, "")
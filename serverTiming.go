@@ -0,0 +1,113 @@
+package irma
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ServerTiming is the duration of a step taken by the server in handling a request, as sent to
+// the client in the Server-Timing header.
+type ServerTiming struct {
+	Name        string        `json:"name"`
+	Description string        `json:"description,omitempty"`
+	Duration    time.Duration `json:"durationNs"`
+}
+
+// ServerTimings is a list of server timings, in the order in which they are sent.
+type ServerTimings []*ServerTiming
+
+// ServerTimingTotal is the name of the server timing containing the total time spent by the server
+// on a request.
+const ServerTimingTotal = "total"
+
+// Add returns the timings with the duration added to the timing with the specified name, which is
+// appended if not yet present.
+func (timings ServerTimings) Add(name, description string, duration time.Duration) ServerTimings {
+	for _, timing := range timings {
+		if timing.Name == name {
+			timing.Duration += duration
+			return timings
+		}
+	}
+	return append(timings, &ServerTiming{Name: name, Description: description, Duration: duration})
+}
+
+// Get returns the duration of the timing with the specified name, and whether it is present.
+func (timings ServerTimings) Get(name string) (time.Duration, bool) {
+	for _, timing := range timings {
+		if timing.Name == name {
+			return timing.Duration, true
+		}
+	}
+	return 0, false
+}
+
+// String formats the timings as the value of a Server-Timing header, with durations in milliseconds.
+func (timings ServerTimings) String() string {
+	metrics := make([]string, 0, len(timings))
+	for _, timing := range timings {
+		metric := timing.Name
+		if timing.Description != "" {
+			metric += ";desc=" + strconv.Quote(timing.Description)
+		}
+		ms := float64(timing.Duration) / float64(time.Millisecond)
+		metric += ";dur=" + strconv.FormatFloat(ms, 'f', 3, 64)
+		metrics = append(metrics, metric)
+	}
+	return strings.Join(metrics, ", ")
+}
+
+// ParseServerTimings parses the value of a Server-Timing header. As prescribed by the
+// specification, metrics and parameters that cannot be parsed are ignored.
+func ParseServerTimings(header string) ServerTimings {
+	var timings ServerTimings
+	for _, metric := range splitQuoted(header, ',') {
+		params := splitQuoted(metric, ';')
+		timing := &ServerTiming{Name: strings.TrimSpace(params[0])}
+		if timing.Name == "" {
+			continue
+		}
+		for _, param := range params[1:] {
+			parts := strings.SplitN(param, "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			val := strings.TrimSpace(parts[1])
+			switch strings.ToLower(strings.TrimSpace(parts[0])) {
+			case "dur":
+				if ms, err := strconv.ParseFloat(val, 64); err == nil {
+					timing.Duration = time.Duration(ms * float64(time.Millisecond))
+				}
+			case "desc":
+				if unquoted, err := strconv.Unquote(val); err == nil {
+					val = unquoted
+				}
+				timing.Description = val
+			}
+		}
+		timings = append(timings, timing)
+	}
+	return timings
+}
+
+// splitQuoted splits s at each occurrence of sep that is not within double quotes.
+func splitQuoted(s string, sep rune) []string {
+	var parts []string
+	var quoted, escaped bool
+	start := 0
+	for i, c := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && quoted:
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case c == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
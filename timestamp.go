@@ -1,9 +1,14 @@
 package irma
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"encoding/asn1"
+	"encoding/json"
 	gobig "math/big"
+	"net/http"
+	"strings"
+	"time"
 
 	"github.com/bwesterb/go-atum"
 	"github.com/go-errors/errors"
@@ -26,6 +31,69 @@ func GetTimestamp(message string, sigs []*big.Int, disclosed [][]*big.Int, conf
 	})
 }
 
+// GetTimestampUsing is like GetTimestamp, but sends the request to the timestamp server using the
+// specified HTTP client, e.g. so that it is sent over Tor. If httpClient is nil, it is GetTimestamp.
+func GetTimestampUsing(
+	httpClient *http.Client, message string, sigs []*big.Int, disclosed [][]*big.Int, conf *Configuration,
+) (*atum.Timestamp, error) {
+	if httpClient == nil {
+		return GetTimestamp(message, sigs, disclosed, conf)
+	}
+	nonce, timestampServerUrl, err := TimestampRequest(message, sigs, disclosed, true, conf)
+	if err != nil {
+		return nil, err
+	}
+	alg := atum.Ed25519
+	return sendTimestampRequest(httpClient, timestampServerUrl, atum.Request{
+		Nonce:           nonce,
+		PreferredSigAlg: &alg,
+	})
+}
+
+// sendTimestampRequest sends the request to the timestamp server as atum.SendRequest does, which
+// always uses http.DefaultClient. If the server requires a proof of work, it is computed using the
+// server information included in the response, after which the request is sent once more.
+func sendTimestampRequest(httpClient *http.Client, url string, req atum.Request) (*atum.Timestamp, error) {
+	if !strings.HasSuffix(url, "/") {
+		url += "/"
+	}
+	for retry := true; ; retry = false {
+		bts, err := json.Marshal(req)
+		if err != nil {
+			return nil, err
+		}
+		res, err := httpClient.Post(url, "application/json", bytes.NewReader(bts))
+		if err != nil {
+			return nil, errors.WrapPrefix(err, "failed to send timestamp request", 0)
+		}
+		var resp atum.Response
+		err = json.NewDecoder(res.Body).Decode(&resp)
+		_ = res.Body.Close()
+		if err != nil {
+			return nil, errors.WrapPrefix(err, "failed to parse timestamp response", 0)
+		}
+		if resp.Error == nil {
+			return resp.Stamp, nil
+		}
+
+		code := *resp.Error
+		if !retry || resp.Info == nil || (code != atum.ErrorMissingPow && code != atum.ErrorPowInvalid) {
+			return nil, errors.Errorf("timestamp server reported error: %s", code)
+		}
+		alg := resp.Info.DefaultSigAlg
+		if req.PreferredSigAlg != nil {
+			alg = *req.PreferredSigAlg
+		}
+		powReq, ok := resp.Info.RequiredProofOfWork[alg]
+		if !ok {
+			return nil, errors.Errorf("timestamp server reported error: %s", code)
+		}
+		now := time.Now().Unix()
+		proof := powReq.Fulfil(atum.EncodeTimeNonce(now, req.Nonce))
+		req.Time, req.ProofOfWork = &now, &proof
+	}
+}
+
 // TimestampRequest computes the nonce to be signed by a timestamp server, given a message to be signed
 // in an attribute-based signature session along with the randomized signatures over the attributes
 // and the disclosed attributes. The url of the timestamp server that should be used to validate the
@@ -30,10 +30,20 @@ type HTTPTransport struct {
 	Server     string
 	Binary     bool
 	ForceHTTPS bool
+	// TimingSink, if set, receives the timing of each request
+	TimingSink RequestTimingSink
 	client     *retryablehttp.Client
 	headers    http.Header
 }
 
+// MessageEncoding is the encoding of the messages sent and received by an HTTPTransport.
+type MessageEncoding string
+
+const (
+	EncodingJSON = MessageEncoding("json")
+	EncodingCBOR = MessageEncoding("cbor")
+)
+
 var HTTPHeaders = map[string]http.Header{}
 
 // Logger is used for logging. If not set, init() will initialize it to logrus.StandardLogger().
@@ -85,11 +95,13 @@ func NewHTTPTransport(serverURL string, forceHTTPS bool, httpClients ...*http.Cl
 	}
 
 	client := &retryablehttp.Client{
-		Logger:       transportlogger,
-		RetryWaitMin: 100 * time.Millisecond,
-		RetryWaitMax: 200 * time.Millisecond,
-		RetryMax:     2,
-		Backoff:      retryablehttp.DefaultBackoff,
+		Logger:         transportlogger,
+		RetryWaitMin:   100 * time.Millisecond,
+		RetryWaitMax:   200 * time.Millisecond,
+		RetryMax:       2,
+		Backoff:        retryablehttp.DefaultBackoff,
+		RequestLogHook: retryRequestLogHook,
+		ErrorHandler:   retryErrorHandler,
 		CheckRetry: func(ctx context.Context, resp *http.Response, err error) (bool, error) {
 			// Don't retry on 5xx (which retryablehttp does by default)
 			return err != nil || resp.StatusCode == 0, err
@@ -124,6 +136,14 @@ func NewHTTPTransport(serverURL string, forceHTTPS bool, httpClients ...*http.Cl
 	}
 }
 
+// Encoding returns the encoding of the messages sent and received by the transport.
+func (transport *HTTPTransport) Encoding() MessageEncoding {
+	if transport.Binary {
+		return EncodingCBOR
+	}
+	return EncodingJSON
+}
+
 func (transport *HTTPTransport) marshal(o interface{}) ([]byte, error) {
 	if transport.Binary {
 		return MarshalBinary(o)
@@ -174,26 +194,54 @@ func (transport *HTTPTransport) SetHeader(name, val string) {
 
 func (transport *HTTPTransport) request(
 	url string, method string, reader io.Reader, contenttype string,
-) (response *http.Response, err error) {
+) (response *http.Response, tracer *requestTracer, err error) {
 	var req retryablehttp.Request
 	u := transport.Server + url
 	if common.ForceHTTPS && transport.ForceHTTPS && !strings.HasPrefix(u, "https") {
-		return nil, &SessionError{ErrorType: ErrorHTTPS, Err: errors.New("remote server does not use https")}
+		return nil, nil, &SessionError{ErrorType: ErrorHTTPS, Err: errors.New("remote server does not use https")}
 	}
 	req.Request, err = http.NewRequest(method, u, reader)
 	if err != nil {
-		return nil, &SessionError{ErrorType: ErrorTransport, Err: err}
+		return nil, nil, &SessionError{ErrorType: ErrorTransport, Err: err}
 	}
 	req.Header = transport.headers.Clone()
 	req.Header.Set("User-Agent", "irmago")
 	if reader != nil && contenttype != "" {
 		req.Header.Set("Content-Type", contenttype)
 	}
+	if transport.TimingSink != nil {
+		var size int64
+		if r, ok := reader.(interface{ Len() int }); ok {
+			size = int64(r.Len())
+		}
+		req.Header.Set(RequestIDHeader, common.NewSessionToken())
+		tracer = newRequestTracer(req.Request, messageTypeOf(method, url), transport.Encoding(), size)
+		req.Request = req.Request.WithContext(tracer.withContext(req.Request.Context()))
+	}
 	res, err := transport.client.Do(&req)
 	if err != nil {
-		return nil, &SessionError{ErrorType: ErrorTransport, Err: err}
+		transport.addTiming(tracer, nil, 0, 0, err)
+		return nil, nil, &SessionError{ErrorType: ErrorTransport, Err: err}
 	}
-	return res, nil
+	return res, tracer, nil
+}
+
+// readBody reads the body of the response, and passes the timing of the request to the
+// TimingSink if it is traced.
+func (transport *HTTPTransport) readBody(res *http.Response, tracer *requestTracer) ([]byte, error) {
+	start := time.Now()
+	body, err := ioutil.ReadAll(res.Body)
+	transport.addTiming(tracer, res, time.Since(start), int64(len(body)), err)
+	return body, err
+}
+
+func (transport *HTTPTransport) addTiming(
+	tracer *requestTracer, res *http.Response, bodyRead time.Duration, size int64, err error,
+) {
+	if tracer == nil {
+		return
+	}
+	transport.TimingSink.AddRequestTiming(tracer.finish(res, bodyRead, size, err))
 }
 
 func (transport *HTTPTransport) jsonRequest(url string, method string, result interface{}, object interface{}) error {
@@ -231,21 +279,27 @@ func (transport *HTTPTransport) jsonRequest(url string, method string, result in
 		}
 	}
 
-	res, err := transport.request(url, method, reader, contenttype)
+	res, tracer, err := transport.request(url, method, reader, contenttype)
 	if err != nil {
 		return err
 	}
 	if method == http.MethodDelete {
+		transport.addTiming(tracer, res, 0, 0, nil)
 		return nil
 	}
 
-	body, err := ioutil.ReadAll(res.Body)
+	body, err := transport.readBody(res, tracer)
 	if err != nil {
 		return &SessionError{ErrorType: ErrorServerResponse, Err: err, RemoteStatus: res.StatusCode}
 	}
 	if res.StatusCode != 200 {
 		apierr := &RemoteError{}
-		err = transport.unmarshal(body, apierr)
+		if strings.HasPrefix(res.Header.Get("Content-Type"), "application/json") {
+			// Servers may report errors as JSON even if they are asked for binary messages
+			err = json.Unmarshal(body, apierr)
+		} else {
+			err = transport.unmarshal(body, apierr)
+		}
 		if err != nil || apierr.ErrorName == "" { // Not an ApiErrorMessage
 			return &SessionError{ErrorType: ErrorServerResponse, Err: err, RemoteStatus: res.StatusCode}
 		}
@@ -270,15 +324,16 @@ func (transport *HTTPTransport) jsonRequest(url string, method string, result in
 }
 
 func (transport *HTTPTransport) GetBytes(url string) ([]byte, error) {
-	res, err := transport.request(url, http.MethodGet, nil, "")
+	res, tracer, err := transport.request(url, http.MethodGet, nil, "")
 	if err != nil {
 		return nil, &SessionError{ErrorType: ErrorTransport, Err: err}
 	}
 
 	if res.StatusCode != 200 {
+		transport.addTiming(tracer, res, 0, 0, nil)
 		return nil, &SessionError{ErrorType: ErrorServerResponse, RemoteStatus: res.StatusCode}
 	}
-	b, err := ioutil.ReadAll(res.Body)
+	b, err := transport.readBody(res, tracer)
 	if err != nil {
 		return nil, &SessionError{ErrorType: ErrorServerResponse, Err: err, RemoteStatus: res.StatusCode}
 	}
@@ -0,0 +1,238 @@
+package irma
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// RequestTiming is the breakdown of the time spent in a single request of an HTTPTransport into
+// the phases of the request, as measured with net/http/httptrace. Phases that did not occur, such
+// as DNS, dial and TLS handshake when a connection was reused, are zero. When the request was
+// retried, the phases are those of the last attempt.
+type RequestTiming struct {
+	RequestID string    `json:"requestId"` // as sent in the X-IRMA-RequestID header
+	Start     time.Time `json:"start"`
+	Method    string    `json:"method"`
+	URL       string    `json:"url"`
+
+	DNS             time.Duration `json:"dnsNs"`
+	Dial            time.Duration `json:"dialNs"`
+	TLSHandshake    time.Duration `json:"tlsHandshakeNs"`
+	RequestWrite    time.Duration `json:"requestWriteNs"` // from obtaining a connection until the request is written
+	TimeToFirstByte time.Duration `json:"timeToFirstByteNs"`
+	BodyRead        time.Duration `json:"bodyReadNs"`
+	Total           time.Duration `json:"totalNs"`
+
+	ConnectionReused bool            `json:"connectionReused"`
+	MessageType      MessageType     `json:"messageType,omitempty"`
+	Encoding         MessageEncoding `json:"messageEncoding,omitempty"`
+	RequestSize      int64           `json:"requestSize"`  // size of the request body in bytes
+	ResponseSize     int64           `json:"responseSize"` // size of the response body in bytes
+	Retries          int             `json:"retries"`
+	StatusCode       int             `json:"statusCode,omitempty"`
+	Error            string          `json:"error,omitempty"`
+
+	// ServerTimings contains the steps taken by the server, if it sent a Server-Timing header
+	ServerTimings ServerTimings `json:"serverTimings,omitempty"`
+}
+
+// MessageType is the type of the protocol message exchanged in a request, by which the amount of
+// bytes sent and received during sessions is accounted.
+type MessageType string
+
+const (
+	MessageSessionRequest      = MessageType("sessionRequest")
+	MessageCommitments         = MessageType("commitments")
+	MessageProofs              = MessageType("proofs")
+	MessageStatus              = MessageType("status")
+	MessageCancel              = MessageType("cancel")
+	MessageKeysharePin         = MessageType("keysharePin")
+	MessageKeyshareCommitments = MessageType("keyshareCommitments")
+	MessageKeyshareResponse    = MessageType("keyshareResponse")
+	MessageKeyshareAccount     = MessageType("keyshareAccount")
+	MessageRevocationUpdate    = MessageType("revocationUpdate")
+	MessageRevocation          = MessageType("revocation")
+	MessageOther               = MessageType("other")
+)
+
+// messageTypeOrder contains the message types in the order in which they are reported.
+var messageTypeOrder = []MessageType{
+	MessageSessionRequest, MessageCommitments, MessageProofs, MessageStatus, MessageCancel,
+	MessageKeysharePin, MessageKeyshareCommitments, MessageKeyshareResponse, MessageKeyshareAccount,
+	MessageRevocationUpdate, MessageRevocation, MessageOther,
+}
+
+// messageTypes maps the paths, relative to the server URL of the transport, to which protocol
+// messages are sent to the type of these messages.
+var messageTypes = map[string]MessageType{
+	"":                     MessageSessionRequest,
+	"commitments":          MessageCommitments,
+	"proofs":               MessageProofs,
+	"status":               MessageStatus,
+	"statusevents":         MessageStatus,
+	"users/verify/pin":     MessageKeysharePin,
+	"prove/getCommitments": MessageKeyshareCommitments,
+	"prove/getResponse":    MessageKeyshareResponse,
+	"client/register":      MessageKeyshareAccount,
+	"users/change/pin":     MessageKeyshareAccount,
+}
+
+// messageTypeOf returns the type of the message sent with the specified method to the specified
+// path relative to the server URL of the transport.
+func messageTypeOf(method, path string) MessageType {
+	if method == http.MethodDelete {
+		return MessageCancel
+	}
+	if typ, ok := messageTypes[path]; ok {
+		return typ
+	}
+	if strings.HasPrefix(path, "/revocation/") {
+		if strings.Contains(path, "/update/") {
+			return MessageRevocationUpdate
+		}
+		return MessageRevocation
+	}
+	return MessageOther
+}
+
+// RequestTimingSink receives the timing of each request of the HTTPTransports it is set on.
+type RequestTimingSink interface {
+	AddRequestTiming(timing *RequestTiming)
+}
+
+// ServerTime returns the total time the server reported to have spent on the request, or zero if
+// it did not report it.
+func (timing *RequestTiming) ServerTime() time.Duration {
+	if timing == nil {
+		return 0
+	}
+	total, _ := timing.ServerTimings.Get(ServerTimingTotal)
+	return total
+}
+
+type requestTracerKey struct{}
+
+// requestTracer collects the timing of a request from the httptrace callbacks, which may be
+// invoked concurrently.
+type requestTracer struct {
+	timing *RequestTiming
+	mutex  sync.Mutex
+
+	dnsStart, connectStart, tlsStart, gotConn, wroteRequest time.Time
+}
+
+func newRequestTracer(req *http.Request, typ MessageType, encoding MessageEncoding, size int64) *requestTracer {
+	return &requestTracer{timing: &RequestTiming{
+		RequestID:   req.Header.Get(RequestIDHeader),
+		Start:       time.Now(),
+		Method:      req.Method,
+		URL:         req.URL.String(),
+		MessageType: typ,
+		Encoding:    encoding,
+		RequestSize: size,
+	}}
+}
+
+// withContext returns the context of the request, to which the tracer is attached.
+func (tracer *requestTracer) withContext(ctx context.Context) context.Context {
+	ctx = context.WithValue(ctx, requestTracerKey{}, tracer)
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			tracer.update(func(now time.Time) { tracer.dnsStart = now })
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			tracer.update(func(now time.Time) { tracer.timing.DNS = now.Sub(tracer.dnsStart) })
+		},
+		ConnectStart: func(string, string) {
+			tracer.update(func(now time.Time) { tracer.connectStart = now })
+		},
+		ConnectDone: func(string, string, error) {
+			tracer.update(func(now time.Time) { tracer.timing.Dial = now.Sub(tracer.connectStart) })
+		},
+		TLSHandshakeStart: func() {
+			tracer.update(func(now time.Time) { tracer.tlsStart = now })
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			tracer.update(func(now time.Time) { tracer.timing.TLSHandshake = now.Sub(tracer.tlsStart) })
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			tracer.update(func(now time.Time) {
+				tracer.gotConn = now
+				tracer.timing.ConnectionReused = info.Reused
+			})
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			tracer.update(func(now time.Time) {
+				tracer.wroteRequest = now
+				tracer.timing.RequestWrite = now.Sub(tracer.gotConn)
+			})
+		},
+		GotFirstResponseByte: func() {
+			tracer.update(func(now time.Time) { tracer.timing.TimeToFirstByte = now.Sub(tracer.wroteRequest) })
+		},
+	})
+}
+
+func (tracer *requestTracer) update(f func(now time.Time)) {
+	now := time.Now()
+	tracer.mutex.Lock()
+	defer tracer.mutex.Unlock()
+	f(now)
+}
+
+// attempt is called before each attempt of the request; attempt is 0 for the initial request.
+func (tracer *requestTracer) attempt(attempt int) {
+	tracer.mutex.Lock()
+	defer tracer.mutex.Unlock()
+	tracer.timing.Retries = attempt
+	if attempt > 0 {
+		// Only keep the phases of the last attempt
+		tracer.timing.DNS, tracer.timing.Dial, tracer.timing.TLSHandshake = 0, 0, 0
+		tracer.timing.RequestWrite, tracer.timing.TimeToFirstByte = 0, 0
+	}
+}
+
+// finish completes the timing of the request after its response body has been read.
+func (tracer *requestTracer) finish(res *http.Response, bodyRead time.Duration, size int64, err error) *RequestTiming {
+	tracer.mutex.Lock()
+	defer tracer.mutex.Unlock()
+	timing := tracer.timing
+	timing.Total = time.Since(timing.Start)
+	timing.BodyRead = bodyRead
+	timing.ResponseSize = size
+	if res != nil {
+		timing.StatusCode = res.StatusCode
+		timing.ServerTimings = ParseServerTimings(res.Header.Get(ServerTimingHeader))
+	}
+	if err != nil {
+		timing.Error = err.Error()
+	}
+	return timing
+}
+
+// retryRequestLogHook informs the tracer of a request, if any, of each attempt of the request.
+func retryRequestLogHook(_ retryablehttp.Logger, req *http.Request, attempt int) {
+	if tracer, ok := req.Context().Value(requestTracerKey{}).(*requestTracer); ok {
+		tracer.attempt(attempt)
+	}
+}
+
+// retryErrorHandler is called when all attempts of a request failed. Unlike the default of
+// retryablehttp it wraps the error of the last attempt, so that e.g. timeouts can be recognized.
+func retryErrorHandler(res *http.Response, err error, attempts int) (*http.Response, error) {
+	if res != nil {
+		_ = res.Body.Close()
+	}
+	if err == nil {
+		return nil, fmt.Errorf("giving up after %d attempts", attempts)
+	}
+	return nil, fmt.Errorf("giving up after %d attempts: %w", attempts, err)
+}